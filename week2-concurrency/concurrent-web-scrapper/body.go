@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// pageBody is what is left of a body once it has streamed past
+type pageBody struct {
+	length  int
+	hash    string
+	links   []string
+	records []Record
+}
+
+// readBody streams the body through the hash and the link extractor, it is
+// only held in memory when the extractor has a rule for the page
+//
+// The Content-Type is checked and the declared length is held against
+// ScrapperConfig.MaxBodyBytes before anything is read.
+func (sc *Scrapper) readBody(rawURL string, resp *http.Response) (pageBody, error) {
+	var page pageBody
+
+	if mediaType, ok := wantedType(sc.contentTypes, resp); !ok {
+		return page, fmt.Errorf("%w %q", ErrUnwantedContentType, mediaType)
+	}
+	if sc.maxBody > 0 && resp.ContentLength > sc.maxBody {
+		return page, ErrBodyTooLarge
+	}
+
+	var body io.Reader = resp.Body
+	if sc.maxBody > 0 {
+		body = &limitReader{r: body, left: sc.maxBody}
+	}
+	hash := sha256.New()
+	var length byteCounter
+	body = io.TeeReader(body, io.MultiWriter(hash, &length))
+
+	crawl := sc.crawling.Load() && isHTML(resp)
+	keep := sc.extractor != nil && sc.extractor.matches(rawURL)
+
+	copies := int64(0)
+	if keep {
+		copies++
+	}
+	if cacheStores(resp) {
+		copies++
+	}
+	release, err := sc.memory.reserve(sc.ctx, copies, sc.expectedSize(resp))
+	if err != nil {
+		return page, err
+	}
+	defer release()
+
+	// relative links are relative to where any redirects ended up
+	switch {
+	case keep:
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return page, err
+		}
+		if crawl {
+			page.links = extractLinks(resp.Request.URL, bytes.NewReader(data))
+		}
+		page.records = sc.extractor.Extract(rawURL, data)
+	case crawl:
+		page.links = extractLinks(resp.Request.URL, body)
+	}
+
+	// whatever the tokenizer left, and the read error that stopped it
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return page, err
+	}
+	page.length = int(length)
+	page.hash = hex.EncodeToString(hash.Sum(nil))
+	return page, nil
+}
+
+// expectedSize is how much of the memory budget a copy of the body takes,
+// -1 when it can't be told
+func (sc *Scrapper) expectedSize(resp *http.Response) int64 {
+	switch {
+	case resp.ContentLength >= 0:
+		return resp.ContentLength
+	case sc.maxBody > 0:
+		return sc.maxBody
+	}
+	return -1
+}
+
+// wantedType reports whether the response's media type is one of types,
+// which may end in a wildcard like "text/*", no types wants everything
+func wantedType(types []string, resp *http.Response) (string, bool) {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		// RFC 9110 8.3, a missing or invalid type is just bytes
+		mediaType = "application/octet-stream"
+	}
+	if len(types) == 0 {
+		return mediaType, true
+	}
+
+	for _, t := range types {
+		if t == mediaType || t == "*/*" {
+			return mediaType, true
+		}
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return mediaType, true
+		}
+	}
+	return mediaType, false
+}
+
+// limitReader fails with ErrBodyTooLarge once more than left bytes come
+// through
+type limitReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.left < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	// one byte more than allowed tells a body of exactly the limit apart
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+
+	n, err := l.r.Read(p)
+	if int64(n) > l.left {
+		n = int(l.left)
+		l.left = -1
+		return n, ErrBodyTooLarge
+	}
+	l.left -= int64(n)
+	return n, err
+}
+
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// memoryBudget bounds the bytes of bodies held in memory over all workers,
+// a worker that would go over it waits for others to finish their pages
+type memoryBudget struct {
+	total int64
+	sem   *semaphore.Weighted
+}
+
+// newMemoryBudget returns nil, no limit, for a total of 0
+func newMemoryBudget(total int64) *memoryBudget {
+	if total <= 0 {
+		return nil
+	}
+	return &memoryBudget{total: total, sem: semaphore.NewWeighted(total)}
+}
+
+// reserve waits until copies of a body of size bytes fit, an unknown size
+// or one larger than the budget takes all of it so the page still gets a
+// turn
+func (b *memoryBudget) reserve(ctx context.Context, copies, size int64) (func(), error) {
+	if b == nil || copies == 0 || size == 0 {
+		return func() {}, nil
+	}
+
+	n := copies * size
+	if size < 0 || n > b.total {
+		n = b.total
+	}
+	if err := b.sem.Acquire(ctx, n); err != nil {
+		return nil, err
+	}
+	return func() { b.sem.Release(n) }, nil
+}
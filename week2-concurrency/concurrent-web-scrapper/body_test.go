@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapeURL_BodyLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := strings.Repeat("x", 1024)
+		if r.URL.Path == "/over" {
+			body += "x"
+		}
+		if r.URL.Query().Has("chunked") {
+			// no Content-Length, the limit is only noticed while reading
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"/exact", true},
+		{"/exact?chunked", true},
+		{"/over", false},
+		{"/over?chunked", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			sc, _ := newTestScrapper(ScrapperConfig{MaxBodyBytes: 1024, Retry: RetryPolicy{MaxAttempts: 1}})
+			result := sc.ScrapeURL(srv.URL + tt.path)
+
+			if !tt.ok {
+				assert.Equal(t, KindBodyTooLarge, ErrorKindOf(result.Error))
+				return
+			}
+			require.NoError(t, result.Error)
+			assert.Equal(t, 1024, result.BodyLength)
+			assert.Equal(t, contentHash([]byte(strings.Repeat("x", 1024))), result.ContentHash)
+		})
+	}
+}
+
+func TestScrapeURL_ContentTypes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		fmt.Fprint(w, "body")
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		contentType string
+		ok          bool
+	}{
+		{"text/html; charset=utf-8", true},
+		{"TEXT/HTML", true},
+		{"text/plain", true},
+		{"application/json", false},
+		{"application/pdf", false},
+	}
+
+	sc, _ := newTestScrapper(ScrapperConfig{
+		ContentTypes: []string{"Text/*", "text/html"},
+		Retry:        RetryPolicy{MaxAttempts: 1},
+	})
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			result := sc.ScrapeURL(srv.URL + "/?type=" + url.QueryEscape(tt.contentType))
+
+			if tt.ok {
+				require.NoError(t, result.Error)
+				assert.Equal(t, 4, result.BodyLength)
+				return
+			}
+			assert.Equal(t, KindContentType, ErrorKindOf(result.Error))
+			assert.ErrorIs(t, result.Error, ErrUnwantedContentType)
+			assert.Equal(t, 200, result.StatusCode)
+			assert.Zero(t, result.BodyLength)
+			assert.Equal(t, 1, result.Attempts)
+		})
+	}
+}
+
+func TestWantedType(t *testing.T) {
+	resp := func(contentType string) *http.Response {
+		return &http.Response{Header: http.Header{"Content-Type": {contentType}}}
+	}
+
+	mediaType, ok := wantedType(nil, resp("image/png"))
+	assert.True(t, ok)
+	assert.Equal(t, "image/png", mediaType)
+
+	mediaType, ok = wantedType([]string{"text/html"}, resp(""))
+	assert.False(t, ok)
+	assert.Equal(t, "application/octet-stream", mediaType)
+
+	_, ok = wantedType([]string{"*/*"}, resp("image/png"))
+	assert.True(t, ok)
+	_, ok = wantedType([]string{"text/*"}, resp("textual/plain"))
+	assert.False(t, ok)
+}
+
+func TestScrapeURL_StreamsUnmatchedPages(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<title>t</title><a href="/next">next</a>`)
+	}))
+	defer srv.Close()
+
+	extractor, err := NewExtractor([]ExtractRule{{
+		Name:       "products",
+		URLPattern: `/products/`,
+		Fields:     []Field{{Name: "title", Selector: Selector{Kind: CSS, Expr: "title"}}},
+	}})
+	require.NoError(t, err)
+
+	sc, _ := newTestScrapper(ScrapperConfig{Extractor: extractor, MemoryBudget: 1})
+	sc.crawling.Store(true)
+
+	page := sc.ScrapeURL(srv.URL + "/about")
+	require.NoError(t, page.Error)
+	assert.Empty(t, page.Records)
+	assert.Equal(t, []string{srv.URL + "/next"}, page.Links)
+
+	product := sc.ScrapeURL(srv.URL + "/products/1")
+	require.NoError(t, product.Error)
+	require.Len(t, product.Records, 1)
+	assert.Equal(t, "t", product.Records[0].Fields["title"])
+	assert.Equal(t, []string{srv.URL + "/next"}, product.Links)
+}
+
+func TestMemoryBudget(t *testing.T) {
+	ctx := context.Background()
+	budget := newMemoryBudget(100)
+
+	release, err := budget.reserve(ctx, 1, 60)
+	require.NoError(t, err)
+
+	// 2 copies of 30 bytes don't fit next to the first page
+	acquired := make(chan func())
+	go func() {
+		r, err := budget.reserve(ctx, 2, 30)
+		assert.NoError(t, err)
+		acquired <- r
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("reserved past the budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	(<-acquired)()
+
+	// an unknown size takes the whole budget, so it waits for everything
+	release, err = budget.reserve(ctx, 1, 10)
+	require.NoError(t, err)
+	waiting, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = budget.reserve(waiting, 1, -1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	release()
+
+	release, err = budget.reserve(ctx, 1, 1000)
+	require.NoError(t, err, "larger than the budget still gets a turn")
+	release()
+
+	var unlimited *memoryBudget
+	release, err = unlimited.reserve(ctx, 1, 1<<40)
+	require.NoError(t, err)
+	release()
+}
+
+func TestLimitReader(t *testing.T) {
+	r := &limitReader{r: strings.NewReader("abcdef"), left: 4}
+	got, err := io.ReadAll(r)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+	assert.Equal(t, "abcd", string(got))
+
+	got, err = io.ReadAll(&limitReader{r: strings.NewReader("abcd"), left: 4})
+	assert.NoError(t, err)
+	assert.Equal(t, "abcd", string(got))
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStatus is how the HTTP cache answered a request
+type CacheStatus int
+
+const (
+	// no cache is configured
+	CacheOff CacheStatus = iota
+	// fetched from the server, nothing usable was cached
+	CacheMiss
+	// served from the cache without contacting the server
+	CacheHit
+	// the server confirmed the cached copy is still current, 304
+	CacheRevalidated
+)
+
+func (c CacheStatus) String() string {
+	switch c {
+	case CacheOff:
+		return "off"
+	case CacheMiss:
+		return "miss"
+	case CacheHit:
+		return "hit"
+	case CacheRevalidated:
+		return "revalidated"
+	}
+	return fmt.Sprintf("CacheStatus(%d)", int(c))
+}
+
+// ChangeStatus compares a page with the last time it was fetched
+type ChangeStatus int
+
+const (
+	// nothing to compare with, no cache or the page wasn't cached
+	ChangeUnknown ChangeStatus = iota
+	ChangeUnchanged
+	ChangeModified
+)
+
+func (c ChangeStatus) String() string {
+	switch c {
+	case ChangeUnknown:
+		return "unknown"
+	case ChangeUnchanged:
+		return "unchanged"
+	case ChangeModified:
+		return "modified"
+	}
+	return fmt.Sprintf("ChangeStatus(%d)", int(c))
+}
+
+// the caching transport reports to ScrapeURL through these response headers
+const (
+	cacheStatusHeader  = "X-Scrapper-Cache"
+	previousHashHeader = "X-Scrapper-Previous-Hash"
+)
+
+// CacheEntry is a stored response
+type CacheEntry struct {
+	URL        string      `json:"url"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	// when the response was received
+	StoredAt time.Time `json:"stored_at"`
+	// hex SHA-256 of Body
+	Hash string `json:"hash"`
+}
+
+// CacheStore keeps cached responses by URL, implementations must be safe
+// for concurrent use
+type CacheStore interface {
+	Get(url string) (*CacheEntry, bool)
+	Put(entry *CacheEntry) error
+}
+
+// MemoryCache is a CacheStore that lasts as long as the process
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]*CacheEntry
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]*CacheEntry)}
+}
+
+func (c *MemoryCache) Get(url string) (*CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[url]
+	return e, ok
+}
+
+func (c *MemoryCache) Put(entry *CacheEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[entry.URL] = entry
+	return nil
+}
+
+// DiskCache is a CacheStore with one JSON file per URL, so later runs
+// reuse what earlier ones fetched
+type DiskCache struct {
+	dir string
+}
+
+// OpenDiskCache uses dir for the cache, creating it if needed
+func OpenDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+func (c *DiskCache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (c *DiskCache) Get(url string) (*CacheEntry, bool) {
+	data, err := os.ReadFile(c.path(url))
+	if err != nil {
+		return nil, false
+	}
+
+	var e CacheEntry
+	// a damaged file is just a miss, the next Put replaces it
+	if err := json.Unmarshal(data, &e); err != nil || e.URL != url {
+		return nil, false
+	}
+	return &e, true
+}
+
+// Put writes through a temporary file and a rename, so readers never see
+// half an entry
+func (c *DiskCache) Put(entry *CacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(c.dir, "entry-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(entry.URL))
+}
+
+// cachingTransport is a private HTTP cache (RFC 9111) in front of another
+// transport: fresh entries are served without a request, stale ones are
+// revalidated with their ETag and Last-Modified validators
+type cachingTransport struct {
+	next  http.RoundTripper
+	store CacheStore
+	now   func() time.Time
+}
+
+func newCachingTransport(next http.RoundTripper, store CacheStore) *cachingTransport {
+	return &cachingTransport{next: next, store: store, now: time.Now}
+}
+
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.next.RoundTrip(req)
+	}
+
+	// fetch has looked the entry up already when it decided to send req
+	entry, looked := lookedUp(req)
+	if !looked {
+		var resp *http.Response
+		if resp, entry = t.lookup(req); resp != nil {
+			return resp, nil
+		}
+	}
+
+	key := req.URL.String()
+	cached := entry != nil
+
+	if cached {
+		// the caller's request may be reused, work on a copy
+		req = req.Clone(req.Context())
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if modified := entry.Header.Get("Last-Modified"); modified != "" {
+			req.Header.Set("If-Modified-Since", modified)
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		// the 304 carries the new freshness information
+		updated := *entry
+		updated.Header = entry.Header.Clone()
+		for _, name := range []string{"Cache-Control", "Date", "ETag", "Expires", "Last-Modified", "Age"} {
+			if v := resp.Header.Get(name); v != "" {
+				updated.Header.Set(name, v)
+			}
+		}
+		updated.StoredAt = t.now()
+		if err := t.store.Put(&updated); err != nil {
+			return nil, err
+		}
+		return updated.response(req, CacheRevalidated), nil
+	}
+
+	if resp.StatusCode != http.StatusOK || !storable(resp) {
+		resp.Header.Set(cacheStatusHeader, CacheMiss.String())
+		if cached {
+			resp.Header.Set(previousHashHeader, entry.Hash)
+		}
+		return resp, nil
+	}
+
+	resp.Body = &cacheFill{
+		ReadCloser: resp.Body,
+		store:      t.store,
+		entry: &CacheEntry{
+			URL:        key,
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header.Clone(),
+			StoredAt:   t.now(),
+		},
+	}
+	resp.Header.Set(cacheStatusHeader, CacheMiss.String())
+	if cached {
+		resp.Header.Set(previousHashHeader, entry.Hash)
+	}
+	return resp, nil
+}
+
+// cacheFill passes a body through and stores it once it was read to the
+// end, a body that is given up on isn't cached
+type cacheFill struct {
+	io.ReadCloser
+	buf   bytes.Buffer
+	store CacheStore
+	entry *CacheEntry // nil once stored
+}
+
+func (f *cacheFill) Read(p []byte) (int, error) {
+	n, err := f.ReadCloser.Read(p)
+	f.buf.Write(p[:n])
+
+	if err == io.EOF && f.entry != nil {
+		f.entry.Body = f.buf.Bytes()
+		f.entry.Hash = contentHash(f.entry.Body)
+		if putErr := f.store.Put(f.entry); putErr != nil {
+			err = putErr
+		}
+		f.entry = nil
+	}
+	return n, err
+}
+
+// cacheStores reports whether the caching transport keeps a copy of the
+// response's body as it is read
+func cacheStores(resp *http.Response) bool {
+	return resp.Header.Get(cacheStatusHeader) == CacheMiss.String() &&
+		resp.StatusCode == http.StatusOK && storable(resp)
+}
+
+// lookup answers req from a fresh entry without contacting the server,
+// otherwise it returns the entry to revalidate, if there is one
+func (t *cachingTransport) lookup(req *http.Request) (*http.Response, *CacheEntry) {
+	if t == nil || req.Method != http.MethodGet {
+		return nil, nil
+	}
+	entry, ok := t.store.Get(req.URL.String())
+	if !ok {
+		return nil, nil
+	}
+
+	reqCC := parseCacheControl(req.Header.Get("Cache-Control"))
+	if !reqCC.has("no-cache") && !reqCC.has("max-age=0") && t.fresh(entry) {
+		return entry.response(req, CacheHit), nil
+	}
+	return nil, entry
+}
+
+// cacheLookup is the outcome of a lookup made before req was sent, kept in
+// its context so the transport doesn't ask the store again
+type cacheLookup struct {
+	url   string
+	entry *CacheEntry // nil if nothing was cached
+}
+
+type cacheLookupKey struct{}
+
+// withLookup returns req carrying the entry a lookup found for it
+func withLookup(req *http.Request, entry *CacheEntry) *http.Request {
+	l := cacheLookup{url: req.URL.String(), entry: entry}
+	return req.WithContext(context.WithValue(req.Context(), cacheLookupKey{}, l))
+}
+
+// lookedUp returns the entry of an earlier lookup for req, a redirect to
+// another URL is looked up afresh
+func lookedUp(req *http.Request) (*CacheEntry, bool) {
+	l, ok := req.Context().Value(cacheLookupKey{}).(cacheLookup)
+	if !ok || l.url != req.URL.String() {
+		return nil, false
+	}
+	return l.entry, true
+}
+
+// storable reports whether the response may be kept by a private cache
+func storable(resp *http.Response) bool {
+	cc := parseCacheControl(resp.Header.Get("Cache-Control"))
+	return !cc.has("no-store") && resp.Header.Get("Vary") != "*"
+}
+
+// fresh reports whether the entry can be used without asking the server
+func (t *cachingTransport) fresh(e *CacheEntry) bool {
+	cc := parseCacheControl(e.Header.Get("Cache-Control"))
+	if cc.has("no-cache") {
+		return false
+	}
+
+	age := t.now().Sub(e.StoredAt)
+	if secs, err := strconv.Atoi(e.Header.Get("Age")); err == nil {
+		age += time.Duration(secs) * time.Second
+	}
+	return age < e.lifetime(cc)
+}
+
+// lifetime is how long the response stays fresh: max-age, else Expires,
+// else a tenth of the time since Last-Modified
+func (e *CacheEntry) lifetime(cc cacheControl) time.Duration {
+	if v, ok := cc["max-age"]; ok {
+		secs, err := strconv.Atoi(v)
+		if err != nil {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.StoredAt
+	}
+	if v := e.Header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0 // invalid, e.g. "0", means already expired
+		}
+		return expires.Sub(date)
+	}
+	if modified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil {
+		return date.Sub(modified) / 10
+	}
+	return 0
+}
+
+// response rebuilds an http.Response for req from the entry
+func (e *CacheEntry) response(req *http.Request, status CacheStatus) *http.Response {
+	header := e.Header.Clone()
+	header.Set(cacheStatusHeader, status.String())
+	header.Set(previousHashHeader, e.Hash)
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// cacheControl holds Cache-Control directives, valueless ones map to ""
+type cacheControl map[string]string
+
+func parseCacheControl(header string) cacheControl {
+	cc := make(cacheControl)
+	for part := range strings.SplitSeq(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+	}
+	return cc
+}
+
+// has reports a directive, "max-age=0" style checks the value too
+func (cc cacheControl) has(directive string) bool {
+	name, value, withValue := strings.Cut(directive, "=")
+	v, ok := cc[name]
+	return ok && (!withValue || v == value)
+}
+
+// cacheInfo reads what the caching transport reported about resp
+func cacheInfo(resp *http.Response) (CacheStatus, string) {
+	switch resp.Header.Get(cacheStatusHeader) {
+	case CacheHit.String():
+		return CacheHit, resp.Header.Get(previousHashHeader)
+	case CacheRevalidated.String():
+		return CacheRevalidated, resp.Header.Get(previousHashHeader)
+	case CacheMiss.String():
+		return CacheMiss, resp.Header.Get(previousHashHeader)
+	}
+	return CacheOff, ""
+}
+
+func contentHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// changeOf compares a body's hash with the one cached before, if any
+func changeOf(previous, current string) ChangeStatus {
+	switch previous {
+	case "":
+		return ChangeUnknown
+	case current:
+		return ChangeUnchanged
+	}
+	return ChangeModified
+}
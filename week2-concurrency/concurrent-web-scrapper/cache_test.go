@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// versionedPage serves *body with an ETag derived from it, answering
+// If-None-Match with 304, and counts the requests that reached it
+func versionedPage(body *atomic.Value, cacheControl string, requests *atomic.Int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		content := body.Load().(string)
+		etag := `"` + contentHash([]byte(content))[:16] + `"`
+
+		w.Header().Set("ETag", etag)
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprint(w, content)
+	})
+}
+
+// countingCache counts the lookups per URL
+type countingCache struct {
+	CacheStore
+	mu   sync.Mutex
+	gets map[string]int
+}
+
+func (c *countingCache) Get(url string) (*CacheEntry, bool) {
+	c.mu.Lock()
+	c.gets[url]++
+	c.mu.Unlock()
+	return c.CacheStore.Get(url)
+}
+
+// lookups returns and resets the count for url
+func (c *countingCache) lookups(url string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := c.gets[url]
+	delete(c.gets, url)
+	return n
+}
+
+func TestScrapeURL_Cache(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		// the second request asks to skip fresh copies
+		noCache bool
+		// the page changes between the two requests
+		changed bool
+
+		wantCache    CacheStatus
+		wantChange   ChangeStatus
+		wantRequests int32
+	}{
+		{"fresh copy is served", "max-age=60", false, false, CacheHit, ChangeUnchanged, 1},
+		{"stale copy is revalidated", "max-age=0", false, false, CacheRevalidated, ChangeUnchanged, 2},
+		{"no-cache always revalidates", "no-cache, max-age=60", false, false, CacheRevalidated, ChangeUnchanged, 2},
+		{"request no-cache skips fresh copy", "max-age=60", true, false, CacheRevalidated, ChangeUnchanged, 2},
+		{"changed page", "max-age=0", false, true, CacheMiss, ChangeModified, 2},
+		{"no-store isn't kept", "no-store", false, false, CacheMiss, ChangeUnknown, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body atomic.Value
+			body.Store("<p>v1</p>")
+			var requests atomic.Int32
+			srv := httptest.NewServer(versionedPage(&body, tt.cacheControl, &requests))
+			defer srv.Close()
+
+			cache := &countingCache{CacheStore: NewMemoryCache(), gets: make(map[string]int)}
+			sc, _ := newTestScrapper(ScrapperConfig{Cache: cache})
+			first := sc.ScrapeURL(srv.URL)
+			require.NoError(t, first.Error)
+			assert.Equal(t, CacheMiss, first.Cache)
+			assert.Equal(t, ChangeUnknown, first.Change)
+			assert.Equal(t, 1, cache.lookups(srv.URL), "one lookup per fetch")
+
+			if tt.changed {
+				body.Store("<p>v2</p>")
+			}
+			headers := http.Header{}
+			if tt.noCache {
+				headers.Set("Cache-Control", "no-cache")
+			}
+			sc, _ = newTestScrapper(ScrapperConfig{Cache: cache, Headers: headers})
+			second := sc.ScrapeURL(srv.URL)
+			require.NoError(t, second.Error)
+
+			assert.Equal(t, tt.wantCache, second.Cache)
+			assert.Equal(t, tt.wantChange, second.Change)
+			assert.Equal(t, 1, cache.lookups(srv.URL), "one lookup per fetch")
+			assert.Equal(t, tt.wantRequests, requests.Load())
+			assert.Equal(t, http.StatusOK, second.StatusCode)
+			assert.Equal(t, contentHash([]byte(body.Load().(string))), second.ContentHash)
+			assert.Equal(t, len(body.Load().(string)), second.BodyLength)
+		})
+	}
+}
+
+func TestScrapeURL_CacheHitSkipsLimits(t *testing.T) {
+	var body atomic.Value
+	body.Store("<p>v1</p>")
+	var requests atomic.Int32
+	srv := httptest.NewServer(versionedPage(&body, "max-age=60", &requests))
+	defer srv.Close()
+
+	// a second request to the host would have to wait 10s
+	sc, _ := newTestScrapper(ScrapperConfig{Cache: NewMemoryCache(), HostRPS: 0.1})
+	require.NoError(t, sc.ScrapeURL(srv.URL).Error)
+
+	start := time.Now()
+	result := sc.ScrapeURL(srv.URL)
+	require.NoError(t, result.Error)
+	assert.Equal(t, CacheHit, result.Cache)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestScrapeURL_NoCache(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+
+	sc, _ := newTestScrapper(ScrapperConfig{})
+	result := sc.ScrapeURL(srv.URL)
+
+	require.NoError(t, result.Error)
+	assert.Equal(t, CacheOff, result.Cache)
+	assert.Equal(t, ChangeUnknown, result.Change)
+	assert.Equal(t, contentHash([]byte("ok")), result.ContentHash)
+}
+
+func TestDiskCache_SurvivesRuns(t *testing.T) {
+	var body atomic.Value
+	body.Store("<p>v1</p>")
+	var requests atomic.Int32
+	srv := httptest.NewServer(versionedPage(&body, "max-age=0", &requests))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	for run, want := range []CacheStatus{CacheMiss, CacheRevalidated} {
+		cache, err := OpenDiskCache(dir)
+		require.NoError(t, err)
+
+		sc, _ := newTestScrapper(ScrapperConfig{Cache: cache})
+		result := sc.ScrapeURL(srv.URL)
+		require.NoError(t, result.Error)
+		assert.Equal(t, want, result.Cache, "run %d", run+1)
+		assert.Equal(t, len("<p>v1</p>"), result.BodyLength)
+	}
+}
+
+func TestDiskCache_DamagedEntry(t *testing.T) {
+	cache, err := OpenDiskCache(t.TempDir())
+	require.NoError(t, err)
+
+	url := "https://example.com/"
+	require.NoError(t, cache.Put(&CacheEntry{URL: url, StatusCode: 200, Body: []byte("ok")}))
+	entry, ok := cache.Get(url)
+	require.True(t, ok)
+	assert.Equal(t, []byte("ok"), entry.Body)
+
+	require.NoError(t, os.WriteFile(cache.path(url), []byte("{not json"), 0o644))
+	_, ok = cache.Get(url)
+	assert.False(t, ok)
+}
+
+func TestCacheEntry_Lifetime(t *testing.T) {
+	date := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"max-age", http.Header{"Cache-Control": {"public, max-age=300"}}, 5 * time.Minute},
+		{"max-age wins over Expires", http.Header{
+			"Cache-Control": {"max-age=10"},
+			"Expires":       {date.Add(time.Hour).Format(http.TimeFormat)},
+		}, 10 * time.Second},
+		{"Expires", http.Header{
+			"Date":    {date.Format(http.TimeFormat)},
+			"Expires": {date.Add(time.Hour).Format(http.TimeFormat)},
+		}, time.Hour},
+		{"invalid Expires", http.Header{"Expires": {"0"}}, 0},
+		{"Last-Modified heuristic", http.Header{
+			"Date":          {date.Format(http.TimeFormat)},
+			"Last-Modified": {date.Add(-10 * time.Hour).Format(http.TimeFormat)},
+		}, time.Hour},
+		{"nothing", http.Header{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &CacheEntry{Header: tt.header, StoredAt: date}
+			assert.Equal(t, tt.want, e.lifetime(parseCacheControl(tt.header.Get("Cache-Control"))))
+		})
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the scrapper's YAML config file, command line flags override it
+type Config struct {
+	// URLs to scrape, added to the ones given as arguments
+	Seeds []string `yaml:"seeds"`
+	// a file with one URL per line, "-" reads stdin
+	SeedsFile string `yaml:"seeds_file"`
+
+	Workers         int               `yaml:"workers"`
+	Timeout         time.Duration     `yaml:"timeout"`
+	RPS             int               `yaml:"rps"`
+	HostRPS         float64           `yaml:"host_rps"`
+	MaxConnsPerHost int               `yaml:"max_conns_per_host"`
+	UserAgent       string            `yaml:"user_agent"`
+	IgnoreRobots    bool              `yaml:"ignore_robots"`
+	MaxBodyBytes    int64             `yaml:"max_body_bytes"`
+	ContentTypes    []string          `yaml:"content_types"`
+	MemoryBudget    int64             `yaml:"memory_budget"`
+	MaxAttempts     int               `yaml:"max_attempts"`
+	Headers         map[string]string `yaml:"headers"`
+	Proxies         []string          `yaml:"proxies"`
+	// directory of cached responses, revalidated on later runs, empty
+	// disables caching
+	Cache string `yaml:"cache"`
+
+	// 0 only scrapes the seeds, more follows their links, left out it is 0
+	// or the depth of the resumed session
+	MaxDepth       int      `yaml:"max_depth"`
+	MaxPages       int      `yaml:"max_pages"`
+	AllowedDomains []string `yaml:"allowed_domains"`
+
+	Extract []ExtractRule `yaml:"extract"`
+	Output  OutputConfig  `yaml:"output"`
+
+	// directory keeping the state of the scrape for -resume, empty keeps
+	// it in memory only
+	Session            string        `yaml:"session"`
+	CheckpointInterval time.Duration `yaml:"checkpoint_interval"`
+}
+
+// OutputConfig chooses the sinks, every one that is set gets all results
+type OutputConfig struct {
+	JSONL          string            `yaml:"jsonl"`
+	CSV            string            `yaml:"csv"`
+	SQLite         string            `yaml:"sqlite"`
+	SQLiteTable    string            `yaml:"sqlite_table"`
+	Webhook        string            `yaml:"webhook"`
+	WebhookHeaders map[string]string `yaml:"webhook_headers"`
+	BatchSize      int               `yaml:"batch_size"`
+	FlushInterval  time.Duration     `yaml:"flush_interval"`
+	// the final statistics are also written here as JSON
+	Stats string `yaml:"stats"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Workers:            10,
+		Timeout:            30 * time.Second,
+		RPS:                50,
+		HostRPS:            5,
+		MaxConnsPerHost:    4,
+		UserAgent:          DefaultUserAgent,
+		MaxAttempts:        DefaultRetryPolicy.MaxAttempts,
+		CheckpointInterval: 5 * time.Second,
+		MaxDepth:           -1, // unset, see CrawlConfig.MaxDepth
+		Output: OutputConfig{
+			SQLiteTable:   "results",
+			BatchSize:     50,
+			FlushInterval: time.Second,
+		},
+	}
+}
+
+// LoadConfig reads a YAML config file over the defaults, unknown keys are
+// an error so typos don't go unnoticed
+func LoadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return cfg, fmt.Errorf("config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// cliOptions are the flags that only make sense on the command line
+type cliOptions struct {
+	configPath string
+	progress   bool
+	verbose    bool
+	resume     bool
+}
+
+func newFlagSet(cfg *Config, opts *cliOptions, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("scrapper", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: scrapper [flags] [URL...]")
+		fmt.Fprintln(stderr, "URLs come from the arguments, -urls, the config file or else stdin.")
+		fmt.Fprintln(stderr, "Each page is scraped once, repeated URLs are skipped.")
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&opts.configPath, "config", "", "YAML config file, flags override it")
+	fs.BoolVar(&opts.progress, "progress", isTerminal(stderr), "show live progress on stderr, on by default when it is a terminal")
+	fs.BoolVar(&opts.verbose, "v", false, "print every result")
+	fs.BoolVar(&opts.resume, "resume", false, "continue the scrape saved in -session")
+
+	fs.StringVar(&cfg.SeedsFile, "urls", cfg.SeedsFile, `file with one URL per line, "-" for stdin`)
+	fs.IntVar(&cfg.Workers, "workers", cfg.Workers, "concurrent fetches")
+	fs.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "per request timeout")
+	fs.IntVar(&cfg.RPS, "rps", cfg.RPS, "requests per second over all hosts, 0 is unlimited")
+	fs.Float64Var(&cfg.HostRPS, "host-rps", cfg.HostRPS, "requests per second per host, 0 is unlimited")
+	fs.IntVar(&cfg.MaxConnsPerHost, "host-conns", cfg.MaxConnsPerHost, "requests in flight per host, 0 is unlimited")
+	fs.StringVar(&cfg.UserAgent, "user-agent", cfg.UserAgent, "User-Agent header and robots.txt agent")
+	fs.BoolVar(&cfg.IgnoreRobots, "ignore-robots", cfg.IgnoreRobots, "don't fetch or obey robots.txt")
+	fs.Int64Var(&cfg.MaxBodyBytes, "max-body", cfg.MaxBodyBytes, "largest body in bytes, 0 is unlimited")
+	fs.Func("content-type", `media type to download, e.g. "text/html" or "text/*", repeatable`, func(s string) error {
+		cfg.ContentTypes = append(cfg.ContentTypes, s)
+		return nil
+	})
+	fs.Int64Var(&cfg.MemoryBudget, "memory", cfg.MemoryBudget, "bytes of page bodies held at once, 0 is unlimited")
+	fs.StringVar(&cfg.Cache, "cache", cfg.Cache, "directory to cache responses in and revalidate them from")
+	fs.IntVar(&cfg.MaxAttempts, "attempts", cfg.MaxAttempts, "tries per URL for transient failures")
+	fs.Func("header", `extra request header "Name: value", repeatable`, func(s string) error {
+		name, value, ok := strings.Cut(s, ":")
+		if !ok {
+			return fmt.Errorf("want Name: value, got %q", s)
+		}
+		if cfg.Headers == nil {
+			cfg.Headers = make(map[string]string)
+		}
+		cfg.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		return nil
+	})
+	fs.Func("proxy", "proxy URL, repeatable to rotate between several", func(s string) error {
+		cfg.Proxies = append(cfg.Proxies, s)
+		return nil
+	})
+
+	fs.Func("depth", "follow links `n` deep from the seeds (default 0, or the session's depth with -resume)", func(s string) error {
+		depth, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		if depth < 0 {
+			return errors.New("must not be negative")
+		}
+		cfg.MaxDepth = depth
+		return nil
+	})
+	fs.IntVar(&cfg.MaxPages, "max-pages", cfg.MaxPages, "stop after this many pages, 0 is unlimited")
+	fs.Func("allow-domain", "also follow links into this domain, repeatable", func(s string) error {
+		cfg.AllowedDomains = append(cfg.AllowedDomains, s)
+		return nil
+	})
+
+	fs.StringVar(&cfg.Output.JSONL, "jsonl", cfg.Output.JSONL, "append results to this JSON Lines file")
+	fs.StringVar(&cfg.Output.CSV, "csv", cfg.Output.CSV, "append results to this CSV file")
+	fs.StringVar(&cfg.Output.SQLite, "sqlite", cfg.Output.SQLite, "insert results into this SQLite database")
+	fs.StringVar(&cfg.Output.SQLiteTable, "sqlite-table", cfg.Output.SQLiteTable, "table for -sqlite")
+	fs.StringVar(&cfg.Output.Webhook, "webhook", cfg.Output.Webhook, "POST batches of results to this URL")
+	fs.StringVar(&cfg.Output.Stats, "stats", cfg.Output.Stats, "write the final statistics as JSON to this file")
+
+	fs.StringVar(&cfg.Session, "session", cfg.Session, "directory to save the scrape's progress in")
+	fs.DurationVar(&cfg.CheckpointInterval, "checkpoint", cfg.CheckpointInterval, "how often the session is made durable")
+	return fs
+}
+
+// parseArgs builds the config from an optional config file and the flags,
+// returning the remaining arguments
+func parseArgs(args []string, stderr io.Writer) (Config, cliOptions, []string, error) {
+	// a first pass only looks for -config
+	cfg, opts := defaultConfig(), cliOptions{}
+	if err := newFlagSet(&cfg, &opts, io.Discard).Parse(args); err != nil {
+		// reported properly by the second pass
+		opts = cliOptions{}
+	}
+
+	cfg = defaultConfig()
+	if opts.configPath != "" {
+		var err error
+		if cfg, err = LoadConfig(opts.configPath); err != nil {
+			return cfg, opts, nil, err
+		}
+	}
+
+	fs := newFlagSet(&cfg, &opts, stderr)
+	if err := fs.Parse(args); err != nil {
+		return cfg, opts, nil, err
+	}
+	return cfg, opts, fs.Args(), nil
+}
+
+// scrapperConfig turns the config into the scrapper's own
+func (cfg Config) scrapperConfig() (ScrapperConfig, error) {
+	sc := ScrapperConfig{
+		Workers:         cfg.Workers,
+		Timeout:         cfg.Timeout,
+		RPS:             cfg.RPS,
+		HostRPS:         cfg.HostRPS,
+		MaxConnsPerHost: cfg.MaxConnsPerHost,
+		UserAgent:       cfg.UserAgent,
+		IgnoreRobots:    cfg.IgnoreRobots,
+		MaxBodyBytes:    cfg.MaxBodyBytes,
+		ContentTypes:    cfg.ContentTypes,
+		MemoryBudget:    cfg.MemoryBudget,
+		Retry:           DefaultRetryPolicy,
+		Headers:         toHeader(cfg.Headers),
+	}
+	if cfg.Workers < 1 {
+		return sc, fmt.Errorf("workers must be at least 1, got %d", cfg.Workers)
+	}
+	sc.Retry.MaxAttempts = max(cfg.MaxAttempts, 1)
+
+	for _, raw := range cfg.Proxies {
+		proxy, err := url.Parse(raw)
+		if err != nil || proxy.Host == "" {
+			return sc, fmt.Errorf("invalid proxy %q", raw)
+		}
+		sc.Proxies = append(sc.Proxies, proxy)
+	}
+
+	if cfg.Cache != "" {
+		cache, err := OpenDiskCache(cfg.Cache)
+		if err != nil {
+			return sc, err
+		}
+		sc.Cache = cache
+	}
+
+	if len(cfg.Extract) > 0 {
+		extractor, err := NewExtractor(cfg.Extract)
+		if err != nil {
+			return sc, err
+		}
+		sc.Extractor = extractor
+	}
+	return sc, nil
+}
+
+// sinks opens every configured output, closing the ones already opened if
+// one fails
+func (cfg Config) sinks() ([]Sink, error) {
+	var sinks []Sink
+	fail := func(err error) ([]Sink, error) {
+		for _, s := range sinks {
+			s.Close()
+		}
+		return nil, err
+	}
+
+	out := cfg.Output
+	if out.JSONL != "" {
+		s, err := OpenJSONLSink(out.JSONL)
+		if err != nil {
+			return fail(err)
+		}
+		sinks = append(sinks, s)
+	}
+	if out.CSV != "" {
+		s, err := OpenCSVSink(out.CSV)
+		if err != nil {
+			return fail(err)
+		}
+		sinks = append(sinks, s)
+	}
+	if out.SQLite != "" {
+		s, err := OpenSQLiteSink(out.SQLite, out.SQLiteTable)
+		if err != nil {
+			return fail(err)
+		}
+		sinks = append(sinks, s)
+	}
+	if out.Webhook != "" {
+		sinks = append(sinks, NewWebhookSink(out.Webhook, toHeader(out.WebhookHeaders)))
+	}
+	return sinks, nil
+}
+
+func toHeader(m map[string]string) http.Header {
+	if len(m) == 0 {
+		return nil
+	}
+	h := make(http.Header)
+	for name, value := range m {
+		h.Set(name, value)
+	}
+	return h
+}
+
+// readSeeds collects the URLs from the arguments, the config and the seeds
+// file, falling back to stdin when there are none and stdin isn't nil
+func readSeeds(cfg Config, args []string, stdin io.Reader) ([]string, error) {
+	seeds := append(append([]string{}, cfg.Seeds...), args...)
+
+	var in io.Reader
+	switch {
+	case cfg.SeedsFile == "-":
+		in = stdin
+	case cfg.SeedsFile != "":
+		f, err := os.Open(cfg.SeedsFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	case len(seeds) == 0 && stdin != nil:
+		in = stdin
+	}
+
+	if in != nil {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				seeds = append(seeds, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return seeds, nil
+}
+
+// flushTimeout bounds how long the sinks may take to store the last results
+// once the scrape is interrupted
+const flushTimeout = 10 * time.Second
+
+// run is the command line program, it returns the exit code
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	cfg, opts, rest, err := parseArgs(args, stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return 2
+	}
+
+	scfg, err := cfg.scrapperConfig()
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return 2
+	}
+
+	if opts.resume {
+		// the session knows its URLs, don't wait for more on stdin
+		stdin = nil
+	}
+	seeds, err := readSeeds(cfg, rest, stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error reading URLs:", err)
+		return 1
+	}
+	// one bad line in a long list shouldn't stop the others
+	var valid []string
+	seen := make(map[string]bool)
+	for _, seed := range seeds {
+		norm, err := NormalizeURL(seed)
+		if err != nil {
+			fmt.Fprintf(stderr, "Skipping %q: %v\n", seed, err)
+			continue
+		}
+		if seen[norm] {
+			fmt.Fprintf(stderr, "Skipping %q: already listed\n", seed)
+			continue
+		}
+		seen[norm] = true
+		valid = append(valid, seed)
+	}
+	if len(valid) == 0 && !opts.resume {
+		fmt.Fprintln(stderr, "Error: no URLs to scrape")
+		return 2
+	}
+	if opts.resume && cfg.Session == "" {
+		fmt.Fprintln(stderr, "Error: -resume needs -session")
+		return 2
+	}
+
+	sinks, err := cfg.sinks()
+	if err != nil {
+		fmt.Fprintln(stderr, "Error opening output:", err)
+		return 1
+	}
+
+	var session *Session
+	if cfg.Session != "" {
+		if opts.resume {
+			session, err = ResumeSession(cfg.Session, cfg.CheckpointInterval)
+		} else {
+			session, err = CreateSession(cfg.Session, cfg.CheckpointInterval)
+		}
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			fmt.Fprintln(stderr, "Error opening session:", err)
+			return 1
+		}
+		if opts.resume {
+			fmt.Fprintf(stderr, "Resuming session: %d done, %d left\n", session.Done(), len(session.pending()))
+		}
+	}
+
+	stats := NewStats(time.Second)
+	sinks = append(sinks, stats)
+	if opts.verbose {
+		sinks = append(sinks, consoleSink(stdout))
+	}
+	if opts.progress {
+		sinks = append(sinks, newProgress(stderr, 500*time.Millisecond))
+	}
+	if session != nil {
+		// last, so a page is only done once every other sink has it
+		sinks = append(sinks, session)
+	}
+
+	scrapper := NewScrapperWithConfig(scfg)
+	// a crawl of depth 0 is a plain scrape of the seeds with a clean end
+	// once they are done
+	err = scrapper.Crawl(valid, CrawlConfig{
+		MaxDepth:       cfg.MaxDepth,
+		MaxPages:       cfg.MaxPages,
+		AllowedDomains: cfg.AllowedDomains,
+		Session:        session,
+	})
+	if err != nil {
+		fmt.Fprintln(stderr, "Error starting scrape:", err)
+		return 1
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			scrapper.Stop()
+		case <-finished:
+		}
+	}()
+
+	// on Ctrl-C the results already fetched still reach the sinks, they get
+	// flushTimeout to take them before their writes are cancelled too
+	writeCtx, cancelWrites := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWrites()
+	stopWrites := context.AfterFunc(ctx, func() {
+		time.AfterFunc(flushTimeout, cancelWrites)
+	})
+	defer stopWrites()
+
+	batch := BatchConfig{Size: cfg.Output.BatchSize, MaxWait: cfg.Output.FlushInterval}
+	err = WriteResults(writeCtx, scrapper.Results(), batch, sinks...)
+	close(finished)
+
+	report := stats.Report()
+	report.PrintTable(stdout)
+	if cfg.Output.Stats != "" {
+		err = errors.Join(err, writeReport(cfg.Output.Stats, report))
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "Error writing results:", err)
+		return 1
+	}
+	if ctx.Err() != nil {
+		return 130
+	}
+	return 0
+}
+
+// consoleSink prints one line per result
+func consoleSink(w io.Writer) Sink {
+	return SinkFunc(func(ctx context.Context, batch []ScrapperResult) error {
+		for _, result := range batch {
+			if result.Error != nil {
+				fmt.Fprintf(w, "❌ %s - Error: %v, Attempts: %d\n", result.URL, result.Error, result.Attempts)
+				continue
+			}
+
+			fmt.Fprintf(w, "✅ %s - Status %d, Size: %d bytes, Time: %v", result.URL, result.StatusCode, result.BodyLength, result.Duration)
+			if result.Cache != CacheOff {
+				fmt.Fprintf(w, ", Cache: %s (%s)", result.Cache, result.Change)
+			}
+			fmt.Fprintln(w)
+			for _, record := range result.Records {
+				fmt.Fprintf(w, "   %s: %v\n", record.Rule, record.Fields)
+			}
+		}
+		return nil
+	})
+}
+
+// writeReport saves the final statistics as JSON
+func writeReport(path string, report StatsReport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := report.WriteJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
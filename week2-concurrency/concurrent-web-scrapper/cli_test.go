@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoadConfig_Example(t *testing.T) {
+	cfg, err := LoadConfig("scrapper.example.yaml")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"https://go.dev/"}, cfg.Seeds)
+	assert.Equal(t, 30*time.Second, cfg.Timeout)
+	assert.Equal(t, 2.0, cfg.HostRPS)
+	assert.Equal(t, int64(5<<20), cfg.MaxBodyBytes)
+	assert.Equal(t, map[string]string{"Accept-Language": "en"}, cfg.Headers)
+	assert.Equal(t, time.Second, cfg.Output.FlushInterval)
+	require.Len(t, cfg.Extract, 1)
+	assert.Equal(t, Field{Name: "description", Selector: Selector{Kind: CSS, Expr: `meta[name="description"]`}, Attr: "content"}, cfg.Extract[0].Fields[1])
+
+	_, err = cfg.scrapperConfig()
+	assert.NoError(t, err)
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	_, err := LoadConfig(writeFile(t, "typo.yaml", "wokers: 5\n"))
+	assert.ErrorContains(t, err, "wokers")
+
+	_, err = LoadConfig(writeFile(t, "bad.yaml", "timeout: soon\n"))
+	assert.Error(t, err)
+
+	cfg, err := LoadConfig(writeFile(t, "empty.yaml", ""))
+	require.NoError(t, err)
+	assert.Equal(t, defaultConfig(), cfg)
+}
+
+func TestParseArgs_FlagsOverrideConfig(t *testing.T) {
+	path := writeFile(t, "cfg.yaml", `
+workers: 3
+rps: 7
+headers:
+  X-From-File: yes
+proxies: [http://one:8080]
+output:
+  jsonl: from-file.jsonl
+`)
+
+	cfg, opts, rest, err := parseArgs([]string{
+		"-config", path,
+		"-workers", "12",
+		"-header", "Authorization: Bearer x",
+		"-proxy", "http://two:8080",
+		"-progress=false",
+		"http://example.com/",
+	}, &bytes.Buffer{})
+	require.NoError(t, err)
+
+	assert.Equal(t, 12, cfg.Workers, "flag wins")
+	assert.Equal(t, 7, cfg.RPS, "file value kept")
+	assert.Equal(t, 30*time.Second, cfg.Timeout, "default kept")
+	assert.Equal(t, map[string]string{"X-From-File": "yes", "Authorization": "Bearer x"}, cfg.Headers)
+	assert.Equal(t, []string{"http://one:8080", "http://two:8080"}, cfg.Proxies)
+	assert.Equal(t, "from-file.jsonl", cfg.Output.JSONL)
+	assert.False(t, opts.progress)
+	assert.Equal(t, []string{"http://example.com/"}, rest)
+
+	// a status line is only drawn on a terminal unless asked for
+	_, opts, _, err = parseArgs(nil, &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.False(t, opts.progress)
+	_, opts, _, err = parseArgs([]string{"-progress"}, &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.True(t, opts.progress)
+
+	_, _, _, err = parseArgs([]string{"-header", "no colon"}, &bytes.Buffer{})
+	assert.Error(t, err)
+	_, _, _, err = parseArgs([]string{"-depth", "-1"}, &bytes.Buffer{})
+	assert.Error(t, err)
+	_, _, _, err = parseArgs([]string{"-config", "missing.yaml"}, &bytes.Buffer{})
+	assert.Error(t, err)
+}
+
+func TestConfig_ScrapperConfigErrors(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Proxies = []string{"::"}
+	_, err := cfg.scrapperConfig()
+	assert.ErrorContains(t, err, "invalid proxy")
+
+	cfg = defaultConfig()
+	cfg.Workers = 0
+	_, err = cfg.scrapperConfig()
+	assert.Error(t, err)
+
+	cfg = defaultConfig()
+	cfg.Extract = []ExtractRule{{Name: "bad"}}
+	_, err = cfg.scrapperConfig()
+	assert.ErrorIs(t, err, ErrInvalidRule)
+}
+
+func TestReadSeeds(t *testing.T) {
+	file := writeFile(t, "urls.txt", "# a comment\nhttp://file/1\n\n  http://file/2  \n")
+	stdin := strings.NewReader("http://stdin/1\n")
+
+	tests := []struct {
+		name string
+		cfg  Config
+		args []string
+		want []string
+	}{
+		{
+			name: "config, arguments and file",
+			cfg:  Config{Seeds: []string{"http://config/1"}, SeedsFile: file},
+			args: []string{"http://arg/1"},
+			want: []string{"http://config/1", "http://arg/1", "http://file/1", "http://file/2"},
+		},
+		{name: "stdin when nothing else", want: []string{"http://stdin/1"}},
+		{name: "stdin on request", cfg: Config{SeedsFile: "-"}, args: []string{"http://arg/1"}, want: []string{"http://arg/1", "http://stdin/1"}},
+		{name: "stdin left alone", args: []string{"http://arg/1"}, want: []string{"http://arg/1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdin.Seek(0, 0)
+			got, err := readSeeds(tt.cfg, tt.args, stdin)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestScrapper_HeadersAndProxies(t *testing.T) {
+	// a forward proxy sees the absolute URL of every request
+	proxied := make(chan string, 10)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.URL.String() + " " + r.Header.Get("X-Api-Key")
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	sc := NewScrapperWithConfig(ScrapperConfig{
+		Workers: 1, Timeout: time.Second, IgnoreRobots: true,
+		Headers: http.Header{"X-Api-Key": {"secret"}},
+		Proxies: []*url.URL{proxyURL},
+	})
+
+	result := sc.ScrapeURL("http://scrapper.invalid/page")
+	require.NoError(t, result.Error)
+	assert.Equal(t, "http://scrapper.invalid/page secret", <-proxied)
+}
+
+func TestRun(t *testing.T) {
+	srv := site(t, map[string][]string{
+		"/":  {"/a", "/b"},
+		"/a": {},
+	})
+	out := filepath.Join(t.TempDir(), "out.jsonl")
+	statsOut := filepath.Join(t.TempDir(), "stats.json")
+
+	var stdout, stderr bytes.Buffer
+	stdin := strings.NewReader(srv.URL + "/\nnot a url\n" + srv.URL + "\n")
+	code := run(context.Background(), []string{"-depth", "1", "-jsonl", out, "-stats", statsOut, "-progress=false", "-v"}, stdin, &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+
+	assert.Contains(t, stderr.String(), `Skipping "not a url"`)
+	assert.Contains(t, stderr.String(), `Skipping "`+srv.URL+`": already listed`)
+	assert.Contains(t, stdout.String(), "3 (2 succeeded, 1 failed)", "/b is a 404")
+	assert.Contains(t, stdout.String(), "✅ "+srv.URL+"/a")
+
+	f, err := os.Open(out)
+	require.NoError(t, err)
+	defer f.Close()
+	urls := map[string]int{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var row ResultRow
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &row))
+		urls[row.URL] = row.StatusCode
+	}
+	assert.Equal(t, map[string]int{srv.URL + "/": 200, srv.URL + "/a": 200, srv.URL + "/b": 404}, urls)
+
+	data, err := os.ReadFile(statsOut)
+	require.NoError(t, err)
+	var report StatsReport
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, map[int]int{200: 2, 404: 1}, report.StatusCodes)
+	assert.Equal(t, 1, report.Failed)
+
+	t.Run("no URLs", func(t *testing.T) {
+		var stderr bytes.Buffer
+		code := run(context.Background(), []string{"-progress=false"}, strings.NewReader(""), &bytes.Buffer{}, &stderr)
+		assert.Equal(t, 2, code)
+		assert.Contains(t, stderr.String(), "no URLs")
+	})
+}
+
+func TestProgress_Line(t *testing.T) {
+	p := &progress{start: time.Unix(0, 0), kinds: map[ErrorKind]int{}}
+	p.now = func() time.Time { return time.Unix(10, 0) }
+
+	p.Write(context.Background(), []ScrapperResult{
+		{BodyLength: 10 << 20},
+		{Error: &FetchError{Kind: KindTimeout}},
+		{Error: &FetchError{Kind: KindServerError}},
+		{Error: &FetchError{Kind: KindTimeout}},
+	})
+	assert.Equal(t, "4 pages, 3 failed (2 timeout, 1 server error) | 0.4 pages/s | 1.0 MB/s | 10s", p.line())
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[float64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KB", 3 << 30: "3.0 GB"} {
+		assert.Equal(t, want, formatBytes(n), fmt.Sprint(n))
+	}
+}
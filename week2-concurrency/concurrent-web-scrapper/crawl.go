@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+var ErrAlreadyStarted = errors.New("scrapper already started")
+
+// CrawlConfig controls which links a crawl follows
+type CrawlConfig struct {
+	// how many links deep to follow from the seeds, 0 only fetches the seeds,
+	// a negative depth resumes with the session's depth and is 0 otherwise
+	MaxDepth int
+	// stop discovering pages once this many have been queued, 0 is unlimited
+	MaxPages int
+	// domains to follow links into besides the seeds' own hosts, subdomains
+	// included, e.g. "example.com" also allows "blog.example.com"
+	AllowedDomains []string
+	// records the frontier as it grows and picks up where an earlier run
+	// of the session stopped, nil keeps everything in memory
+	Session *Session
+}
+
+// fetchTask is a URL waiting for a worker
+type fetchTask struct {
+	url   string
+	depth int
+}
+
+// crawlScope decides whether a link may be followed
+type crawlScope struct {
+	hosts   map[string]bool // seed hosts, with port
+	domains []string
+}
+
+func newCrawlScope(hosts, domains []string) crawlScope {
+	scope := crawlScope{hosts: make(map[string]bool)}
+	for _, host := range hosts {
+		scope.hosts[host] = true
+	}
+	for _, d := range domains {
+		scope.domains = append(scope.domains, strings.ToLower(strings.TrimPrefix(d, ".")))
+	}
+	return scope
+}
+
+func (s crawlScope) allows(u *url.URL) bool {
+	if s.hosts[u.Host] {
+		return true
+	}
+
+	host := u.Hostname()
+	for _, d := range s.domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Crawl fetches the seeds and follows the links found in their HTML, within
+// the scope and limits of cfg
+//
+// Every page is fetched once, a seed that normalises to the same URL as an
+// earlier one is skipped.
+//
+// It replaces Start, Submit and Stop: the results channel is closed once
+// every discovered page has been fetched, or once Stop cancels the crawl.
+func (sc *Scrapper) Crawl(seeds []string, cfg CrawlConfig) error {
+	var parsed []*url.URL
+	for _, seed := range seeds {
+		norm, err := NormalizeURL(seed)
+		if err != nil {
+			return fmt.Errorf("seed %q: %w", seed, err)
+		}
+		u, _ := url.Parse(norm)
+		parsed = append(parsed, u)
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.started {
+		return ErrAlreadyStarted
+	}
+	sc.started = true
+	sc.crawling.Store(true)
+
+	sc.startWorkers()
+	go sc.coordinate(parsed, cfg)
+	return nil
+}
+
+// coordinate owns the frontier: it hands URLs to the workers, collects what
+// they found and ends the crawl once nothing is left in flight
+func (sc *Scrapper) coordinate(seeds []*url.URL, cfg CrawlConfig) {
+	defer close(sc.crawlDone)
+	defer func() {
+		close(sc.urls)
+		sc.wg.Wait()
+		close(sc.results)
+	}()
+
+	var hosts []string
+	for _, seed := range seeds {
+		if !slices.Contains(hosts, seed.Host) {
+			hosts = append(hosts, seed.Host)
+		}
+	}
+	// a resume goes on with the first run's scope, its seeds may be gone
+	if cfg.Session != nil {
+		hosts, cfg = cfg.Session.crawl(hosts, cfg)
+	}
+	cfg.MaxDepth = max(cfg.MaxDepth, 0)
+	scope := newCrawlScope(hosts, cfg.AllowedDomains)
+	visited := make(map[string]bool)
+	var frontier []fetchTask
+	pending := 0 // queued or being fetched
+
+	// takes normalised URLs only
+	enqueue := func(norm string, depth int) {
+		if visited[norm] || (cfg.MaxPages > 0 && len(visited) >= cfg.MaxPages) {
+			return
+		}
+		visited[norm] = true
+		task := fetchTask{url: norm, depth: depth}
+		frontier = append(frontier, task)
+		pending++
+		if cfg.Session != nil {
+			cfg.Session.queue(task)
+		}
+	}
+
+	// a resumed session's URLs are never queued again, only the ones
+	// without a result are fetched
+	if cfg.Session != nil {
+		for _, seen := range cfg.Session.seen() {
+			visited[seen] = true
+		}
+		for _, task := range cfg.Session.pending() {
+			frontier = append(frontier, task)
+			pending++
+		}
+	}
+
+	for _, seed := range seeds {
+		enqueue(seed.String(), 0)
+	}
+
+	for pending > 0 {
+		// only offer a task when there is one, a nil channel never sends
+		var out chan<- fetchTask
+		var next fetchTask
+		if len(frontier) > 0 {
+			out = sc.urls
+			next = frontier[0]
+		}
+
+		select {
+		case out <- next:
+			frontier = frontier[1:]
+		case result := <-sc.crawled:
+			pending--
+
+			if result.Depth < cfg.MaxDepth {
+				for _, link := range result.Links {
+					norm, err := NormalizeURL(link)
+					if err != nil {
+						continue
+					}
+					if u, _ := url.Parse(norm); scope.allows(u) {
+						enqueue(norm, result.Depth+1)
+					}
+				}
+			}
+
+			select {
+			case sc.results <- result:
+			case <-sc.ctx.Done():
+				return
+			}
+		case <-sc.ctx.Done():
+			return
+		}
+	}
+}
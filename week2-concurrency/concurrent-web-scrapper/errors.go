@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrBodyTooLarge        = errors.New("response body too large")
+	ErrUnwantedContentType = errors.New("unwanted content type")
+)
+
+// ErrorKind is the category of a failed fetch
+type ErrorKind int
+
+const (
+	KindOther ErrorKind = iota
+	// the scrapper was stopped
+	KindCanceled
+	// the host name didn't resolve
+	KindDNS
+	// connecting, the request or reading the body took too long
+	KindTimeout
+	// nothing listening on the port
+	KindConnRefused
+	// the connection broke, e.g. reset by the peer
+	KindNetwork
+	// 4xx status
+	KindClientError
+	// 5xx status
+	KindServerError
+	// the body is larger than ScrapperConfig.MaxBodyBytes
+	KindBodyTooLarge
+	// the Content-Type isn't in ScrapperConfig.ContentTypes
+	KindContentType
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindOther:
+		return "other"
+	case KindCanceled:
+		return "canceled"
+	case KindDNS:
+		return "dns"
+	case KindTimeout:
+		return "timeout"
+	case KindConnRefused:
+		return "connection refused"
+	case KindNetwork:
+		return "network"
+	case KindClientError:
+		return "client error"
+	case KindServerError:
+		return "server error"
+	case KindBodyTooLarge:
+		return "body too large"
+	case KindContentType:
+		return "content type"
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+// FetchError is the error of a ScrapperResult whose page couldn't be fetched
+type FetchError struct {
+	Kind ErrorKind
+	// the response status for KindClientError and KindServerError
+	StatusCode int
+	// the underlying error, nil for an error status
+	Err error
+
+	// from the Retry-After header of an error status, 0 when missing
+	retryAfter time.Duration
+	// a DNS failure that may resolve on a later try
+	temporary bool
+}
+
+func (e *FetchError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s: %d %s", e.Kind, e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("%s: %v", e.Kind, e.Err)
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// Transient reports whether trying again later may succeed
+func (e *FetchError) Transient() bool {
+	switch e.Kind {
+	case KindTimeout, KindConnRefused, KindNetwork, KindServerError:
+		return true
+	case KindDNS:
+		return e.temporary
+	case KindClientError:
+		return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// ErrorKindOf returns the category of a ScrapperResult error
+func ErrorKindOf(err error) ErrorKind {
+	var fetchErr *FetchError
+	if errors.As(err, &fetchErr) {
+		return fetchErr.Kind
+	}
+	return KindOther
+}
+
+// classify wraps an error from the client or from reading a body
+func classify(err error) *FetchError {
+	fetchErr := &FetchError{Kind: KindOther, Err: err}
+
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		fetchErr.Kind = KindCanceled
+	case errors.As(err, &dnsErr):
+		fetchErr.Kind = KindDNS
+		fetchErr.temporary = dnsErr.IsTemporary || dnsErr.IsTimeout
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		fetchErr.Kind = KindTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		fetchErr.Kind = KindConnRefused
+	case errors.Is(err, ErrBodyTooLarge):
+		fetchErr.Kind = KindBodyTooLarge
+	case errors.Is(err, ErrUnwantedContentType):
+		fetchErr.Kind = KindContentType
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF),
+		errors.As(err, new(*net.OpError)):
+		fetchErr.Kind = KindNetwork
+	}
+	return fetchErr
+}
+
+// statusError turns a 4xx or 5xx response into an error, nil otherwise
+func statusError(resp *http.Response, now time.Time) *FetchError {
+	var kind ErrorKind
+	switch {
+	case resp.StatusCode >= 500:
+		kind = KindServerError
+	case resp.StatusCode >= 400:
+		kind = KindClientError
+	default:
+		return nil
+	}
+
+	return &FetchError{
+		Kind:       kind,
+		StatusCode: resp.StatusCode,
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), now),
+	}
+}
+
+// parseRetryAfter reads a Retry-After header, which is either a number of
+// seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(secs)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/tidwall/gjson"
+	"golang.org/x/net/html"
+)
+
+var ErrInvalidRule = errors.New("invalid extraction rule")
+
+// SelectorKind is the query language of a Selector
+type SelectorKind string
+
+const (
+	CSS   SelectorKind = "css"
+	XPath SelectorKind = "xpath"
+	// the first capture group of each match, or the whole match without one
+	Regex SelectorKind = "regex"
+	// a gjson path, e.g. "items.#.name", for API responses
+	JSONPath SelectorKind = "json"
+)
+
+// Selector finds values in a page
+type Selector struct {
+	Kind SelectorKind `yaml:"kind"`
+	Expr string       `yaml:"expr"`
+}
+
+// Field is one named value of a record
+type Field struct {
+	Name     string `yaml:"name"`
+	Selector `yaml:",inline"`
+	// CSS and XPath read this attribute instead of the element's text
+	Attr string `yaml:"attr"`
+	// keep every match as a []string instead of the first one, JSON paths
+	// already return arrays when they match several values
+	All bool `yaml:"all"`
+}
+
+// ExtractRule turns the pages whose URL matches URLPattern into records
+type ExtractRule struct {
+	Name string `yaml:"name"`
+	// a regular expression matched against the page URL, empty matches all
+	URLPattern string `yaml:"url_pattern"`
+	// selects the elements that each become a record, fields are then
+	// relative to them. Leave empty for one record per page. Regex can't
+	// select records.
+	Each   Selector `yaml:"each"`
+	Fields []Field  `yaml:"fields"`
+}
+
+// Record is the data one rule extracted, Fields holds a string, a []string
+// or for JSON paths any decoded JSON value. Fields without a match are left
+// out.
+type Record struct {
+	Rule   string         `json:"rule"`
+	Fields map[string]any `json:"fields"`
+}
+
+// compiledSelector is a Selector ready to run
+type compiledSelector struct {
+	kind  SelectorKind
+	css   cascadia.Sel
+	xpath *xpath.Expr
+	regex *regexp.Regexp
+	json  string
+}
+
+type compiledField struct {
+	Field
+	sel compiledSelector
+}
+
+type compiledRule struct {
+	name   string
+	url    *regexp.Regexp // nil matches all
+	each   *compiledSelector
+	fields []compiledField
+}
+
+// Extractor applies extraction rules to fetched pages, it is safe for
+// concurrent use
+type Extractor struct {
+	rules []compiledRule
+}
+
+// NewExtractor compiles rules, failing on the first invalid pattern or
+// selector
+func NewExtractor(rules []ExtractRule) (*Extractor, error) {
+	ex := &Extractor{}
+
+	for _, rule := range rules {
+		cr := compiledRule{name: rule.Name}
+		invalid := func(format string, args ...any) error {
+			return fmt.Errorf("%w %q: %s", ErrInvalidRule, rule.Name, fmt.Sprintf(format, args...))
+		}
+
+		if rule.URLPattern != "" {
+			re, err := regexp.Compile(rule.URLPattern)
+			if err != nil {
+				return nil, invalid("url pattern: %v", err)
+			}
+			cr.url = re
+		}
+
+		if rule.Each.Expr != "" {
+			if rule.Each.Kind == Regex {
+				return nil, invalid("records can't be selected with a regex")
+			}
+			sel, err := compileSelector(rule.Each)
+			if err != nil {
+				return nil, invalid("each: %v", err)
+			}
+			cr.each = &sel
+		}
+
+		if len(rule.Fields) == 0 {
+			return nil, invalid("no fields")
+		}
+		for _, field := range rule.Fields {
+			sel, err := compileSelector(field.Selector)
+			if err != nil {
+				return nil, invalid("field %q: %v", field.Name, err)
+			}
+			cr.fields = append(cr.fields, compiledField{Field: field, sel: sel})
+		}
+
+		ex.rules = append(ex.rules, cr)
+	}
+	return ex, nil
+}
+
+func compileSelector(s Selector) (compiledSelector, error) {
+	cs := compiledSelector{kind: s.Kind}
+	var err error
+
+	switch s.Kind {
+	case CSS:
+		cs.css, err = cascadia.Parse(s.Expr)
+	case XPath:
+		cs.xpath, err = xpath.Compile(s.Expr)
+	case Regex:
+		cs.regex, err = regexp.Compile(s.Expr)
+	case JSONPath:
+		if s.Expr == "" {
+			err = errors.New("empty JSON path")
+		}
+		cs.json = s.Expr
+	default:
+		err = fmt.Errorf("unknown selector kind %q", s.Kind)
+	}
+	return cs, err
+}
+
+// page is a fetched body, parsed as HTML on first use
+type page struct {
+	body []byte
+	doc  *html.Node
+}
+
+func (p *page) html() *html.Node {
+	if p.doc == nil {
+		doc, err := html.Parse(bytes.NewReader(p.body))
+		if err != nil {
+			doc = &html.Node{Type: html.DocumentNode}
+		}
+		p.doc = doc
+	}
+	return p.doc
+}
+
+// matches reports whether any rule applies to url, other pages don't need
+// their body kept for Extract
+func (ex *Extractor) matches(url string) bool {
+	for _, rule := range ex.rules {
+		if rule.url == nil || rule.url.MatchString(url) {
+			return true
+		}
+	}
+	return false
+}
+
+// Extract returns the records of every rule matching url
+func (ex *Extractor) Extract(url string, body []byte) []Record {
+	p := &page{body: body}
+
+	var records []Record
+	for _, rule := range ex.rules {
+		if rule.url != nil && !rule.url.MatchString(url) {
+			continue
+		}
+
+		if rule.each == nil {
+			if fields := rule.extract(scope{page: p}); len(fields) > 0 {
+				records = append(records, Record{Rule: rule.name, Fields: fields})
+			}
+			continue
+		}
+
+		for _, s := range rule.each.scopes(p) {
+			if fields := rule.extract(s); len(fields) > 0 {
+				records = append(records, Record{Rule: rule.name, Fields: fields})
+			}
+		}
+	}
+	return records
+}
+
+// scope is what fields are evaluated against: the whole page, an element
+// selected by Each or a JSON value selected by Each
+type scope struct {
+	page *page
+	node *html.Node
+	json *gjson.Result
+}
+
+func (s scope) root() *html.Node {
+	if s.node != nil {
+		return s.node
+	}
+	return s.page.html()
+}
+
+func (s scope) text() string {
+	switch {
+	case s.json != nil:
+		return s.json.Raw
+	case s.node != nil:
+		return htmlquery.OutputHTML(s.node, true)
+	}
+	return string(s.page.body)
+}
+
+// scopes returns one scope per record selected by an Each selector
+func (cs compiledSelector) scopes(p *page) []scope {
+	var scopes []scope
+
+	switch cs.kind {
+	case CSS, XPath:
+		for _, node := range cs.nodes(p.html()) {
+			scopes = append(scopes, scope{page: p, node: node})
+		}
+	case JSONPath:
+		for _, item := range gjson.GetBytes(p.body, cs.json).Array() {
+			scopes = append(scopes, scope{page: p, json: &item})
+		}
+	}
+	return scopes
+}
+
+func (cs compiledSelector) nodes(root *html.Node) []*html.Node {
+	if cs.kind == CSS {
+		return cascadia.QueryAll(root, cs.css)
+	}
+	return htmlquery.QuerySelectorAll(root, cs.xpath)
+}
+
+func (r compiledRule) extract(s scope) map[string]any {
+	fields := make(map[string]any)
+	for _, f := range r.fields {
+		if v, ok := f.value(s); ok {
+			fields[f.Name] = v
+		}
+	}
+	return fields
+}
+
+func (f compiledField) value(s scope) (any, bool) {
+	var values []string
+
+	switch f.sel.kind {
+	case CSS, XPath:
+		if s.json != nil {
+			return nil, false
+		}
+		for _, node := range f.sel.nodes(s.root()) {
+			if v, ok := f.nodeValue(node); ok {
+				values = append(values, v)
+			}
+		}
+	case Regex:
+		for _, m := range f.sel.regex.FindAllStringSubmatch(s.text(), -1) {
+			if len(m) > 1 {
+				values = append(values, m[1])
+			} else {
+				values = append(values, m[0])
+			}
+		}
+	case JSONPath:
+		var res gjson.Result
+		if s.json != nil {
+			res = s.json.Get(f.sel.json)
+		} else {
+			res = gjson.GetBytes(s.page.body, f.sel.json)
+		}
+		if !res.Exists() {
+			return nil, false
+		}
+		return res.Value(), true
+	}
+
+	switch {
+	case len(values) == 0:
+		return nil, false
+	case f.All:
+		return values, true
+	}
+	return values[0], true
+}
+
+// nodeValue is the attribute or the whitespace collapsed text of a node
+func (f compiledField) nodeValue(node *html.Node) (string, bool) {
+	if f.Attr == "" {
+		return strings.Join(strings.Fields(htmlquery.InnerText(node)), " "), true
+	}
+	for _, attr := range node.Attr {
+		if attr.Key == f.Attr {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const productsPage = `<html><head><title> Shop  </title></head><body>
+<ul>
+  <li class="product" data-sku="A1"><span class="name">Kettle</span> <span class="price">$20</span></li>
+  <li class="product" data-sku="B2"><span class="name">Toaster</span> <span class="price">$35</span></li>
+  <li class="product" data-sku="C3"><span class="name">Mug</span></li>
+</ul>
+<a href="/next">next</a> <a href="/prev">prev</a>
+<p>Contact: sales@example.com or help@example.com</p>
+</body></html>`
+
+const productsJSON = `{"total": 2, "items": [{"id": 1, "name": "Kettle", "tags": ["kitchen"]}, {"id": 2, "name": "Toaster"}]}`
+
+func TestExtractor_Extract(t *testing.T) {
+	tests := []struct {
+		name string
+		rule ExtractRule
+		body string
+		want []map[string]any
+	}{
+		{
+			name: "css page fields",
+			rule: ExtractRule{Fields: []Field{
+				{Name: "title", Selector: Selector{Kind: CSS, Expr: "title"}},
+				{Name: "links", Selector: Selector{Kind: CSS, Expr: "a"}, Attr: "href", All: true},
+				{Name: "missing", Selector: Selector{Kind: CSS, Expr: "h1"}},
+			}},
+			body: productsPage,
+			want: []map[string]any{{"title": "Shop", "links": []string{"/next", "/prev"}}},
+		},
+		{
+			name: "css records",
+			rule: ExtractRule{
+				Each: Selector{Kind: CSS, Expr: "li.product"},
+				Fields: []Field{
+					{Name: "name", Selector: Selector{Kind: CSS, Expr: ".name"}},
+					{Name: "price", Selector: Selector{Kind: CSS, Expr: ".price"}},
+				},
+			},
+			body: productsPage,
+			want: []map[string]any{
+				{"name": "Kettle", "price": "$20"},
+				{"name": "Toaster", "price": "$35"},
+				{"name": "Mug"},
+			},
+		},
+		{
+			name: "xpath records with attributes",
+			rule: ExtractRule{
+				Each: Selector{Kind: XPath, Expr: "//li[@class='product']"},
+				Fields: []Field{
+					{Name: "sku", Selector: Selector{Kind: XPath, Expr: "."}, Attr: "data-sku"},
+					{Name: "name", Selector: Selector{Kind: XPath, Expr: ".//span[@class='name']"}},
+				},
+			},
+			body: productsPage,
+			want: []map[string]any{
+				{"sku": "A1", "name": "Kettle"},
+				{"sku": "B2", "name": "Toaster"},
+				{"sku": "C3", "name": "Mug"},
+			},
+		},
+		{
+			name: "xpath attribute nodes",
+			rule: ExtractRule{Fields: []Field{
+				{Name: "links", Selector: Selector{Kind: XPath, Expr: "//a/@href"}, All: true},
+			}},
+			body: productsPage,
+			want: []map[string]any{{"links": []string{"/next", "/prev"}}},
+		},
+		{
+			name: "regex with and without a group",
+			rule: ExtractRule{Fields: []Field{
+				{Name: "emails", Selector: Selector{Kind: Regex, Expr: `[a-z]+@example\.com`}, All: true},
+				{Name: "user", Selector: Selector{Kind: Regex, Expr: `([a-z]+)@example\.com`}},
+			}},
+			body: productsPage,
+			want: []map[string]any{{"emails": []string{"sales@example.com", "help@example.com"}, "user": "sales"}},
+		},
+		{
+			name: "regex within records",
+			rule: ExtractRule{
+				Each:   Selector{Kind: CSS, Expr: "li.product"},
+				Fields: []Field{{Name: "price", Selector: Selector{Kind: Regex, Expr: `\$(\d+)`}}},
+			},
+			body: productsPage,
+			want: []map[string]any{{"price": "20"}, {"price": "35"}},
+		},
+		{
+			name: "json page fields",
+			rule: ExtractRule{Fields: []Field{
+				{Name: "total", Selector: Selector{Kind: JSONPath, Expr: "total"}},
+				{Name: "names", Selector: Selector{Kind: JSONPath, Expr: "items.#.name"}},
+			}},
+			body: productsJSON,
+			want: []map[string]any{{"total": float64(2), "names": []any{"Kettle", "Toaster"}}},
+		},
+		{
+			name: "json records",
+			rule: ExtractRule{
+				Each: Selector{Kind: JSONPath, Expr: "items"},
+				Fields: []Field{
+					{Name: "id", Selector: Selector{Kind: JSONPath, Expr: "id"}},
+					{Name: "tags", Selector: Selector{Kind: JSONPath, Expr: "tags"}},
+				},
+			},
+			body: productsJSON,
+			want: []map[string]any{{"id": float64(1), "tags": []any{"kitchen"}}, {"id": float64(2)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name = tt.name
+			ex, err := NewExtractor([]ExtractRule{tt.rule})
+			require.NoError(t, err)
+
+			var got []map[string]any
+			for _, r := range ex.Extract("http://example.com/", []byte(tt.body)) {
+				assert.Equal(t, tt.name, r.Rule)
+				got = append(got, r.Fields)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewExtractor_Invalid(t *testing.T) {
+	field := Field{Name: "f", Selector: Selector{Kind: CSS, Expr: "p"}}
+
+	tests := []struct {
+		name string
+		rule ExtractRule
+	}{
+		{name: "url pattern", rule: ExtractRule{URLPattern: "(", Fields: []Field{field}}},
+		{name: "css", rule: ExtractRule{Fields: []Field{{Name: "f", Selector: Selector{Kind: CSS, Expr: "p["}}}}},
+		{name: "xpath", rule: ExtractRule{Fields: []Field{{Name: "f", Selector: Selector{Kind: XPath, Expr: "//p["}}}}},
+		{name: "regex", rule: ExtractRule{Fields: []Field{{Name: "f", Selector: Selector{Kind: Regex, Expr: "(["}}}}},
+		{name: "unknown kind", rule: ExtractRule{Fields: []Field{{Name: "f", Selector: Selector{Kind: "jq", Expr: "."}}}}},
+		{name: "regex records", rule: ExtractRule{Each: Selector{Kind: Regex, Expr: "."}, Fields: []Field{field}}},
+		{name: "no fields", rule: ExtractRule{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewExtractor([]ExtractRule{tt.rule})
+			assert.ErrorIs(t, err, ErrInvalidRule)
+		})
+	}
+}
+
+func TestScrapper_ExtractsByURLPattern(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/products":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, productsJSON)
+		case "/shop":
+			fmt.Fprint(w, productsPage)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ex, err := NewExtractor([]ExtractRule{
+		{
+			Name:       "api",
+			URLPattern: `/api/`,
+			Each:       Selector{Kind: JSONPath, Expr: "items"},
+			Fields:     []Field{{Name: "name", Selector: Selector{Kind: JSONPath, Expr: "name"}}},
+		},
+		{
+			Name:       "shop",
+			URLPattern: `/shop$`,
+			Fields:     []Field{{Name: "title", Selector: Selector{Kind: CSS, Expr: "title"}}},
+		},
+	})
+	require.NoError(t, err)
+
+	sc := NewScrapperWithConfig(ScrapperConfig{Workers: 1, Timeout: time.Second, RPS: 100, IgnoreRobots: true, Extractor: ex})
+
+	api := sc.ScrapeURL(srv.URL + "/api/products")
+	require.NoError(t, api.Error)
+	assert.Equal(t, []Record{
+		{Rule: "api", Fields: map[string]any{"name": "Kettle"}},
+		{Rule: "api", Fields: map[string]any{"name": "Toaster"}},
+	}, api.Records)
+
+	shop := sc.ScrapeURL(srv.URL + "/shop")
+	require.NoError(t, shop.Error)
+	assert.Equal(t, []Record{{Rule: "shop", Fields: map[string]any{"title": "Shop"}}}, shop.Records)
+
+	missing := sc.ScrapeURL(srv.URL + "/api/missing")
+	assert.Error(t, missing.Error)
+	assert.Empty(t, missing.Records, "failed pages aren't extracted")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// hostSlot paces the requests to one host and bounds how many are in flight
+type hostSlot struct {
+	limiter *rate.Limiter
+	conns   chan struct{} // nil when connections aren't limited
+
+	delayOnce sync.Once
+}
+
+// hostLimits hands out a hostSlot per host
+type hostLimits struct {
+	rps      float64
+	maxConns int
+
+	mu    sync.Mutex
+	hosts map[string]*hostSlot
+}
+
+func newHostLimits(rps float64, maxConns int) *hostLimits {
+	return &hostLimits{
+		rps:      rps,
+		maxConns: maxConns,
+		hosts:    make(map[string]*hostSlot),
+	}
+}
+
+func (h *hostLimits) slot(host string) *hostSlot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	slot, ok := h.hosts[host]
+	if !ok {
+		limit := rate.Inf
+		if h.rps > 0 {
+			limit = rate.Limit(h.rps)
+		}
+		slot = &hostSlot{limiter: rate.NewLimiter(limit, 1)}
+		if h.maxConns > 0 {
+			slot.conns = make(chan struct{}, h.maxConns)
+		}
+		h.hosts[host] = slot
+	}
+	return slot
+}
+
+// acquire waits until a request to host may start, crawlDelay from the
+// host's robots.txt slows the host down further if it is stricter than
+// the configured rate. The returned func must be called once the response
+// has been read.
+func (h *hostLimits) acquire(ctx context.Context, host string, crawlDelay time.Duration) (func(), error) {
+	slot := h.slot(host)
+
+	if crawlDelay > 0 {
+		slot.delayOnce.Do(func() {
+			if delayed := rate.Every(crawlDelay); delayed < slot.limiter.Limit() {
+				slot.limiter.SetLimit(delayed)
+			}
+		})
+	}
+
+	if slot.conns != nil {
+		select {
+		case slot.conns <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	release := func() {
+		if slot.conns != nil {
+			<-slot.conns
+		}
+	}
+
+	if err := slot.limiter.Wait(ctx); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scrapeAll submits every URL and waits for all the results
+func scrapeAll(t *testing.T, sc *Scrapper, urls []string) []ScrapperResult {
+	t.Helper()
+
+	sc.Start()
+	go func() {
+		for _, u := range urls {
+			require.NoError(t, sc.Submit(u))
+		}
+		sc.Stop()
+	}()
+
+	var results []ScrapperResult
+	for r := range sc.Results() {
+		require.NoError(t, r.Error)
+		results = append(results, r)
+	}
+	return results
+}
+
+func TestScrapper_MaxConnsPerHost(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	})
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	sc := NewScrapperWithConfig(ScrapperConfig{Workers: 10, Timeout: time.Second, RPS: 1000, MaxConnsPerHost: 2})
+	var urls []string
+	for i := range 10 {
+		urls = append(urls, fmt.Sprintf("%s/%d", srv.URL, i))
+	}
+
+	assert.Len(t, scrapeAll(t, sc, urls), 10)
+	assert.Equal(t, int32(2), peak.Load())
+}
+
+func TestScrapper_HostRateLimits(t *testing.T) {
+	// records when each host saw its requests
+	var mu sync.Mutex
+	seen := make(map[string][]time.Time)
+	server := func(robots string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/robots.txt" {
+				fmt.Fprint(w, robots)
+				return
+			}
+			mu.Lock()
+			seen[r.Host] = append(seen[r.Host], time.Now())
+			mu.Unlock()
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+
+	fast := server("")
+	slow := server("User-agent: *\nCrawl-delay: 0.1\n")
+
+	sc := NewScrapperWithConfig(ScrapperConfig{Workers: 8, Timeout: time.Second, RPS: 1000, HostRPS: 50})
+	var urls []string
+	for i := range 5 {
+		urls = append(urls, fmt.Sprintf("%s/%d", fast.URL, i), fmt.Sprintf("%s/%d", slow.URL, i))
+	}
+	scrapeAll(t, sc, urls)
+
+	minGap := func(times []time.Time) time.Duration {
+		gap := time.Hour
+		for i := 1; i < len(times); i++ {
+			gap = min(gap, times[i].Sub(times[i-1]))
+		}
+		return gap
+	}
+
+	fastTimes := seen[fast.Listener.Addr().String()]
+	slowTimes := seen[slow.Listener.Addr().String()]
+	require.Len(t, fastTimes, 5)
+	require.Len(t, slowTimes, 5)
+
+	// 50 requests per second is a request every 20ms, leave some slack
+	assert.GreaterOrEqual(t, minGap(fastTimes), 15*time.Millisecond)
+	assert.GreaterOrEqual(t, minGap(slowTimes), 90*time.Millisecond, "crawl delay is stricter than HostRPS")
+	// hosts are paced independently
+	assert.Less(t, fastTimes[4].Sub(fastTimes[0]), 300*time.Millisecond)
+}
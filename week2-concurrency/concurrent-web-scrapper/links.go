@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var ErrUnsupportedScheme = errors.New("only http and https URLs can be crawled")
+
+// NormalizeURL reduces a URL to a canonical form so the same page is only
+// crawled once: the scheme and host are lowercased, default ports and
+// fragments dropped, dot segments resolved and query parameters sorted
+func NormalizeURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+	return normalize(u)
+}
+
+func normalize(u *url.URL) (string, error) {
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", ErrUnsupportedScheme
+	}
+
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host += ":" + port
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]" // IPv6 literal
+	}
+	u.Host = host
+
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	// resolving against the root cleans up ./ and ../ segments
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Path = u.ResolveReference(&url.URL{Path: u.Path}).Path
+	u.RawPath = ""
+
+	if u.RawQuery != "" {
+		u.RawQuery = u.Query().Encode()
+	}
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
+
+// extractLinks returns the absolute URLs of the links in an HTML document,
+// relative links are resolved against base or the document's <base href>
+//
+// The document is tokenized as it is read, it stops at the first read error.
+func extractLinks(base *url.URL, body io.Reader) []string {
+	var links []string
+	seen := make(map[string]bool)
+
+	z := html.NewTokenizer(body)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return links
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		name, hasAttr := z.TagName()
+		if !hasAttr {
+			continue
+		}
+		tag := string(name)
+		if tag != "a" && tag != "area" && tag != "base" {
+			continue
+		}
+
+		var href, rel string
+		for {
+			key, val, more := z.TagAttr()
+			switch string(key) {
+			case "href":
+				href = string(val)
+			case "rel":
+				rel = strings.ToLower(string(val))
+			}
+			if !more {
+				break
+			}
+		}
+		if href == "" {
+			continue
+		}
+
+		ref, err := url.Parse(strings.TrimSpace(href))
+		if err != nil {
+			continue
+		}
+		if tag == "base" {
+			base = base.ResolveReference(ref)
+			continue
+		}
+		if strings.Contains(rel, "nofollow") {
+			continue
+		}
+
+		link := base.ResolveReference(ref)
+		if link.Scheme != "http" && link.Scheme != "https" {
+			continue // mailto:, javascript: and friends
+		}
+		if s := link.String(); !seen[s] {
+			seen[s] = true
+			links = append(links, s)
+		}
+	}
+}
@@ -4,9 +4,15 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -18,36 +24,181 @@ type ScrapperResult struct {
 	BodyLength int
 	Duration   time.Duration
 	Error      error
+	// links from the seeds, 0 for the seeds themselves
+	Depth int
+	// absolute links found on the page, only collected when crawling
+	Links []string
+	// tries made, more than 1 when transient failures were retried
+	Attempts int
+	// data pulled out of the page by ScrapperConfig.Extractor
+	Records []Record
+	// how ScrapperConfig.Cache answered, CacheOff without one
+	Cache CacheStatus
+	// hex SHA-256 of the body
+	ContentHash string
+	// whether the body differs from the cached copy
+	Change ChangeStatus
+	// when the last attempt ended
+	FetchedAt time.Time
+}
+
+// DefaultUserAgent identifies the scrapper when ScrapperConfig doesn't
+const DefaultUserAgent = "roadmap-scrapper/1.0"
+
+// ScrapperConfig holds the settings of a Scrapper
+type ScrapperConfig struct {
+	Workers int
+	// per request, including reading the body
+	Timeout time.Duration
+	// requests per second over all hosts, 0 is unlimited
+	RPS int
+	// requests per second to any single host, 0 is unlimited
+	HostRPS float64
+	// requests in flight to any single host, 0 is unlimited
+	MaxConnsPerHost int
+	// sent with every request and matched against robots.txt groups
+	UserAgent string
+	// skip fetching and obeying robots.txt
+	IgnoreRobots bool
+	// retries of transient failures, the zero value uses DefaultRetryPolicy
+	Retry RetryPolicy
+	// responses with a larger body fail with KindBodyTooLarge, 0 is unlimited
+	MaxBodyBytes int64
+	// media types to download, like "text/html" or "text/*", others fail
+	// with KindContentType before their body is read, none allows all
+	ContentTypes []string
+	// bytes of bodies held in memory at once over all workers, workers wait
+	// for room before reading a body they have to keep, 0 is unlimited
+	MemoryBudget int64
+	// turns pages into records, see NewExtractor
+	Extractor *Extractor
+	// sent with every request, User-Agent is set by UserAgent instead
+	Headers http.Header
+	// requests take turns going through these, none uses the environment's
+	// HTTP_PROXY and HTTPS_PROXY
+	Proxies []*url.URL
+	// keeps responses to answer or revalidate later requests, nil disables
+	// caching
+	Cache CacheStore
 }
 
 type Scrapper struct {
-	numWorkers int
-	urls       chan string
-	results    chan ScrapperResult
-	ctx        context.Context
-	cancel     context.CancelFunc
-	wg         sync.WaitGroup
-	client     *http.Client
-	limiter    *rate.Limiter
+	numWorkers   int
+	urls         chan fetchTask
+	results      chan ScrapperResult
+	ctx          context.Context
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
+	client       *http.Client
+	limiter      *rate.Limiter
+	userAgent    string
+	headers      http.Header
+	hosts        *hostLimits
+	robots       *robotsCache // nil when robots.txt is ignored
+	retry        RetryPolicy
+	maxBody      int64
+	memory       *memoryBudget // nil when unlimited
+	contentTypes []string
+	sleep        func(ctx context.Context, d time.Duration) error
+	extractor    *Extractor        // nil when nothing is extracted
+	cache        *cachingTransport // nil without a cache
+
+	mu        sync.Mutex // guards started
+	started   bool
+	crawling  atomic.Bool         // set once by Crawl, read by workers, Submit and Stop
+	crawled   chan ScrapperResult // workers -> crawl coordinator
+	crawlDone chan struct{}
 }
 
 func NewScrapper(numWorkers int, timeout time.Duration, rps int) *Scrapper {
+	return NewScrapperWithConfig(ScrapperConfig{
+		Workers: numWorkers,
+		Timeout: timeout,
+		RPS:     rps,
+	})
+}
+
+func NewScrapperWithConfig(cfg ScrapperConfig) *Scrapper {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &Scrapper{
-		client: &http.Client{
-			Timeout: timeout,
-		},
-		numWorkers: numWorkers,
-		urls:       make(chan string, 1000),
-		results:    make(chan ScrapperResult, 100),
-		ctx:        ctx,
-		cancel:     cancel,
-		limiter:    rate.NewLimiter(rate.Limit(rps), rps),
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = DefaultUserAgent
+	}
+	if cfg.Retry.MaxAttempts == 0 {
+		cfg.Retry = DefaultRetryPolicy
 	}
+	var transport http.RoundTripper = newTransport(cfg.Proxies)
+	var cache *cachingTransport
+	if cfg.Cache != nil {
+		cache = newCachingTransport(transport, cfg.Cache)
+		transport = cache
+	}
+	client := &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: transport,
+	}
+	var contentTypes []string
+	for _, t := range cfg.ContentTypes {
+		contentTypes = append(contentTypes, strings.ToLower(strings.TrimSpace(t)))
+	}
+	limiter := rate.NewLimiter(rate.Inf, 0)
+	if cfg.RPS > 0 {
+		limiter = rate.NewLimiter(rate.Limit(cfg.RPS), cfg.RPS)
+	}
+
+	sc := &Scrapper{
+		client:       client,
+		numWorkers:   cfg.Workers,
+		urls:         make(chan fetchTask, 1000),
+		results:      make(chan ScrapperResult, 100),
+		ctx:          ctx,
+		cancel:       cancel,
+		limiter:      limiter,
+		userAgent:    cfg.UserAgent,
+		headers:      cfg.Headers,
+		hosts:        newHostLimits(cfg.HostRPS, cfg.MaxConnsPerHost),
+		retry:        cfg.Retry,
+		maxBody:      cfg.MaxBodyBytes,
+		memory:       newMemoryBudget(cfg.MemoryBudget),
+		contentTypes: contentTypes,
+		sleep:        sleep,
+		extractor:    cfg.Extractor,
+		cache:        cache,
+		crawled:      make(chan ScrapperResult),
+		crawlDone:    make(chan struct{}),
+	}
+	if !cfg.IgnoreRobots {
+		sc.robots = newRobotsCache(client, cfg.UserAgent, sc.hosts, limiter, cfg.Retry)
+	}
+	return sc
+}
+
+// newTransport is the default transport with the proxies taking turns
+func newTransport(proxies []*url.URL) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(proxies) == 0 {
+		return transport
+	}
+
+	var next atomic.Uint64
+	transport.Proxy = func(*http.Request) (*url.URL, error) {
+		return proxies[(next.Add(1)-1)%uint64(len(proxies))], nil
+	}
+	return transport
 }
 
 func (sc *Scrapper) Start() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.started {
+		return
+	}
+	sc.started = true
+	sc.startWorkers()
+}
+
+func (sc *Scrapper) startWorkers() {
 	for i := range sc.numWorkers {
 		sc.wg.Add(1)
 		go sc.Worker(i)
@@ -59,15 +210,22 @@ func (sc *Scrapper) Worker(workerID int) {
 
 	for {
 		select {
-		case url, ok := <-sc.urls:
+		case task, ok := <-sc.urls:
 			if !ok {
 				return
 			}
 
-			result := sc.ScrapeURL(url)
+			result := sc.ScrapeURL(task.url)
+			result.Depth = task.depth
+
+			// while crawling the coordinator looks at the links first
+			out := sc.results
+			if sc.crawling.Load() {
+				out = sc.crawled
+			}
 
 			select {
-			case sc.results <- result:
+			case out <- result:
 			case <-sc.ctx.Done():
 				return
 			}
@@ -77,40 +235,118 @@ func (sc *Scrapper) Worker(workerID int) {
 	}
 }
 
-func (sc *Scrapper) ScrapeURL(url string) ScrapperResult {
+func (sc *Scrapper) ScrapeURL(rawURL string) ScrapperResult {
 	start := time.Now()
 
 	result := ScrapperResult{
-		URL: url,
+		URL: rawURL,
 	}
-
-	sc.limiter.Wait(sc.ctx)
-
-	resp, err := sc.client.Get(url)
-	if err != nil {
-		result.Duration = time.Since(start)
+	fail := func(err error) ScrapperResult {
+		result.FetchedAt = time.Now()
+		result.Duration = result.FetchedAt.Sub(start)
 		result.Error = err
 		return result
 	}
 
+	req, err := http.NewRequestWithContext(sc.ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return fail(err)
+	}
+	for key, values := range sc.headers {
+		req.Header[http.CanonicalHeaderKey(key)] = values
+	}
+	req.Header.Set("User-Agent", sc.userAgent)
+
+	var crawlDelay time.Duration
+	if sc.robots != nil {
+		rules, err := sc.robots.rules(sc.ctx, req.URL)
+		if err != nil {
+			return fail(classify(err))
+		}
+		if !rules.allowed(req.URL.RequestURI()) {
+			return fail(ErrDisallowedByRobots)
+		}
+		crawlDelay = rules.crawlDelay
+	}
+
+	for {
+		result.Attempts++
+
+		resp, page, err := sc.fetch(rawURL, req, crawlDelay)
+		if err == nil {
+			result.StatusCode = resp.StatusCode
+			result.BodyLength = page.length
+			result.ContentHash = page.hash
+			var previous string
+			result.Cache, previous = cacheInfo(resp)
+			result.Change = changeOf(previous, result.ContentHash)
+			result.Links = page.links
+			result.Records = page.records
+			result.FetchedAt = time.Now()
+			result.Duration = result.FetchedAt.Sub(start)
+			return result
+		}
+
+		if resp != nil {
+			result.StatusCode = resp.StatusCode
+		}
+		delay, retry := sc.retry.retryDelay(result.Attempts, err, rand.Float64)
+		if !retry {
+			return fail(err)
+		}
+		if err := sc.sleep(sc.ctx, delay); err != nil {
+			return fail(classify(err))
+		}
+	}
+}
+
+// fetch makes one attempt at req within the host's and the global limits,
+// a 4xx or 5xx status is returned as a *FetchError along with the response
+func (sc *Scrapper) fetch(rawURL string, req *http.Request, crawlDelay time.Duration) (*http.Response, pageBody, error) {
+	// a fresh cached copy doesn't reach the host, so it isn't held back
+	req = req.Clone(sc.ctx)
+	resp, entry := sc.cache.lookup(req)
+	if resp == nil {
+		release, err := sc.hosts.acquire(sc.ctx, strings.ToLower(req.URL.Host), crawlDelay)
+		if err != nil {
+			return nil, pageBody{}, classify(err)
+		}
+		defer release()
+
+		if err := sc.limiter.Wait(sc.ctx); err != nil {
+			return nil, pageBody{}, classify(err)
+		}
+
+		if sc.cache != nil {
+			req = withLookup(req, entry)
+		}
+		resp, err = sc.client.Do(req)
+		if err != nil {
+			return nil, pageBody{}, classify(err)
+		}
+	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		result.Duration = time.Since(start)
-		result.Error = err
-		return result
+	if fetchErr := statusError(resp, time.Now()); fetchErr != nil {
+		// drain a little so the connection can be reused
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+		return resp, pageBody{}, fetchErr
 	}
 
-	result.StatusCode = resp.StatusCode
-	result.BodyLength = len(body)
-	result.Duration = time.Since(start)
-	return result
+	page, err := sc.readBody(rawURL, resp)
+	if err != nil {
+		return resp, pageBody{}, classify(err)
+	}
+	return resp, page, nil
 }
 
 func (sc *Scrapper) Submit(url string) error {
+	if sc.crawling.Load() {
+		return fmt.Errorf("Scrapper is crawling, URLs come from the crawl")
+	}
+
 	select {
-	case sc.urls <- url:
+	case sc.urls <- fetchTask{url: url}:
 		return nil
 	case <-sc.ctx.Done():
 		return fmt.Errorf("Scrapper Shutting down")
@@ -118,82 +354,30 @@ func (sc *Scrapper) Submit(url string) error {
 }
 
 func (sc *Scrapper) Stop() {
-	fmt.Println("==========Stopping Scrapper==========")
+	if sc.crawling.Load() {
+		// the coordinator owns urls and results, cancel and let it clean up
+		sc.cancel()
+		<-sc.crawlDone
+		return
+	}
+
 	close(sc.urls)
 	sc.wg.Wait()
 	close(sc.results)
 	sc.cancel()
-	fmt.Println("==========Scrapper Stopped===========")
 }
 
 func (sc *Scrapper) Results() <-chan ScrapperResult {
 	return sc.results
 }
 
-func generateURLs(count int) []string {
-	urls := make([]string, count)
-	for i := range count {
-		urls[i] = fmt.Sprintf("https://httpbin.org/delay/%d", i%5)
-	}
-	return urls
+func isHTML(resp *http.Response) bool {
+	return strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html")
 }
 
 func main() {
-	fmt.Println("Web Scrapper")
-
-	scrapper := NewScrapper(50, 60*time.Second, 50)
-
-	scrapper.Start()
-
-	urls := generateURLs(1000)
-
-	submitDone := make(chan struct{})
-	go func() {
-		for _, url := range urls {
-			if err := scrapper.Submit(url); err != nil {
-				fmt.Println("Error Submitting URL: ", url, ", Error: ", err)
-			}
-		}
-
-		close(submitDone)
-	}()
-
-	go func() {
-		<-submitDone
-		scrapper.Stop()
-	}()
-
-	var (
-		successCount  int
-		failedCount   int
-		totalDuration time.Duration
-	)
-
-	fmt.Println("Scrapping Results: ")
-	fmt.Println(strings.Repeat("=", 80))
-
-	for result := range scrapper.Results() {
-		if result.Error != nil {
-			failedCount++
-			fmt.Printf("❌ %s - Error: %v\n", result.URL, result.Error)
-		} else {
-			successCount++
-			totalDuration += result.Duration
-			fmt.Printf("✅ %s - Status %d, Size: %d bytes, Time: %v\n", result.URL, result.StatusCode, result.BodyLength, result.Duration)
-		}
-	}
-
-	// print statistics
-	fmt.Println()
-	fmt.Println("Statistics:")
-	fmt.Printf("  Total URLs: %d \n", len(urls))
-	fmt.Printf("  Success: %d\n", successCount)
-	fmt.Printf("  Failed: %d\n", failedCount)
-
-	if successCount > 0 {
-		avgDuration := totalDuration / time.Duration(successCount)
-		fmt.Printf("  Average Duration: %v\n", avgDuration)
-	}
-
-	fmt.Println("\nScrapping Complete")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
 }
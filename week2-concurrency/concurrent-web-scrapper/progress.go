@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progress is a Sink that keeps a live status line up to date
+type progress struct {
+	out   io.Writer
+	start time.Time
+	now   func() time.Time
+
+	mu     sync.Mutex
+	pages  int
+	failed int
+	bytes  int64
+	kinds  map[ErrorKind]int
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// isTerminal reports whether w is a terminal, the status line's carriage
+// returns only make sense on one
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// newProgress redraws the status line on out every interval until closed
+func newProgress(out io.Writer, interval time.Duration) *progress {
+	p := &progress{
+		out:     out,
+		start:   time.Now(),
+		now:     time.Now,
+		kinds:   make(map[ErrorKind]int),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(p.stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.draw()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *progress) Write(ctx context.Context, batch []ScrapperResult) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, r := range batch {
+		p.pages++
+		p.bytes += int64(r.BodyLength)
+		if r.Error != nil {
+			p.failed++
+			p.kinds[ErrorKindOf(r.Error)]++
+		}
+	}
+	return nil
+}
+
+// Close draws the final state and ends the line
+func (p *progress) Close() error {
+	close(p.stop)
+	<-p.stopped
+	p.draw()
+	fmt.Fprintln(p.out)
+	return nil
+}
+
+func (p *progress) draw() {
+	// \r and clearing the line keep the status on a single line
+	fmt.Fprint(p.out, "\r\033[K"+p.line())
+}
+
+// line renders e.g. "120 pages, 3 failed (2 timeout, 1 server error) |
+// 12.0 pages/s | 1.5 MB/s | 10s"
+func (p *progress) line() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	elapsed := p.now().Sub(p.start)
+	secs := max(elapsed.Seconds(), 0.001)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d pages, %d failed", p.pages, p.failed)
+	if p.failed > 0 {
+		var kinds []string
+		for _, kind := range slices.Sorted(maps.Keys(p.kinds)) {
+			kinds = append(kinds, fmt.Sprintf("%d %s", p.kinds[kind], kind))
+		}
+		fmt.Fprintf(&b, " (%s)", strings.Join(kinds, ", "))
+	}
+	fmt.Fprintf(&b, " | %.1f pages/s | %s/s | %s", float64(p.pages)/secs, formatBytes(float64(p.bytes)/secs), elapsed.Round(time.Second))
+	return b.String()
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "1.5 MB"
+func formatBytes(n float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// RetryPolicy decides whether and when a failed fetch is attempted again,
+// only transient failures are retried
+type RetryPolicy struct {
+	// total attempts including the first one, 1 disables retries
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// growth factor between attempts, defaults to 2
+	Multiplier float64
+	// fraction of the backoff that is randomised, 0 is no jitter and 1 is
+	// full jitter
+	Jitter float64
+	// a Retry-After longer than this gives up instead of waiting, 0 waits
+	// as long as the server asks
+	MaxRetryAfter time.Duration
+}
+
+// DefaultRetryPolicy is used when ScrapperConfig.Retry is left empty
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Jitter:         0.5,
+	MaxRetryAfter:  time.Minute,
+}
+
+// retryDelay returns how long to wait before trying again after the
+// attempt-th try failed with err, false means give up. random returns a
+// number in [0, 1).
+func (rp RetryPolicy) retryDelay(attempt int, err error, random func() float64) (time.Duration, bool) {
+	var fetchErr *FetchError
+	if attempt >= rp.MaxAttempts || !errors.As(err, &fetchErr) || !fetchErr.Transient() {
+		return 0, false
+	}
+
+	// the server knows best when it will be ready again
+	if fetchErr.retryAfter > 0 {
+		if rp.MaxRetryAfter > 0 && fetchErr.retryAfter > rp.MaxRetryAfter {
+			return 0, false
+		}
+		return fetchErr.retryAfter, true
+	}
+	return rp.backoff(attempt, random), true
+}
+
+// backoff returns how long to wait after the attempt-th try
+func (rp RetryPolicy) backoff(attempt int, random func() float64) time.Duration {
+	multiplier := rp.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	delay := float64(rp.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if rp.MaxBackoff > 0 && delay > float64(rp.MaxBackoff) {
+		delay = float64(rp.MaxBackoff)
+	}
+
+	jitter := min(max(rp.Jitter, 0), 1)
+	delay = delay*(1-jitter) + delay*jitter*random()
+	return time.Duration(delay)
+}
+
+// sleep waits for d unless ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
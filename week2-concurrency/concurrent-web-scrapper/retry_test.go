@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestScrapper returns a scrapper that records its backoff sleeps
+// instead of waiting
+func newTestScrapper(cfg ScrapperConfig) (*Scrapper, *[]time.Duration) {
+	cfg.Workers = max(cfg.Workers, 1)
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Second
+	}
+	cfg.RPS = max(cfg.RPS, 1000)
+	cfg.IgnoreRobots = true
+
+	sc := NewScrapperWithConfig(cfg)
+	var sleeps []time.Duration
+	sc.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return ctx.Err()
+	}
+	return sc, &sleeps
+}
+
+func TestScrapeURL_ErrorKinds(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			fmt.Fprint(w, "ok")
+		case "/missing":
+			http.NotFound(w, r)
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/huge":
+			fmt.Fprint(w, strings.Repeat("x", 2048))
+		}
+	}))
+	defer srv.Close()
+
+	// a port nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	refused := "http://" + ln.Addr().String()
+	ln.Close()
+
+	tests := []struct {
+		url    string
+		kind   ErrorKind
+		status int
+	}{
+		{url: srv.URL + "/missing", kind: KindClientError, status: 404},
+		{url: srv.URL + "/broken", kind: KindServerError, status: 500},
+		{url: srv.URL + "/slow", kind: KindTimeout},
+		{url: srv.URL + "/huge", kind: KindBodyTooLarge, status: 200},
+		{url: refused, kind: KindConnRefused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind.String(), func(t *testing.T) {
+			sc, _ := newTestScrapper(ScrapperConfig{
+				Timeout:      50 * time.Millisecond,
+				MaxBodyBytes: 1024,
+				Retry:        RetryPolicy{MaxAttempts: 1},
+			})
+
+			result := sc.ScrapeURL(tt.url)
+			require.Error(t, result.Error)
+			assert.Equal(t, tt.kind, ErrorKindOf(result.Error), result.Error.Error())
+			assert.Equal(t, tt.status, result.StatusCode)
+			assert.Equal(t, 1, result.Attempts)
+		})
+	}
+
+	sc, _ := newTestScrapper(ScrapperConfig{MaxBodyBytes: 1024})
+	result := sc.ScrapeURL(srv.URL + "/ok")
+	assert.NoError(t, result.Error)
+	assert.Equal(t, 200, result.StatusCode)
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		kind      ErrorKind
+		transient bool
+	}{
+		{name: "canceled", err: context.Canceled, kind: KindCanceled},
+		{name: "no such host", err: &net.DNSError{Err: "no such host", IsNotFound: true}, kind: KindDNS},
+		{name: "dns timeout", err: &net.DNSError{Err: "timeout", IsTimeout: true}, kind: KindDNS, transient: true},
+		{name: "deadline", err: context.DeadlineExceeded, kind: KindTimeout, transient: true},
+		{name: "body too large", err: ErrBodyTooLarge, kind: KindBodyTooLarge},
+		{name: "other", err: fmt.Errorf("unsupported protocol scheme"), kind: KindOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classify(tt.err)
+			assert.Equal(t, tt.kind, err.Kind)
+			assert.Equal(t, tt.transient, err.Transient())
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 7*time.Second, parseRetryAfter("7", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(now.Add(-time.Hour).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	rp := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Jitter: 0.5}
+	half := func() float64 { return 0.5 }
+
+	assert.Equal(t, 75*time.Millisecond, rp.backoff(1, half))
+	assert.Equal(t, 150*time.Millisecond, rp.backoff(2, half))
+	assert.Equal(t, 300*time.Millisecond, rp.backoff(3, half))
+	assert.Equal(t, 750*time.Millisecond, rp.backoff(10, half), "capped at MaxBackoff")
+}
+
+func TestScrapeURL_Retries(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 4, InitialBackoff: 100 * time.Millisecond, MaxRetryAfter: 10 * time.Second}
+
+	t.Run("transient failures are retried with backoff", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, "ok")
+		}))
+		defer srv.Close()
+
+		sc, sleeps := newTestScrapper(ScrapperConfig{Retry: policy})
+		result := sc.ScrapeURL(srv.URL)
+		require.NoError(t, result.Error)
+		assert.Equal(t, 3, result.Attempts)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, *sleeps)
+	})
+
+	t.Run("gives up after MaxAttempts", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer srv.Close()
+
+		sc, sleeps := newTestScrapper(ScrapperConfig{Retry: policy})
+		result := sc.ScrapeURL(srv.URL)
+		assert.Equal(t, KindServerError, ErrorKindOf(result.Error))
+		assert.Equal(t, 4, result.Attempts)
+		assert.Len(t, *sleeps, 3)
+	})
+
+	t.Run("client errors aren't retried", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		defer srv.Close()
+
+		sc, sleeps := newTestScrapper(ScrapperConfig{Retry: policy})
+		result := sc.ScrapeURL(srv.URL)
+		assert.Equal(t, KindClientError, ErrorKindOf(result.Error))
+		assert.Equal(t, 1, result.Attempts)
+		assert.Empty(t, *sleeps)
+	})
+
+	t.Run("Retry-After is honoured", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				w.Header().Set("Retry-After", "3")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			fmt.Fprint(w, "ok")
+		}))
+		defer srv.Close()
+
+		sc, sleeps := newTestScrapper(ScrapperConfig{Retry: policy})
+		result := sc.ScrapeURL(srv.URL)
+		require.NoError(t, result.Error)
+		assert.Equal(t, 2, result.Attempts)
+		assert.Equal(t, []time.Duration{3 * time.Second}, *sleeps)
+	})
+
+	t.Run("a Retry-After past MaxRetryAfter gives up", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		sc, sleeps := newTestScrapper(ScrapperConfig{Retry: policy})
+		result := sc.ScrapeURL(srv.URL)
+		assert.Equal(t, KindServerError, ErrorKindOf(result.Error))
+		assert.Equal(t, 1, result.Attempts)
+		assert.Empty(t, *sleeps)
+	})
+
+	t.Run("stopping ends the backoff", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		sc, _ := newTestScrapper(ScrapperConfig{Retry: policy})
+		sc.sleep = sleep
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			sc.cancel()
+		}()
+
+		result := sc.ScrapeURL(srv.URL)
+		assert.Equal(t, KindCanceled, ErrorKindOf(result.Error))
+	})
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var ErrDisallowedByRobots = errors.New("disallowed by robots.txt")
+
+// robots.txt files larger than this are truncated, like Google does
+const maxRobotsSize = 500 << 10
+
+// robotsRule is one Allow or Disallow line
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// robotsRules are the rules of the group that applies to our user agent
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+var (
+	allowAll    = robotsRules{}
+	disallowAll = robotsRules{rules: []robotsRule{{allow: false, pattern: "/"}}}
+)
+
+// allowed reports whether path, the escaped path and query of a URL, may
+// be fetched: the longest matching pattern wins and Allow wins a tie
+func (r robotsRules) allowed(path string) bool {
+	best, allow := -1, true
+	for _, rule := range r.rules {
+		if !matchRobotsPattern(rule.pattern, path) {
+			continue
+		}
+		if n := len(rule.pattern); n > best || (n == best && rule.allow) {
+			best, allow = n, rule.allow
+		}
+	}
+	return allow
+}
+
+// matchRobotsPattern matches a path prefix where * matches any run of
+// characters and a trailing $ anchors the end of the path
+func matchRobotsPattern(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	if len(parts) == 1 {
+		return !anchored || rest == ""
+	}
+
+	for i, part := range parts[1:] {
+		last := i == len(parts)-2
+		if last && anchored {
+			return strings.HasSuffix(rest, part)
+		}
+		idx := strings.Index(rest, part)
+		if idx < 0 {
+			return false
+		}
+		rest = rest[idx+len(part):]
+	}
+	return true
+}
+
+// parseRobots picks the rules for userAgent out of a robots.txt file
+//
+// The group naming our product token wins over the * group, groups for the
+// same agent are merged, and unknown lines are ignored.
+func parseRobots(body []byte, userAgent string) robotsRules {
+	token := strings.ToLower(userAgent)
+	if i := strings.IndexAny(token, "/ "); i >= 0 {
+		token = token[:i]
+	}
+
+	var specific, wildcard robotsRules
+	var foundSpecific bool
+	var agents []string // of the group being read
+	inRules := false    // a rule ends the run of user-agent lines
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		if key == "user-agent" {
+			if inRules {
+				agents, inRules = nil, false
+			}
+			agents = append(agents, strings.ToLower(value))
+			continue
+		}
+
+		var targets []*robotsRules
+		for _, agent := range agents {
+			switch {
+			case agent == "*":
+				targets = append(targets, &wildcard)
+			case agent == token:
+				targets = append(targets, &specific)
+				foundSpecific = true
+			}
+		}
+		inRules = true
+
+		for _, group := range targets {
+			switch key {
+			case "allow", "disallow":
+				if value == "" {
+					continue // "Disallow:" allows everything
+				}
+				group.rules = append(group.rules, robotsRule{allow: key == "allow", pattern: value})
+			case "crawl-delay":
+				if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+					group.crawlDelay = time.Duration(secs * float64(time.Second))
+				}
+			}
+		}
+	}
+
+	if foundSpecific {
+		return specific
+	}
+	return wildcard
+}
+
+// robotsFailureTTL is how long disallow-all stands in for a robots.txt that
+// couldn't be fetched, after that the next lookup tries again
+const robotsFailureTTL = time.Minute
+
+// robotsEntry is the robots.txt of one site, ready is closed once rules is set
+type robotsEntry struct {
+	ready chan struct{}
+	rules robotsRules
+	// zero for a robots.txt that was read, kept for the whole run
+	expires time.Time
+}
+
+// robotsCache fetches each site's robots.txt once and shares it between
+// workers, concurrent lookups for the same site wait for a single fetch
+//
+// The fetches wait for the same host and global limits as pages do, and
+// transient failures are retried like pages are.
+type robotsCache struct {
+	client    *http.Client
+	userAgent string
+	hosts     *hostLimits
+	limiter   *rate.Limiter
+	retry     RetryPolicy
+	sleep     func(ctx context.Context, d time.Duration) error
+	now       func() time.Time
+
+	mu    sync.Mutex
+	sites map[string]*robotsEntry // by scheme://host
+}
+
+func newRobotsCache(client *http.Client, userAgent string, hosts *hostLimits, limiter *rate.Limiter, retry RetryPolicy) *robotsCache {
+	return &robotsCache{
+		client:    client,
+		userAgent: userAgent,
+		hosts:     hosts,
+		limiter:   limiter,
+		retry:     retry,
+		sleep:     sleep,
+		now:       time.Now,
+		sites:     make(map[string]*robotsEntry),
+	}
+}
+
+// rules returns the rules that apply to u's site
+func (c *robotsCache) rules(ctx context.Context, u *url.URL) (robotsRules, error) {
+	site := u.Scheme + "://" + u.Host
+
+	c.mu.Lock()
+	entry, ok := c.sites[site]
+	if ok && !entry.expires.IsZero() && !c.now().Before(entry.expires) {
+		ok = false
+	}
+	if !ok {
+		entry = &robotsEntry{ready: make(chan struct{})}
+		c.sites[site] = entry
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		rules, err := c.fetchRetrying(ctx, site, strings.ToLower(u.Host))
+		// other lookups check expires under mu, rules is only read once
+		// ready is closed
+		c.mu.Lock()
+		entry.rules = rules
+		if err != nil {
+			entry.expires = c.now().Add(robotsFailureTTL)
+		}
+		c.mu.Unlock()
+		close(entry.ready)
+	}
+
+	select {
+	case <-entry.ready:
+		return entry.rules, nil
+	case <-ctx.Done():
+		return robotsRules{}, ctx.Err()
+	}
+}
+
+// fetchRetrying retries transient failures by the retry policy, once it
+// gives up it returns disallow-all and the last error
+func (c *robotsCache) fetchRetrying(ctx context.Context, site, host string) (robotsRules, error) {
+	for attempt := 1; ; attempt++ {
+		rules, err := c.fetch(ctx, site, host)
+		if err == nil {
+			return rules, nil
+		}
+
+		delay, retry := c.retry.retryDelay(attempt, err, rand.Float64)
+		if !retry {
+			return disallowAll, err
+		}
+		if err := c.sleep(ctx, delay); err != nil {
+			return disallowAll, err
+		}
+	}
+}
+
+// fetch downloads and parses robots.txt following RFC 9309: a missing
+// file allows everything, an unreachable one disallows everything and is
+// returned as an error
+func (c *robotsCache) fetch(ctx context.Context, site, host string) (robotsRules, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, site+"/robots.txt", nil)
+	if err != nil {
+		return disallowAll, err
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+
+	release, err := c.hosts.acquire(ctx, host, 0)
+	if err != nil {
+		return disallowAll, classify(err)
+	}
+	defer release()
+	if err := c.limiter.Wait(ctx); err != nil {
+		return disallowAll, classify(err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return disallowAll, classify(err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return disallowAll, statusError(resp, c.now())
+	case resp.StatusCode >= 400:
+		return allowAll, nil
+	case resp.StatusCode >= 300:
+		// the client follows redirects, one that is left over went nowhere
+		return allowAll, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsSize))
+	if err != nil {
+		return disallowAll, classify(err)
+	}
+	return parseRobots(body, c.userAgent), nil
+}
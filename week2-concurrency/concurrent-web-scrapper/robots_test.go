@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestMatchRobotsPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/", "/anything", true},
+		{"/private", "/private/page", true},
+		{"/private", "/privateer", true},
+		{"/private/", "/private", false},
+		{"/*.pdf", "/docs/file.pdf", true},
+		{"/*.pdf", "/docs/file.pdf?download=1", true},
+		{"/*.pdf$", "/docs/file.pdf?download=1", false},
+		{"/*.pdf$", "/docs/file.pdf", true},
+		{"/a$", "/a", true},
+		{"/a$", "/ab", false},
+		{"/a*b*c", "/a-x-b-y-c", true},
+		{"/a*b*c", "/a-x-c-y-b", false},
+		{"/search?q=", "/search?q=go", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchRobotsPattern(tt.pattern, tt.path))
+		})
+	}
+}
+
+func TestParseRobots(t *testing.T) {
+	body := []byte(`
+# comments and blank lines are ignored
+User-agent: *
+Disallow: /private
+Allow: /private/public
+Crawl-delay: 2
+
+User-agent: otherbot
+User-agent: TestBot
+Disallow: /              # everything
+Allow: /open
+Crawl-delay: 0.5
+
+User-agent: testbot
+Disallow: /also-merged
+Sitemap: https://example.com/sitemap.xml
+`)
+
+	t.Run("specific group wins and same agent groups are merged", func(t *testing.T) {
+		rules := parseRobots(body, "TestBot/2.0 (+https://example.com)")
+		assert.Equal(t, 500*time.Millisecond, rules.crawlDelay)
+		assert.False(t, rules.allowed("/"))
+		assert.True(t, rules.allowed("/open/page"))
+		assert.False(t, rules.allowed("/also-merged"))
+	})
+
+	t.Run("falls back to the wildcard group", func(t *testing.T) {
+		rules := parseRobots(body, "somebot")
+		assert.Equal(t, 2*time.Second, rules.crawlDelay)
+		assert.True(t, rules.allowed("/"))
+		assert.False(t, rules.allowed("/private/page"))
+		assert.True(t, rules.allowed("/private/public/page"), "longer allow wins")
+	})
+
+	t.Run("empty disallow allows everything", func(t *testing.T) {
+		rules := parseRobots([]byte("User-agent: *\nDisallow:\n"), "somebot")
+		assert.True(t, rules.allowed("/anything"))
+	})
+
+	t.Run("allow wins a tie", func(t *testing.T) {
+		rules := parseRobots([]byte("User-agent: *\nDisallow: /page\nAllow: /page\n"), "somebot")
+		assert.True(t, rules.allowed("/page"))
+	})
+}
+
+func TestRobotsCache_FetchStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		allowed bool
+	}{
+		{name: "missing file allows everything", status: http.StatusNotFound, allowed: true},
+		{name: "forbidden file allows everything", status: http.StatusForbidden, allowed: true},
+		{name: "server error disallows everything", status: http.StatusServiceUnavailable, allowed: false},
+		{name: "rate limited disallows everything", status: http.StatusTooManyRequests, allowed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/robots.txt" {
+					w.WriteHeader(tt.status)
+				}
+			}))
+			defer srv.Close()
+
+			sc := NewScrapperWithConfig(ScrapperConfig{Workers: 1, Timeout: time.Second, RPS: 100, Retry: RetryPolicy{MaxAttempts: 1}})
+			result := sc.ScrapeURL(srv.URL + "/page")
+			if tt.allowed {
+				assert.NoError(t, result.Error)
+			} else {
+				assert.ErrorIs(t, result.Error, ErrDisallowedByRobots)
+			}
+		})
+	}
+}
+
+func TestRobotsCache_TransientFailure(t *testing.T) {
+	var failures, robotsFetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			robotsFetches.Add(1)
+			if failures.Add(-1) >= 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintln(w, "User-agent: *\nDisallow: /private")
+		}
+	}))
+	defer srv.Close()
+
+	t.Run("retried by the retry policy", func(t *testing.T) {
+		failures.Store(1)
+		robotsFetches.Store(0)
+		sc := NewScrapperWithConfig(ScrapperConfig{Workers: 1, Timeout: time.Second, RPS: 100, Retry: RetryPolicy{MaxAttempts: 2}})
+
+		assert.NoError(t, sc.ScrapeURL(srv.URL+"/page").Error)
+		assert.ErrorIs(t, sc.ScrapeURL(srv.URL+"/private").Error, ErrDisallowedByRobots)
+		assert.Equal(t, int32(2), robotsFetches.Load())
+	})
+
+	t.Run("disallows only for a while", func(t *testing.T) {
+		failures.Store(1)
+		robotsFetches.Store(0)
+		sc := NewScrapperWithConfig(ScrapperConfig{Workers: 1, Timeout: time.Second, RPS: 100, Retry: RetryPolicy{MaxAttempts: 1}})
+		clock := time.Now()
+		sc.robots.now = func() time.Time { return clock }
+
+		assert.ErrorIs(t, sc.ScrapeURL(srv.URL+"/page").Error, ErrDisallowedByRobots)
+		assert.ErrorIs(t, sc.ScrapeURL(srv.URL+"/page").Error, ErrDisallowedByRobots, "the failure is cached")
+		assert.Equal(t, int32(1), robotsFetches.Load())
+
+		clock = clock.Add(robotsFailureTTL)
+		assert.NoError(t, sc.ScrapeURL(srv.URL+"/page").Error)
+		assert.Equal(t, int32(2), robotsFetches.Load())
+
+		clock = clock.Add(time.Hour)
+		assert.NoError(t, sc.ScrapeURL(srv.URL+"/page").Error)
+		assert.Equal(t, int32(2), robotsFetches.Load(), "a robots.txt that was read is kept")
+	})
+}
+
+func TestRobotsCache_ConcurrentFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	// run with -race, lookups check the expiry while a fetch is recorded
+	u, err := url.Parse(srv.URL + "/page")
+	require.NoError(t, err)
+	cache := newRobotsCache(srv.Client(), DefaultUserAgent, newHostLimits(0, 0), rate.NewLimiter(rate.Inf, 0), RetryPolicy{MaxAttempts: 1})
+	var mu sync.Mutex
+	clock := time.Now()
+	cache.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		// every lookup finds the last failure expired
+		clock = clock.Add(robotsFailureTTL)
+		return clock
+	}
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(func() {
+			for range 10 {
+				rules, err := cache.rules(context.Background(), u)
+				assert.NoError(t, err)
+				assert.False(t, rules.allowed("/page"))
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestRobotsCache_HostLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	}))
+	defer srv.Close()
+
+	// the robots.txt fetch takes the host's only token, the page waits
+	sc := NewScrapperWithConfig(ScrapperConfig{Workers: 1, Timeout: time.Second, HostRPS: 5})
+	start := time.Now()
+	require.NoError(t, sc.ScrapeURL(srv.URL+"/page").Error)
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
+
+func TestScrapper_Robots(t *testing.T) {
+	var robotsFetches, pageFetches atomic.Int32
+	var mu sync.Mutex
+	var agents []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents = append(agents, r.Header.Get("User-Agent"))
+		mu.Unlock()
+		if r.URL.Path == "/robots.txt" {
+			robotsFetches.Add(1)
+			fmt.Fprintln(w, "User-agent: politebot\nDisallow: /admin\n\nUser-agent: *\nDisallow: /")
+			return
+		}
+		pageFetches.Add(1)
+		fmt.Fprintln(w, "ok")
+	}))
+	defer srv.Close()
+
+	t.Run("rules for our user agent apply", func(t *testing.T) {
+		sc := NewScrapperWithConfig(ScrapperConfig{Workers: 4, Timeout: time.Second, RPS: 100, UserAgent: "PoliteBot/1.0"})
+		sc.Start()
+		for _, path := range []string{"/a", "/b", "/admin/users", "/c"} {
+			require.NoError(t, sc.Submit(srv.URL+path))
+		}
+		go sc.Stop()
+
+		disallowed := 0
+		for r := range sc.Results() {
+			if r.Error != nil {
+				assert.ErrorIs(t, r.Error, ErrDisallowedByRobots)
+				assert.Contains(t, r.URL, "/admin")
+				disallowed++
+			}
+		}
+		assert.Equal(t, 1, disallowed)
+		assert.Equal(t, int32(1), robotsFetches.Load(), "robots.txt is fetched once per site")
+		assert.Equal(t, int32(3), pageFetches.Load())
+
+		mu.Lock()
+		defer mu.Unlock()
+		require.Len(t, agents, 4)
+		for _, agent := range agents {
+			assert.Equal(t, "PoliteBot/1.0", agent)
+		}
+	})
+
+	t.Run("other agents fall back to the wildcard group", func(t *testing.T) {
+		sc := NewScrapper(1, time.Second, 100)
+		assert.ErrorIs(t, sc.ScrapeURL(srv.URL+"/a").Error, ErrDisallowedByRobots)
+	})
+
+	t.Run("ignored when configured", func(t *testing.T) {
+		sc := NewScrapperWithConfig(ScrapperConfig{Workers: 1, Timeout: time.Second, RPS: 100, IgnoreRobots: true})
+		assert.NoError(t, sc.ScrapeURL(srv.URL+"/a").Error)
+	})
+}
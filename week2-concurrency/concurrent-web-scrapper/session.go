@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSessionExists   = errors.New("session already exists, resume it or pick another directory")
+	ErrNoSessionToLoad = errors.New("no session to resume")
+)
+
+const sessionLog = "session.log"
+
+const (
+	opQueue = "queue" // a URL joined the frontier
+	opDone  = "done"  // its result reached every sink
+	opScope = "scope" // a crawl started, the last one wins
+)
+
+type sessionEntry struct {
+	Op     string        `json:"op"`
+	URL    string        `json:"url,omitempty"`
+	Depth  int           `json:"depth,omitempty"`
+	Result *ResultRow    `json:"result,omitempty"`
+	Scope  *sessionScope `json:"scope,omitempty"`
+}
+
+// sessionScope is what a resumed crawl needs besides its frontier to follow
+// links like the first run did, which may have had seeds the resume hasn't
+type sessionScope struct {
+	Hosts    []string `json:"hosts"`
+	Domains  []string `json:"domains,omitempty"`
+	MaxDepth int      `json:"max_depth"`
+	MaxPages int      `json:"max_pages,omitempty"`
+}
+
+// Session keeps the state of a scrape on disk so it can be resumed: every
+// URL that joins the frontier and every result that is done is appended to
+// a log
+//
+// Appends are buffered and made durable at each checkpoint, a crash loses
+// at most the entries since the last one, so a page may be fetched again on
+// resume but never skipped. A line torn by the crash is cut off when the
+// session is resumed. The log is compacted when the session is loaded and
+// when it is closed, dropping the entries that resumes and refetches repeat.
+//
+// Pass the session in CrawlConfig and add it as the last sink of
+// WriteResults, so pages only count as done once the other sinks have them.
+type Session struct {
+	path string
+
+	mu      sync.Mutex
+	file    *os.File
+	w       *bufio.Writer
+	err     error       // first write error, reported by Checkpoint
+	order   []fetchTask // queued URLs, oldest first
+	visited map[string]bool
+	done    map[string]bool
+	scope   *sessionScope // nil until a crawl started
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// CreateSession starts a new session in dir, which may already exist but
+// must not hold a session
+func CreateSession(dir string, checkpointEvery time.Duration) (*Session, error) {
+	path := filepath.Join(dir, sessionLog)
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("%s: %w", dir, ErrSessionExists)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s, err := openSession(path, checkpointEvery)
+	if err != nil {
+		return nil, err
+	}
+	// the new log has to survive a crash too
+	if err := syncDir(dir); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// ResumeSession loads the session in dir
+func ResumeSession(dir string, checkpointEvery time.Duration) (*Session, error) {
+	path := filepath.Join(dir, sessionLog)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", dir, ErrNoSessionToLoad)
+	}
+	return openSession(path, checkpointEvery)
+}
+
+func openSession(path string, checkpointEvery time.Duration) (*Session, error) {
+	s := &Session{
+		path:    path,
+		visited: make(map[string]bool),
+		done:    make(map[string]bool),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	size, err := s.replay()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.truncate(size); err != nil {
+		return nil, err
+	}
+	if err := compactSessionLog(path); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s.file = file
+	s.w = bufio.NewWriter(file)
+
+	go s.checkpointLoop(checkpointEvery)
+	return s, nil
+}
+
+// replay applies the log and returns the size of its complete lines
+func (s *Session) replay() (int64, error) {
+	return readSessionLog(s.path, s.apply)
+}
+
+// readSessionLog passes every entry of the log at path to fn and returns the
+// size of its complete lines
+func readSessionLog(path string, fn func(sessionEntry)) (int64, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// anything without a trailing newline is a torn write
+			return size, nil
+		}
+
+		var e sessionEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return 0, fmt.Errorf("session %s: line %d: %w", path, lineNo, err)
+		}
+		fn(e)
+		size += int64(len(line))
+	}
+}
+
+// compactSessionLog rewrites the log at path with the last scope, the first
+// queue entry of every URL and the last result of every done one, a torn
+// last line is dropped
+//
+// The new log is written next to the old one and renamed over it, so a
+// crash leaves one or the other.
+func compactSessionLog(path string) error {
+	var scope *sessionScope
+	var queued, done []sessionEntry
+	visited := make(map[string]bool)
+	doneAt := make(map[string]int) // url -> index in done
+	_, err := readSessionLog(path, func(e sessionEntry) {
+		switch e.Op {
+		case opScope:
+			scope = e.Scope
+		case opQueue:
+			if !visited[e.URL] {
+				visited[e.URL] = true
+				queued = append(queued, e)
+			}
+		case opDone:
+			if i, ok := doneAt[e.URL]; ok {
+				done[i] = e
+				return
+			}
+			doneAt[e.URL] = len(done)
+			done = append(done, e)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if scope != nil {
+		if err := enc.Encode(sessionEntry{Op: opScope, Scope: scope}); err != nil {
+			return err
+		}
+	}
+	for _, e := range slices.Concat(queued, done) {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	file, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	file.Close()
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func (s *Session) apply(e sessionEntry) {
+	switch e.Op {
+	case opQueue:
+		if !s.visited[e.URL] {
+			s.visited[e.URL] = true
+			s.order = append(s.order, fetchTask{url: e.URL, depth: e.Depth})
+		}
+	case opDone:
+		s.done[e.URL] = true
+	case opScope:
+		s.scope = e.Scope
+	}
+}
+
+// truncate cuts a line torn by a crash off the end of the log, so new
+// entries don't get appended to it
+func (s *Session) truncate(size int64) error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if info.Size() == size {
+		return nil
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := file.Truncate(size); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// pending returns the queued URLs without a result yet, oldest first
+func (s *Session) pending() []fetchTask {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tasks []fetchTask
+	for _, task := range s.order {
+		if !s.done[task.url] {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+// seen returns every URL queued so far, done or not
+func (s *Session) seen() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	urls := make([]string, 0, len(s.order))
+	for _, task := range s.order {
+		urls = append(urls, task.url)
+	}
+	return urls
+}
+
+// Done returns how many URLs have their result stored
+func (s *Session) Done() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.done)
+}
+
+// crawl merges the scope of the crawl starting now with the saved one and
+// records the result: hosts and domains add up, a negative depth and a page
+// limit this run leaves at 0 are taken from the saved scope
+func (s *Session) crawl(hosts []string, cfg CrawlConfig) ([]string, CrawlConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if saved := s.scope; saved != nil {
+		hosts = union(saved.Hosts, hosts)
+		cfg.AllowedDomains = union(saved.Domains, cfg.AllowedDomains)
+		if cfg.MaxDepth < 0 {
+			cfg.MaxDepth = saved.MaxDepth
+		}
+		if cfg.MaxPages == 0 {
+			cfg.MaxPages = saved.MaxPages
+		}
+	}
+
+	cfg.MaxDepth = max(cfg.MaxDepth, 0)
+	e := sessionEntry{Op: opScope, Scope: &sessionScope{
+		Hosts:    hosts,
+		Domains:  cfg.AllowedDomains,
+		MaxDepth: cfg.MaxDepth,
+		MaxPages: cfg.MaxPages,
+	}}
+	s.apply(e)
+	s.append(e)
+	return hosts, cfg
+}
+
+// union appends the values of b missing from a
+func union(a, b []string) []string {
+	out := slices.Clone(a)
+	for _, v := range b {
+		if !slices.Contains(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// queue records a URL joining the frontier
+func (s *Session) queue(task fetchTask) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := sessionEntry{Op: opQueue, URL: task.url, Depth: task.depth}
+	s.apply(e)
+	s.append(e)
+}
+
+// Write marks the batch's URLs as done, with their results
+func (s *Session) Write(ctx context.Context, batch []ScrapperResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range batch {
+		row := newResultRow(r)
+		e := sessionEntry{Op: opDone, URL: r.URL, Result: &row}
+		s.apply(e)
+		s.append(e)
+	}
+	return s.err
+}
+
+// append buffers an entry, must be called with s.mu held
+func (s *Session) append(e sessionEntry) {
+	if s.err != nil {
+		return
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		s.err = err
+		return
+	}
+	line = append(line, '\n')
+	if _, err := s.w.Write(line); err != nil {
+		s.err = err
+	}
+}
+
+// Checkpoint makes every entry so far durable
+func (s *Session) Checkpoint() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	if err := s.w.Flush(); err != nil {
+		s.err = err
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		s.err = err
+	}
+	return s.err
+}
+
+func (s *Session) checkpointLoop(every time.Duration) {
+	defer close(s.stopped)
+	if every <= 0 {
+		<-s.stop
+		return
+	}
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Checkpoint()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close takes a last checkpoint, closes the log and compacts it
+func (s *Session) Close() error {
+	close(s.stop)
+	<-s.stopped
+
+	err := errors.Join(s.Checkpoint(), s.file.Close())
+	if err != nil {
+		// compacting would make the missing entries look deliberate
+		return err
+	}
+	return compactSessionLog(s.path)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_CreateAndResume(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "session")
+
+	s, err := CreateSession(dir, 0)
+	require.NoError(t, err)
+	s.queue(fetchTask{url: "http://a/", depth: 0})
+	s.queue(fetchTask{url: "http://a/1", depth: 1})
+	s.queue(fetchTask{url: "http://a/2", depth: 1})
+	s.queue(fetchTask{url: "http://a/1", depth: 1}) // already there
+	require.NoError(t, s.Write(context.Background(), []ScrapperResult{{URL: "http://a/1", StatusCode: 200}}))
+	require.NoError(t, s.Close())
+
+	_, err = CreateSession(dir, 0)
+	assert.ErrorIs(t, err, ErrSessionExists)
+	_, err = ResumeSession(filepath.Join(t.TempDir(), "none"), 0)
+	assert.ErrorIs(t, err, ErrNoSessionToLoad)
+
+	s, err = ResumeSession(dir, 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	assert.Equal(t, 1, s.Done())
+	assert.Equal(t, []string{"http://a/", "http://a/1", "http://a/2"}, s.seen())
+	assert.Equal(t, []fetchTask{{url: "http://a/", depth: 0}, {url: "http://a/2", depth: 1}}, s.pending())
+
+	data, err := os.ReadFile(filepath.Join(dir, sessionLog))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"result":{"url":"http://a/1","status_code":200`, "results are kept")
+}
+
+func TestSession_Checkpoints(t *testing.T) {
+	dir := t.TempDir()
+	s, err := CreateSession(dir, 10*time.Millisecond)
+	require.NoError(t, err)
+	defer s.Close()
+
+	s.queue(fetchTask{url: "http://a/"})
+
+	// without a Close the entry only reaches the file through a checkpoint
+	assert.Eventually(t, func() bool {
+		data, _ := os.ReadFile(filepath.Join(dir, sessionLog))
+		return strings.Contains(string(data), "http://a/")
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestSession_TornWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, sessionLog)
+	log := `{"op":"queue","url":"http://a/"}` + "\n" +
+		`{"op":"queue","url":"http://a/1","depth":1}` + "\n" +
+		`{"op":"done","url":"http://a/"` // the crash hit mid line
+	require.NoError(t, os.WriteFile(path, []byte(log), 0o644))
+
+	s, err := ResumeSession(dir, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, s.Done(), "the torn entry doesn't count")
+	assert.Len(t, s.pending(), 2)
+
+	// new entries start on a fresh line
+	require.NoError(t, s.Write(context.Background(), []ScrapperResult{{URL: "http://a/"}}))
+	require.NoError(t, s.Close())
+
+	s, err = ResumeSession(dir, 0)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, []fetchTask{{url: "http://a/1", depth: 1}}, s.pending())
+}
+
+func TestSession_CorruptLog(t *testing.T) {
+	dir := t.TempDir()
+	log := `{"op":"queue","url":"http://a/"}` + "\n" + "garbage\n" + `{"op":"queue","url":"http://a/1"}` + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, sessionLog), []byte(log), 0o644))
+
+	_, err := ResumeSession(dir, 0)
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestSession_Compacts(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, sessionLog)
+	// two runs, the second refetched /1 and changed the scope
+	log := `{"op":"scope","scope":{"hosts":["a"],"max_depth":1}}` + "\n" +
+		`{"op":"queue","url":"http://a/"}` + "\n" +
+		`{"op":"queue","url":"http://a/1","depth":1}` + "\n" +
+		`{"op":"done","url":"http://a/1","result":{"url":"http://a/1","status_code":500}}` + "\n" +
+		`{"op":"scope","scope":{"hosts":["a"],"max_depth":2}}` + "\n" +
+		`{"op":"queue","url":"http://a/1","depth":1}` + "\n" +
+		`{"op":"done","url":"http://a/1","result":{"url":"http://a/1","status_code":200}}` + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(log), 0o644))
+
+	lines := func() []string {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+
+	s, err := ResumeSession(dir, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`{"op":"scope","scope":{"hosts":["a"],"max_depth":2}}`,
+		`{"op":"queue","url":"http://a/"}`,
+		`{"op":"queue","url":"http://a/1","depth":1}`,
+		`{"op":"done","url":"http://a/1","result":{"url":"http://a/1","status_code":200,"body_length":0,"duration_ms":0,"depth":0,"attempts":0}}`,
+	}, lines(), "compacted on load")
+
+	// this run fetches /1 again, its entries are folded in on close
+	s.queue(fetchTask{url: "http://a/1", depth: 1})
+	require.NoError(t, s.Write(context.Background(), []ScrapperResult{{URL: "http://a/1", StatusCode: 304}}))
+	require.NoError(t, s.Write(context.Background(), []ScrapperResult{{URL: "http://a/"}}))
+	require.NoError(t, s.Close())
+	assert.Len(t, lines(), 5)
+	assert.Contains(t, lines()[3], `"status_code":304`, "the last result is kept")
+
+	s, err = ResumeSession(dir, 0)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, 2, s.Done())
+	assert.Empty(t, s.pending())
+	_, err = os.Stat(path + ".tmp")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSession_FailedSinkKeepsPagesPending(t *testing.T) {
+	dir := t.TempDir()
+	s, err := CreateSession(dir, 0)
+	require.NoError(t, err)
+	for _, u := range []string{"http://a/1", "http://a/2", "http://a/3"} {
+		s.queue(fetchTask{url: u})
+	}
+
+	// the database goes away after the first batch
+	writes := 0
+	db := SinkFunc(func(ctx context.Context, batch []ScrapperResult) error {
+		writes++
+		if writes > 1 {
+			return errors.New("database is locked")
+		}
+		return nil
+	})
+	stats := &memorySink{}
+	results := []ScrapperResult{{URL: "http://a/1"}, {URL: "http://a/2"}, {URL: "http://a/3"}}
+	err = WriteResults(context.Background(), feed(results), BatchConfig{Size: 1}, db, stats, s)
+	assert.ErrorContains(t, err, "database is locked")
+	assert.Equal(t, []int{1, 1, 1}, stats.sizes(), "other sinks carry on")
+
+	s, err = ResumeSession(dir, 0)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, 1, s.Done())
+	assert.Equal(t, []fetchTask{{url: "http://a/2"}, {url: "http://a/3"}}, s.pending())
+}
+
+// hitCounter serves site pages and counts the requests per path
+func hitCounter(t *testing.T, pages map[string][]string) (*httptest.Server, func() map[string]int) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+
+	handler := siteHandler(pages)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/robots.txt" {
+			mu.Lock()
+			hits[r.URL.Path]++
+			mu.Unlock()
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		return maps.Clone(hits)
+	}
+}
+
+func TestScrapper_CrawlResumesSession(t *testing.T) {
+	srv, hits := hitCounter(t, map[string][]string{
+		"/":  {"/a", "/b"},
+		"/a": {"/c"},
+		"/b": {},
+		"/c": {},
+	})
+	dir := t.TempDir()
+
+	// a first run fetched / and queued its links, then crashed
+	s, err := CreateSession(dir, 0)
+	require.NoError(t, err)
+	s.queue(fetchTask{url: srv.URL + "/"})
+	s.queue(fetchTask{url: srv.URL + "/a", depth: 1})
+	s.queue(fetchTask{url: srv.URL + "/b", depth: 1})
+	require.NoError(t, s.Write(context.Background(), []ScrapperResult{{URL: srv.URL + "/"}}))
+	require.NoError(t, s.Close())
+
+	s, err = ResumeSession(dir, 0)
+	require.NoError(t, err)
+
+	sc := NewScrapperWithConfig(ScrapperConfig{Workers: 2, Timeout: time.Second, IgnoreRobots: true})
+	require.NoError(t, sc.Crawl([]string{srv.URL}, CrawlConfig{MaxDepth: 2, Session: s}))
+	require.NoError(t, WriteResults(context.Background(), sc.Results(), BatchConfig{Size: 1}, s))
+
+	assert.Equal(t, map[string]int{"/a": 1, "/b": 1, "/c": 1}, hits(), "the seed isn't fetched again")
+
+	s, err = ResumeSession(dir, 0)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, 4, s.Done())
+	assert.Empty(t, s.pending())
+}
+
+func TestRun_Resume(t *testing.T) {
+	srv, hits := hitCounter(t, map[string][]string{"/": {"/a"}, "/a": {}})
+	dir := filepath.Join(t.TempDir(), "session")
+	args := []string{"-session", dir, "-depth", "1", "-progress=false", "-ignore-robots"}
+
+	var stderr bytes.Buffer
+	code := run(context.Background(), append(args, srv.URL), nil, &bytes.Buffer{}, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, map[string]int{"/": 1, "/a": 1}, hits())
+
+	stderr.Reset()
+	code = run(context.Background(), append(args, srv.URL), nil, &bytes.Buffer{}, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "session already exists")
+
+	stderr.Reset()
+	code = run(context.Background(), append(args, "-resume"), strings.NewReader("never read"), &bytes.Buffer{}, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Contains(t, stderr.String(), "Resuming session: 2 done, 0 left")
+	assert.Equal(t, map[string]int{"/": 1, "/a": 1}, hits(), "nothing fetched twice")
+}
+
+func TestRun_ResumeFollowsLinks(t *testing.T) {
+	srv, hits := hitCounter(t, map[string][]string{
+		"/":  {"/a"},
+		"/a": {"/b"},
+		"/b": {"/c"},
+		"/c": {},
+	})
+	dir := filepath.Join(t.TempDir(), "session")
+
+	// a first run of "-depth 2 <seed>" fetched the seed, then was killed
+	s, err := CreateSession(dir, 0)
+	require.NoError(t, err)
+	seed, _ := url.Parse(srv.URL)
+	s.crawl([]string{seed.Host}, CrawlConfig{MaxDepth: 2})
+	s.queue(fetchTask{url: srv.URL + "/"})
+	s.queue(fetchTask{url: srv.URL + "/a", depth: 1})
+	require.NoError(t, s.Write(context.Background(), []ScrapperResult{{URL: srv.URL + "/"}}))
+	require.NoError(t, s.Close())
+
+	// no seeds and no -depth, both come from the session
+	var stderr bytes.Buffer
+	args := []string{"-session", dir, "-resume", "-progress=false", "-ignore-robots"}
+	code := run(context.Background(), args, nil, &bytes.Buffer{}, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Contains(t, stderr.String(), "Resuming session: 1 done, 1 left")
+	assert.Equal(t, map[string]int{"/a": 1, "/b": 1}, hits(), "/c is past the first run's depth")
+
+	s, err = ResumeSession(dir, 0)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, 3, s.Done())
+}
+
+func TestRun_ResumeWithDepthZero(t *testing.T) {
+	srv, hits := hitCounter(t, map[string][]string{
+		"/":  {"/a"},
+		"/a": {"/b"},
+		"/b": {},
+	})
+	dir := filepath.Join(t.TempDir(), "session")
+
+	// a first run of "-depth 2 <seed>" fetched the seed, then was killed
+	s, err := CreateSession(dir, 0)
+	require.NoError(t, err)
+	seed, _ := url.Parse(srv.URL)
+	s.crawl([]string{seed.Host}, CrawlConfig{MaxDepth: 2})
+	s.queue(fetchTask{url: srv.URL + "/"})
+	s.queue(fetchTask{url: srv.URL + "/a", depth: 1})
+	require.NoError(t, s.Write(context.Background(), []ScrapperResult{{URL: srv.URL + "/"}}))
+	require.NoError(t, s.Close())
+
+	// an explicit -depth 0 overrides the saved depth
+	var stderr bytes.Buffer
+	args := []string{"-session", dir, "-resume", "-depth", "0", "-progress=false", "-ignore-robots"}
+	code := run(context.Background(), args, nil, &bytes.Buffer{}, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, map[string]int{"/a": 1}, hits(), "the queued page is fetched, its links aren't followed")
+}
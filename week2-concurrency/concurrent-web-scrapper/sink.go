@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Sink stores scraped results
+//
+// WriteResults calls Write with one batch at a time and waits for it, so a
+// slow sink holds up the results channel and through it the workers.
+type Sink interface {
+	Write(ctx context.Context, batch []ScrapperResult) error
+	Close() error
+}
+
+// SinkFunc turns a function into a Sink that has nothing to close
+type SinkFunc func(ctx context.Context, batch []ScrapperResult) error
+
+func (f SinkFunc) Write(ctx context.Context, batch []ScrapperResult) error {
+	return f(ctx, batch)
+}
+
+func (f SinkFunc) Close() error {
+	return nil
+}
+
+// BatchConfig controls how results are grouped before reaching the sinks
+type BatchConfig struct {
+	// results per batch, at least 1
+	Size int
+	// flush a partial batch once its first result is this old, 0 waits for
+	// a full batch
+	MaxWait time.Duration
+}
+
+// WriteResults reads results until the channel is closed and writes them
+// to every sink in batches, then closes the sinks
+//
+// Nothing is buffered beyond the batch being built, so while a sink is
+// busy the results channel fills up and the workers wait. A sink that fails
+// is dropped for the rest of the run, the others carry on and the failures
+// are returned at the end. Once a sink has failed, a Session after it gets
+// no more batches, so their pages stay pending for a resume.
+func WriteResults(ctx context.Context, results <-chan ScrapperResult, cfg BatchConfig, sinks ...Sink) error {
+	size := max(cfg.Size, 1)
+	failed := make([]error, len(sinks))
+
+	flush := func(batch []ScrapperResult) {
+		stored := true
+		for i, sink := range sinks {
+			if failed[i] != nil {
+				stored = false
+				continue
+			}
+			// a Session records the batch as done, only once the sinks
+			// before it have it
+			if _, ok := sink.(*Session); ok && !stored {
+				continue
+			}
+			if err := sink.Write(ctx, batch); err != nil {
+				failed[i] = fmt.Errorf("sink %d: %w", i, err)
+				stored = false
+			}
+		}
+	}
+
+	batch := make([]ScrapperResult, 0, size)
+	var timer *time.Timer
+	var deadline <-chan time.Time
+
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				if len(batch) > 0 {
+					flush(batch)
+				}
+				for i, sink := range sinks {
+					if err := sink.Close(); err != nil && failed[i] == nil {
+						failed[i] = fmt.Errorf("sink %d: %w", i, err)
+					}
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				return errors.Join(failed...)
+			}
+
+			batch = append(batch, result)
+			if len(batch) == 1 && cfg.MaxWait > 0 {
+				timer = time.NewTimer(cfg.MaxWait)
+				deadline = timer.C
+			}
+			if len(batch) < size {
+				continue
+			}
+		case <-deadline:
+		}
+
+		flush(batch)
+		// the sinks may hold on to the slice
+		batch = make([]ScrapperResult, 0, size)
+		if timer != nil {
+			timer.Stop()
+			timer, deadline = nil, nil
+		}
+	}
+}
+
+// ResultRow is the flat form of a ScrapperResult that sinks store
+type ResultRow struct {
+	URL        string   `json:"url"`
+	StatusCode int      `json:"status_code"`
+	BodyLength int      `json:"body_length"`
+	DurationMs float64  `json:"duration_ms"`
+	Depth      int      `json:"depth"`
+	Attempts   int      `json:"attempts"`
+	ErrorKind  string   `json:"error_kind,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	Records    []Record `json:"records,omitempty"`
+	// empty without a cache
+	Cache       string `json:"cache,omitempty"`
+	ContentHash string `json:"content_hash,omitempty"`
+	Change      string `json:"change,omitempty"`
+}
+
+func newResultRow(r ScrapperResult) ResultRow {
+	row := ResultRow{
+		URL:         r.URL,
+		StatusCode:  r.StatusCode,
+		BodyLength:  r.BodyLength,
+		DurationMs:  float64(r.Duration) / float64(time.Millisecond),
+		Depth:       r.Depth,
+		Attempts:    r.Attempts,
+		Records:     r.Records,
+		ContentHash: r.ContentHash,
+	}
+	if r.Cache != CacheOff {
+		row.Cache = r.Cache.String()
+	}
+	if r.Change != ChangeUnknown {
+		row.Change = r.Change.String()
+	}
+	if r.Error != nil {
+		row.ErrorKind = ErrorKindOf(r.Error).String()
+		row.Error = r.Error.Error()
+	}
+	return row
+}
+
+// recordsJSON is the records column of the tabular sinks
+func (row ResultRow) recordsJSON() (string, error) {
+	if len(row.Records) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(row.Records)
+	return string(b), err
+}
+
+// JSONLSink writes one JSON object per result and line
+type JSONLSink struct {
+	w      *bufio.Writer
+	closer io.Closer // nil when the writer isn't ours
+}
+
+func NewJSONLSink(w io.Writer) *JSONLSink {
+	return &JSONLSink{w: bufio.NewWriter(w)}
+}
+
+// OpenJSONLSink appends to the file at path, creating it if needed
+func OpenJSONLSink(path string) (*JSONLSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	sink := NewJSONLSink(f)
+	sink.closer = f
+	return sink, nil
+}
+
+func (s *JSONLSink) Write(ctx context.Context, batch []ScrapperResult) error {
+	enc := json.NewEncoder(s.w)
+	for _, r := range batch {
+		if err := enc.Encode(newResultRow(r)); err != nil {
+			return err
+		}
+	}
+	return s.w.Flush()
+}
+
+func (s *JSONLSink) Close() error {
+	err := s.w.Flush()
+	if s.closer != nil {
+		err = errors.Join(err, s.closer.Close())
+	}
+	return err
+}
+
+// ErrCSVHeaderMismatch is returned when appending to a CSV file whose columns
+// aren't the current ones, rows would end up under the wrong headings
+var ErrCSVHeaderMismatch = errors.New("csv file has other columns, move it aside or pick another file")
+
+var csvHeader = []string{"url", "status_code", "body_length", "duration_ms", "depth", "attempts", "error_kind", "error", "records", "cache", "content_hash", "change"}
+
+// CSVSink writes one row per result, the records as a JSON column
+type CSVSink struct {
+	w          *csv.Writer
+	closer     io.Closer
+	needHeader bool
+}
+
+func NewCSVSink(w io.Writer) *CSVSink {
+	return &CSVSink{w: csv.NewWriter(w), needHeader: true}
+}
+
+// OpenCSVSink appends to the file at path, the header is only written to
+// a new or empty file and must match the one already there otherwise
+func OpenCSVSink(path string) (*CSVSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() > 0 {
+		header, err := csv.NewReader(f).Read()
+		if err == nil && !slices.Equal(header, csvHeader) {
+			err = ErrCSVHeaderMismatch
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	sink := NewCSVSink(f)
+	sink.closer = f
+	sink.needHeader = info.Size() == 0
+	return sink, nil
+}
+
+func (s *CSVSink) Write(ctx context.Context, batch []ScrapperResult) error {
+	if s.needHeader {
+		if err := s.w.Write(csvHeader); err != nil {
+			return err
+		}
+		s.needHeader = false
+	}
+
+	for _, r := range batch {
+		row := newResultRow(r)
+		records, err := row.recordsJSON()
+		if err != nil {
+			return err
+		}
+		err = s.w.Write([]string{
+			row.URL,
+			strconv.Itoa(row.StatusCode),
+			strconv.Itoa(row.BodyLength),
+			strconv.FormatFloat(row.DurationMs, 'f', 3, 64),
+			strconv.Itoa(row.Depth),
+			strconv.Itoa(row.Attempts),
+			row.ErrorKind,
+			row.Error,
+			records,
+			row.Cache,
+			row.ContentHash,
+			row.Change,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *CSVSink) Close() error {
+	s.w.Flush()
+	err := s.w.Error()
+	if s.closer != nil {
+		err = errors.Join(err, s.closer.Close())
+	}
+	return err
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memorySink records the batches it was given
+type memorySink struct {
+	mu      sync.Mutex
+	batches [][]ScrapperResult
+	closed  bool
+}
+
+func (s *memorySink) Write(ctx context.Context, batch []ScrapperResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, batch)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func (s *memorySink) sizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var sizes []int
+	for _, b := range s.batches {
+		sizes = append(sizes, len(b))
+	}
+	return sizes
+}
+
+func sampleResults() []ScrapperResult {
+	return []ScrapperResult{
+		{
+			URL: "http://example.com/a", StatusCode: 200, BodyLength: 12, Duration: 1500 * time.Microsecond, Attempts: 1,
+			Records: []Record{{Rule: "page", Fields: map[string]any{"title": "A, \"quoted\""}}},
+			Cache:   CacheMiss, ContentHash: "abc123", Change: ChangeModified,
+		},
+		{
+			URL: "http://example.com/b", StatusCode: 503, Duration: 2 * time.Millisecond, Depth: 1, Attempts: 3,
+			Error: &FetchError{Kind: KindServerError, StatusCode: 503},
+		},
+	}
+}
+
+func feed(results []ScrapperResult) <-chan ScrapperResult {
+	ch := make(chan ScrapperResult)
+	go func() {
+		defer close(ch)
+		for _, r := range results {
+			ch <- r
+		}
+	}()
+	return ch
+}
+
+func TestWriteResults_Batches(t *testing.T) {
+	results := make([]ScrapperResult, 7)
+	sink := &memorySink{}
+
+	err := WriteResults(context.Background(), feed(results), BatchConfig{Size: 3}, sink)
+	require.NoError(t, err)
+	assert.Equal(t, []int{3, 3, 1}, sink.sizes())
+	assert.True(t, sink.closed)
+}
+
+func TestWriteResults_MaxWaitFlushesPartialBatch(t *testing.T) {
+	ch := make(chan ScrapperResult)
+	sink := &memorySink{}
+	done := make(chan error)
+	go func() {
+		done <- WriteResults(context.Background(), ch, BatchConfig{Size: 100, MaxWait: 20 * time.Millisecond}, sink)
+	}()
+
+	ch <- ScrapperResult{}
+	ch <- ScrapperResult{}
+	assert.Eventually(t, func() bool { return len(sink.sizes()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []int{2}, sink.sizes())
+
+	close(ch)
+	require.NoError(t, <-done)
+}
+
+func TestWriteResults_Backpressure(t *testing.T) {
+	release := make(chan struct{})
+	slow := SinkFunc(func(ctx context.Context, batch []ScrapperResult) error {
+		<-release
+		return nil
+	})
+
+	ch := make(chan ScrapperResult)
+	go WriteResults(context.Background(), ch, BatchConfig{Size: 1}, slow)
+
+	ch <- ScrapperResult{} // taken, the sink is now stuck on it
+	select {
+	case ch <- ScrapperResult{}:
+		t.Fatal("a busy sink shouldn't take more results")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	ch <- ScrapperResult{}
+	close(ch)
+}
+
+func TestWriteResults_FailingSinkIsDropped(t *testing.T) {
+	calls := 0
+	failing := SinkFunc(func(ctx context.Context, batch []ScrapperResult) error {
+		calls++
+		return errors.New("disk full")
+	})
+	healthy := &memorySink{}
+
+	err := WriteResults(context.Background(), feed(make([]ScrapperResult, 4)), BatchConfig{Size: 1}, failing, healthy)
+	assert.ErrorContains(t, err, "sink 0: disk full")
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, []int{1, 1, 1, 1}, healthy.sizes())
+}
+
+func TestJSONLSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+
+	// two runs append to the same file
+	for range 2 {
+		sink, err := OpenJSONLSink(path)
+		require.NoError(t, err)
+		require.NoError(t, WriteResults(context.Background(), feed(sampleResults()), BatchConfig{Size: 10}, sink))
+	}
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var rows []ResultRow
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var row ResultRow
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &row))
+		rows = append(rows, row)
+	}
+	require.Len(t, rows, 4)
+
+	assert.Equal(t, ResultRow{
+		URL: "http://example.com/a", StatusCode: 200, BodyLength: 12, DurationMs: 1.5, Attempts: 1,
+		Records: []Record{{Rule: "page", Fields: map[string]any{"title": "A, \"quoted\""}}},
+		Cache:   "miss", ContentHash: "abc123", Change: "modified",
+	}, rows[0])
+	assert.Equal(t, ResultRow{
+		URL: "http://example.com/b", StatusCode: 503, DurationMs: 2, Depth: 1, Attempts: 3,
+		ErrorKind: "server error", Error: "server error: 503 Service Unavailable",
+	}, rows[1])
+}
+
+func TestCSVSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.csv")
+
+	for range 2 {
+		sink, err := OpenCSVSink(path)
+		require.NoError(t, err)
+		require.NoError(t, WriteResults(context.Background(), feed(sampleResults()), BatchConfig{Size: 1}, sink))
+	}
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 5, "one header and two rows per run")
+	assert.Equal(t, csvHeader, rows[0])
+	assert.Equal(t, []string{"http://example.com/a", "200", "12", "1.500", "0", "1", "", "", `[{"rule":"page","fields":{"title":"A, \"quoted\""}}]`, "miss", "abc123", "modified"}, rows[1])
+	assert.Equal(t, []string{"http://example.com/b", "503", "0", "2.000", "1", "3", "server error", "server error: 503 Service Unavailable", "", "", "", ""}, rows[2])
+}
+
+func TestCSVSink_HeaderMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.csv")
+
+	// a file from before the cache columns
+	old := "url,status_code,body_length,duration_ms,depth,attempts,error_kind,error,records\nhttp://example.com/a,200,12,1.500,0,1,,,\n"
+	require.NoError(t, os.WriteFile(path, []byte(old), 0o644))
+
+	_, err := OpenCSVSink(path)
+	assert.ErrorIs(t, err, ErrCSVHeaderMismatch)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, old, string(data), "the file is left alone")
+}
+
+func TestSQLiteSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.db")
+
+	_, err := OpenSQLiteSink(path, "results; DROP TABLE x")
+	assert.Error(t, err)
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// a table from before the cache columns
+	_, err = db.Exec(`CREATE TABLE pages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, url TEXT NOT NULL, status_code INTEGER NOT NULL,
+		body_length INTEGER NOT NULL, duration_ms REAL NOT NULL, depth INTEGER NOT NULL,
+		attempts INTEGER NOT NULL, error_kind TEXT, error TEXT, records TEXT)`)
+	require.NoError(t, err)
+
+	sink, err := OpenSQLiteSink(path, "pages")
+	require.NoError(t, err)
+	require.NoError(t, WriteResults(context.Background(), feed(sampleResults()), BatchConfig{Size: 10}, sink))
+
+	rows, err := db.Query(`SELECT url, status_code, attempts, error_kind, records, cache, content_hash, change FROM pages ORDER BY id`)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var got []string
+	for rows.Next() {
+		var url string
+		var status, attempts int
+		var kind, records, cache, hash, change sql.NullString
+		require.NoError(t, rows.Scan(&url, &status, &attempts, &kind, &records, &cache, &hash, &change))
+		got = append(got, fmt.Sprintf("%s %d %d %q %q %s %s %s", url, status, attempts, kind.String, records.String, cache.String, hash.String, change.String))
+	}
+	require.NoError(t, rows.Err())
+
+	assert.Equal(t, []string{
+		`http://example.com/a 200 1 "" "[{\"rule\":\"page\",\"fields\":{\"title\":\"A, \\\"quoted\\\"\"}}]" miss abc123 modified`,
+		`http://example.com/b 503 3 "server error" ""   `,
+	}, got)
+}
+
+func TestWebhookSink(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	failures := 1
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+	}))
+	defer srv.Close()
+
+	sink := NewWebhookSink(srv.URL, http.Header{"Authorization": {"Bearer secret"}})
+	var sleeps []time.Duration
+	sink.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return nil
+	}
+
+	require.NoError(t, WriteResults(context.Background(), feed(sampleResults()), BatchConfig{Size: 10}, sink))
+	assert.Len(t, sleeps, 1, "the 503 was retried")
+	require.Len(t, bodies, 1)
+
+	lines := strings.Split(strings.TrimSpace(bodies[0]), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"url":"http://example.com/a"`)
+	assert.Contains(t, lines[1], `"error_kind":"server error"`)
+
+	t.Run("client errors fail the sink", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer srv.Close()
+
+		err := NewWebhookSink(srv.URL, nil).Write(context.Background(), sampleResults())
+		assert.Equal(t, KindClientError, ErrorKindOf(err))
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLiteSink inserts results into a table, one transaction per batch
+type SQLiteSink struct {
+	db     *sql.DB
+	insert string
+}
+
+// OpenSQLiteSink opens the database at path and creates table if it
+// doesn't exist yet
+func OpenSQLiteSink(path, table string) (*SQLiteSink, error) {
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q", table)
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// one writer at a time is all SQLite allows anyway
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		url         TEXT NOT NULL,
+		status_code INTEGER NOT NULL,
+		body_length INTEGER NOT NULL,
+		duration_ms REAL NOT NULL,
+		depth       INTEGER NOT NULL,
+		attempts    INTEGER NOT NULL,
+		error_kind  TEXT,
+		error       TEXT,
+		records     TEXT,
+		cache        TEXT,
+		content_hash TEXT,
+		change       TEXT
+	)`, table))
+	if err == nil {
+		err = addMissingColumns(db, table)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteSink{
+		db: db,
+		insert: fmt.Sprintf(`INSERT INTO %s
+			(url, status_code, body_length, duration_ms, depth, attempts, error_kind, error, records,
+			 cache, content_hash, change)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, table),
+	}, nil
+}
+
+func (s *SQLiteSink) Write(ctx context.Context, batch []ScrapperResult) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, s.insert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range batch {
+		row := newResultRow(r)
+		records, err := row.recordsJSON()
+		if err != nil {
+			return err
+		}
+		_, err = stmt.ExecContext(ctx,
+			row.URL, row.StatusCode, row.BodyLength, row.DurationMs, row.Depth, row.Attempts,
+			nullString(row.ErrorKind), nullString(row.Error), nullString(records),
+			nullString(row.Cache), nullString(row.ContentHash), nullString(row.Change))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// addedColumns came after the first version of the table, older databases
+// get them added
+var addedColumns = []string{"cache", "content_hash", "change"}
+
+func addMissingColumns(db *sql.DB, table string) error {
+	rows, err := db.Query(fmt.Sprintf(`SELECT name FROM pragma_table_info('%s')`, table))
+	if err != nil {
+		return err
+	}
+	have := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		have[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range addedColumns {
+		if have[column] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s TEXT`, table, column)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteSink) Close() error {
+	return s.db.Close()
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
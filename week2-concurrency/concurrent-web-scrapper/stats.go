@@ -0,0 +1,370 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Stats is a Sink aggregating the results of a scrape: latency percentiles,
+// status codes, bytes, error rates per host and throughput over time
+//
+// A page succeeded when it has no error and a status below 400, anything
+// else counts as failed.
+type Stats struct {
+	interval time.Duration
+	start    time.Time
+	now      func() time.Time
+
+	mu         sync.Mutex
+	end        time.Time // zero until closed
+	pages      int
+	failed     int
+	bytes      int64
+	latency    histogram
+	statuses   map[int]int
+	kinds      map[ErrorKind]int
+	hosts      map[string]*hostStats
+	throughput []ThroughputPoint
+}
+
+type hostStats struct {
+	pages, failed int
+}
+
+// NewStats starts the clock, throughput is counted per interval, 1s when
+// not positive
+func NewStats(interval time.Duration) *Stats {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &Stats{
+		interval: interval,
+		start:    time.Now(),
+		now:      time.Now,
+		statuses: make(map[int]int),
+		kinds:    make(map[ErrorKind]int),
+		hosts:    make(map[string]*hostStats),
+	}
+}
+
+func (s *Stats) Write(ctx context.Context, batch []ScrapperResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range batch {
+		failed := r.Error != nil || r.StatusCode >= 400
+
+		s.pages++
+		s.bytes += int64(r.BodyLength)
+		s.latency.add(r.Duration)
+		if r.StatusCode != 0 {
+			s.statuses[r.StatusCode]++
+		}
+		if r.Error != nil {
+			s.kinds[ErrorKindOf(r.Error)]++
+		}
+
+		host := s.host(r.URL)
+		host.pages++
+		if failed {
+			s.failed++
+			host.failed++
+		}
+
+		point := s.point(r.FetchedAt)
+		point.Pages++
+		point.Bytes += int64(r.BodyLength)
+	}
+	return nil
+}
+
+// point returns the throughput interval a page fetched at t counts towards,
+// batching would shift pages to when they were written otherwise
+// must be called with s.mu held
+func (s *Stats) point(t time.Time) *ThroughputPoint {
+	if t.IsZero() {
+		// not fetched by a Scrapper, it counts as fetched now
+		t = s.now()
+	}
+
+	slot := max(int(t.Sub(s.start)/s.interval), 0)
+	for len(s.throughput) <= slot {
+		offset := time.Duration(len(s.throughput)) * s.interval
+		s.throughput = append(s.throughput, ThroughputPoint{OffsetSec: offset.Seconds()})
+	}
+	return &s.throughput[slot]
+}
+
+func (s *Stats) host(rawURL string) *hostStats {
+	name := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		name = strings.ToLower(u.Host)
+	}
+
+	h, ok := s.hosts[name]
+	if !ok {
+		h = &hostStats{}
+		s.hosts[name] = h
+	}
+	return h
+}
+
+// Close stops the clock, the report's rates are over the scrape only
+func (s *Stats) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.end = s.now()
+	return nil
+}
+
+// StatsReport is a snapshot of Stats, durations are in milliseconds
+type StatsReport struct {
+	Pages       int     `json:"pages"`
+	Succeeded   int     `json:"succeeded"`
+	Failed      int     `json:"failed"`
+	Bytes       int64   `json:"bytes"`
+	ElapsedMs   float64 `json:"elapsed_ms"`
+	PagesPerSec float64 `json:"pages_per_sec"`
+	BytesPerSec float64 `json:"bytes_per_sec"`
+
+	Latency     LatencyReport `json:"latency_ms"`
+	StatusCodes map[int]int   `json:"status_codes"`
+	// failures by ErrorKind
+	Errors     map[string]int    `json:"errors"`
+	Hosts      []HostReport      `json:"hosts"`
+	Throughput []ThroughputPoint `json:"throughput"`
+}
+
+type LatencyReport struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+type HostReport struct {
+	Host      string  `json:"host"`
+	Pages     int     `json:"pages"`
+	Failed    int     `json:"failed"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+// ThroughputPoint is what arrived in one interval of the scrape
+type ThroughputPoint struct {
+	// start of the interval since the scrape began
+	OffsetSec float64 `json:"offset_sec"`
+	Pages     int     `json:"pages"`
+	Bytes     int64   `json:"bytes"`
+}
+
+// Report returns the statistics so far
+func (s *Stats) Report() StatsReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	end := s.end
+	if end.IsZero() {
+		end = s.now()
+	}
+	elapsed := end.Sub(s.start)
+	secs := max(elapsed.Seconds(), 0.001)
+
+	r := StatsReport{
+		Pages:       s.pages,
+		Succeeded:   s.pages - s.failed,
+		Failed:      s.failed,
+		Bytes:       s.bytes,
+		ElapsedMs:   ms(elapsed),
+		PagesPerSec: float64(s.pages) / secs,
+		BytesPerSec: float64(s.bytes) / secs,
+		Latency: LatencyReport{
+			Mean: ms(s.latency.mean()),
+			P50:  ms(s.latency.quantile(0.50)),
+			P90:  ms(s.latency.quantile(0.90)),
+			P99:  ms(s.latency.quantile(0.99)),
+			Max:  ms(s.latency.max),
+		},
+		StatusCodes: maps.Clone(s.statuses),
+		Errors:      make(map[string]int, len(s.kinds)),
+		Throughput:  slices.Clone(s.throughput),
+	}
+	for kind, n := range s.kinds {
+		r.Errors[kind.String()] = n
+	}
+
+	for name, h := range s.hosts {
+		r.Hosts = append(r.Hosts, HostReport{
+			Host:      name,
+			Pages:     h.pages,
+			Failed:    h.failed,
+			ErrorRate: float64(h.failed) / float64(h.pages),
+		})
+	}
+	// busiest hosts first
+	slices.SortFunc(r.Hosts, func(a, b HostReport) int {
+		return cmp.Or(cmp.Compare(b.Pages, a.Pages), strings.Compare(a.Host, b.Host))
+	})
+	return r
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WriteJSON writes the report as an indented JSON document
+func (r StatsReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// PrintTable writes the report for people to read
+func (r StatsReport) PrintTable(w io.Writer) {
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Statistics:")
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  Pages\t%d (%d succeeded, %d failed)\n", r.Pages, r.Succeeded, r.Failed)
+	fmt.Fprintf(tw, "  Transferred\t%s (%s/s)\n", formatBytes(float64(r.Bytes)), formatBytes(r.BytesPerSec))
+	fmt.Fprintf(tw, "  Elapsed\t%v (%.1f pages/s)\n", msDuration(r.ElapsedMs), r.PagesPerSec)
+	if r.Pages > 0 {
+		l := r.Latency
+		fmt.Fprintf(tw, "  Latency\tmean %v, p50 %v, p90 %v, p99 %v, max %v\n",
+			msDuration(l.Mean), msDuration(l.P50), msDuration(l.P90), msDuration(l.P99), msDuration(l.Max))
+	}
+	tw.Flush()
+
+	if len(r.StatusCodes) > 0 {
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  Status\tPages")
+		for _, code := range slices.Sorted(maps.Keys(r.StatusCodes)) {
+			fmt.Fprintf(tw, "  %d\t%d\n", code, r.StatusCodes[code])
+		}
+		tw.Flush()
+	}
+
+	if len(r.Errors) > 0 {
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  Error\tPages")
+		for _, kind := range slices.Sorted(maps.Keys(r.Errors)) {
+			fmt.Fprintf(tw, "  %s\t%d\n", kind, r.Errors[kind])
+		}
+		tw.Flush()
+	}
+
+	if len(r.Hosts) > 0 {
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  Host\tPages\tFailed\tError rate")
+		for _, h := range r.Hosts {
+			fmt.Fprintf(tw, "  %s\t%d\t%d\t%.1f%%\n", h.Host, h.Pages, h.Failed, h.ErrorRate*100)
+		}
+		tw.Flush()
+	}
+
+	if len(r.Throughput) > 1 {
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  Time\tPages\tBytes")
+		for _, p := range r.Throughput {
+			fmt.Fprintf(tw, "  %v\t%d\t%s\n", time.Duration(p.OffsetSec*float64(time.Second)), p.Pages, formatBytes(float64(p.Bytes)))
+		}
+		tw.Flush()
+	}
+}
+
+func msDuration(v float64) time.Duration {
+	return time.Duration(v * float64(time.Millisecond)).Round(time.Microsecond)
+}
+
+// histogramGrowth is the ratio between the bounds of a histogram bucket,
+// quantiles are within half of it of the real value
+const histogramGrowth = 1.02
+
+// histogram keeps latencies in log-spaced buckets, so quantiles need
+// memory for the range of values and not for every value
+type histogram struct {
+	buckets map[int]int
+	count   int
+	sum     time.Duration
+	min     time.Duration
+	max     time.Duration
+}
+
+func (h *histogram) add(d time.Duration) {
+	if h.buckets == nil {
+		h.buckets = make(map[int]int)
+	}
+	h.buckets[bucketOf(d)]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	h.max = max(h.max, d)
+	h.count++
+	h.sum += d
+}
+
+func (h *histogram) mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// quantile returns the value below which q of the values fall
+func (h *histogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := max(int(math.Ceil(q*float64(h.count))), 1)
+	// the ends are known exactly
+	switch rank {
+	case 1:
+		return h.min
+	case h.count:
+		return h.max
+	}
+	seen := 0
+	for _, b := range slices.Sorted(maps.Keys(h.buckets)) {
+		seen += h.buckets[b]
+		if seen >= rank {
+			// the bucket's middle can lie outside what was seen
+			return min(max(bucketValue(b), h.min), h.max)
+		}
+	}
+	return h.max
+}
+
+// bucketOf puts everything below a microsecond in bucket 0, bucket i above
+// it covers [µs·growth^(i-1), µs·growth^i)
+func bucketOf(d time.Duration) int {
+	if d < time.Microsecond {
+		return 0
+	}
+	return int(math.Log(float64(d)/float64(time.Microsecond))/math.Log(histogramGrowth)) + 1
+}
+
+// bucketValue is the geometric middle of a bucket
+func bucketValue(b int) time.Duration {
+	if b == 0 {
+		return 0
+	}
+	lower := float64(time.Microsecond) * math.Pow(histogramGrowth, float64(b-1))
+	return time.Duration(lower * math.Sqrt(histogramGrowth))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram_Quantiles(t *testing.T) {
+	var h histogram
+	var values []time.Duration
+	r := rand.New(rand.NewPCG(1, 2))
+	for range 10000 {
+		// log-normal around 50ms, like real latencies
+		d := time.Duration(float64(50*time.Millisecond) * (0.2 + r.ExpFloat64()))
+		h.add(d)
+		values = append(values, d)
+	}
+	slices.Sort(values)
+
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		exact := values[int(q*float64(len(values)))-1]
+		assert.InEpsilon(t, float64(exact), float64(h.quantile(q)), 0.02, "p%v", q*100)
+	}
+	assert.Equal(t, values[len(values)-1], h.max)
+	assert.Equal(t, values[len(values)-1], h.quantile(1))
+	assert.Equal(t, values[0], h.quantile(0))
+
+	var empty histogram
+	assert.Zero(t, empty.quantile(0.5))
+	assert.Zero(t, empty.mean())
+}
+
+func TestStats_Report(t *testing.T) {
+	clock := time.Unix(0, 0)
+	stats := NewStats(time.Second)
+	stats.start = clock
+	stats.now = func() time.Time { return clock }
+
+	ctx := context.Background()
+	stats.Write(ctx, []ScrapperResult{
+		{URL: "http://a.example/1", StatusCode: 200, BodyLength: 1000, Duration: 10 * time.Millisecond},
+		{URL: "http://a.example/2", StatusCode: 200, BodyLength: 3000, Duration: 30 * time.Millisecond},
+		// 4xx and 5xx fail even without an error
+		{URL: "http://A.example/3", StatusCode: 404, Duration: 20 * time.Millisecond},
+	})
+	clock = clock.Add(2500 * time.Millisecond)
+	stats.Write(ctx, []ScrapperResult{
+		{URL: "http://b.example/", StatusCode: 500, Duration: 40 * time.Millisecond, Error: &FetchError{Kind: KindServerError, StatusCode: 500}},
+		{URL: "http://c.example/", Error: &FetchError{Kind: KindTimeout, Err: errors.New("deadline")}},
+	})
+	clock = clock.Add(1500 * time.Millisecond)
+	require.NoError(t, stats.Close())
+	clock = clock.Add(time.Hour)
+
+	r := stats.Report()
+	assert.Equal(t, 5, r.Pages)
+	assert.Equal(t, 2, r.Succeeded)
+	assert.Equal(t, 3, r.Failed)
+	assert.Equal(t, int64(4000), r.Bytes)
+	assert.Equal(t, 4000.0, r.ElapsedMs, "the clock stops on Close")
+	assert.Equal(t, 1.25, r.PagesPerSec)
+	assert.Equal(t, map[int]int{200: 2, 404: 1, 500: 1}, r.StatusCodes)
+	assert.Equal(t, map[string]int{"server error": 1, "timeout": 1}, r.Errors)
+	assert.Equal(t, []HostReport{
+		{Host: "a.example", Pages: 3, Failed: 1, ErrorRate: 1.0 / 3},
+		{Host: "b.example", Pages: 1, Failed: 1, ErrorRate: 1},
+		{Host: "c.example", Pages: 1, Failed: 1, ErrorRate: 1},
+	}, r.Hosts)
+	assert.Equal(t, []ThroughputPoint{
+		{OffsetSec: 0, Pages: 3, Bytes: 4000},
+		{OffsetSec: 1},
+		{OffsetSec: 2, Pages: 2},
+	}, r.Throughput)
+	assert.Equal(t, 20.0, r.Latency.Mean)
+	assert.InEpsilon(t, 20.0, r.Latency.P50, 0.02)
+	assert.Equal(t, 40.0, r.Latency.Max)
+
+	var out bytes.Buffer
+	require.NoError(t, r.WriteJSON(&out))
+	var decoded StatsReport
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, r, decoded)
+
+	out.Reset()
+	r.PrintTable(&out)
+	for _, want := range []string{
+		"Pages        5 (2 succeeded, 3 failed)",
+		"404     1",
+		"timeout       1",
+		"a.example  3      1       33.3%",
+		"2s    2      0 B",
+	} {
+		assert.Contains(t, out.String(), want)
+	}
+}
+
+func TestStats_ThroughputByFetchTime(t *testing.T) {
+	start := time.Unix(0, 0)
+	stats := NewStats(time.Second)
+	stats.start = start
+	stats.now = func() time.Time { return start.Add(5 * time.Second) }
+
+	// one batch written long after its pages were fetched
+	stats.Write(context.Background(), []ScrapperResult{
+		{URL: "http://a.example/1", BodyLength: 10, FetchedAt: start.Add(500 * time.Millisecond)},
+		{URL: "http://a.example/2", BodyLength: 20, FetchedAt: start.Add(2200 * time.Millisecond)},
+		{URL: "http://a.example/3", BodyLength: 30, FetchedAt: start.Add(2900 * time.Millisecond)},
+		// fetched before the stats were created
+		{URL: "http://a.example/4", BodyLength: 40, FetchedAt: start.Add(-time.Second)},
+	})
+
+	assert.Equal(t, []ThroughputPoint{
+		{OffsetSec: 0, Pages: 2, Bytes: 50},
+		{OffsetSec: 1},
+		{OffsetSec: 2, Pages: 2, Bytes: 50},
+	}, stats.Report().Throughput)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// WebhookSink POSTs every batch to an HTTP endpoint as JSON Lines
+//
+// Transient failures are retried with the same policy as page fetches, so a
+// struggling endpoint slows the scrape down instead of losing results.
+type WebhookSink struct {
+	url     string
+	headers http.Header
+	client  *http.Client
+	retry   RetryPolicy
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+// NewWebhookSink posts to url with the given extra headers, e.g. for auth
+func NewWebhookSink(url string, headers http.Header) *WebhookSink {
+	return &WebhookSink{
+		url:     url,
+		headers: headers,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		retry:   DefaultRetryPolicy,
+		sleep:   sleep,
+	}
+}
+
+func (s *WebhookSink) Write(ctx context.Context, batch []ScrapperResult) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, r := range batch {
+		if err := enc.Encode(newResultRow(r)); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := s.post(ctx, body.Bytes())
+		if err == nil {
+			return nil
+		}
+
+		delay, retry := s.retry.retryDelay(attempt, err, rand.Float64)
+		if !retry {
+			return err
+		}
+		if err := s.sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *WebhookSink) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range s.headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return classify(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+
+	if fetchErr := statusError(resp, time.Now()); fetchErr != nil {
+		return fetchErr
+	}
+	return nil
+}
+
+func (s *WebhookSink) Close() error {
+	return nil
+}
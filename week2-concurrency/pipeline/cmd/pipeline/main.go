@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"week2-concurrency/pipeline"
+)
+
+// example1 squares numbers on 4 workers, keeps the even squares in input
+// order and prints them in batches of 3
+func example1() {
+	fmt.Println("=== Example 1: map, filter and batch ===")
+
+	p := pipeline.NewPipeline(context.Background())
+
+	nums := pipeline.From(p, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12)
+	squares := pipeline.Map(p, nums, func(ctx context.Context, n int) (int, error) {
+		time.Sleep(time.Duration(13-n) * 10 * time.Millisecond) // later items finish first
+		return n * n, nil
+	}, pipeline.Workers(4), pipeline.Ordered())
+	even := pipeline.Filter(p, squares, func(ctx context.Context, n int) (bool, error) {
+		return n%2 == 0, nil
+	})
+	batches := pipeline.Batch(p, even, 3, 0)
+
+	for batch := range batches {
+		fmt.Println("Batch:", batch)
+	}
+	if err := p.Wait(); err != nil {
+		fmt.Println("Pipeline failed:", err)
+	}
+}
+
+// example2 fans lines out to 3 parsers, merges their output and shows that
+// one bad line cancels every stage
+func example2() {
+	fmt.Println("=== Example 2: fan-out, merge and cancellation ===")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p := pipeline.NewPipeline(ctx)
+
+	lines := pipeline.Generate(p, func(ctx context.Context, emit func(string) bool) error {
+		for i := 1; i <= 100; i++ {
+			line := strconv.Itoa(i)
+			if i == 7 {
+				line = "seven"
+			}
+			if !emit(line) {
+				fmt.Printf("Source stopped at line %d\n", i)
+				return nil
+			}
+		}
+		return nil
+	})
+
+	var parsed []<-chan int
+	for i, lines := range pipeline.FanOut(p, lines, 3) {
+		parsed = append(parsed, pipeline.Map(p, lines, func(ctx context.Context, line string) (int, error) {
+			n, err := strconv.Atoi(line)
+			if err != nil {
+				return 0, err
+			}
+			fmt.Printf("Parser %d: %d\n", i+1, n)
+			return n, nil
+		}, pipeline.Named(fmt.Sprintf("parser-%d", i+1))))
+	}
+
+	sum := 0
+	err := pipeline.ForEach(p, pipeline.Merge(p, parsed), func(ctx context.Context, n int) error {
+		sum += n
+		return nil
+	})
+
+	var numErr *strconv.NumError
+	if errors.As(err, &numErr) {
+		fmt.Printf("Pipeline cancelled: %v (partial sum %d)\n", err, sum)
+	}
+}
+
+func main() {
+	fmt.Println("========= Pipelines =========")
+
+	example1()
+	example2()
+}
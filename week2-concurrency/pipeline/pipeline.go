@@ -0,0 +1,150 @@
+// Package pipeline builds concurrent pipelines out of typed channel stages
+// that share cancellation and report the first error
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Pipeline ties stages together: they share one context, the first stage to
+// fail cancels it for all the others, and Wait reports that first error
+//
+// Stages are plain typed channels, every stage closes its output once its
+// input is exhausted or the pipeline is cancelled, so consumers can simply
+// range over the last one.
+type Pipeline struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+
+	mu  sync.Mutex
+	err error // first stage error
+}
+
+// creates a Pipeline that is cancelled along with ctx
+func NewPipeline(ctx context.Context) *Pipeline {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	return &Pipeline{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Context is cancelled once a stage fails or the parent context is done
+func (p *Pipeline) Context() context.Context {
+	return p.ctx
+}
+
+// spawn runs a stage goroutine, an error from it cancels the pipeline
+func (p *Pipeline) spawn(fn func() error) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		if err := fn(); err != nil {
+			p.fail(err)
+		}
+	}()
+}
+
+func (p *Pipeline) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// errors caused by our own cancellation are just noise
+	if p.err == nil && !errors.Is(err, context.Canceled) {
+		p.err = err
+	}
+	p.cancel(err)
+}
+
+// Wait blocks until every stage has stopped and returns the first stage
+// error, or the parent context's error if it was cancelled
+func (p *Pipeline) Wait() error {
+	p.wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+	// still cancel to release the context, the cause only matters if the
+	// parent already cancelled it
+	err := p.ctx.Err()
+	p.cancel(nil)
+	return err
+}
+
+// StageOption configures a stage
+type StageOption func(*stageConfig)
+
+type stageConfig struct {
+	name    string
+	workers int
+	ordered bool
+	buffer  int
+}
+
+func newStageConfig(opts []StageOption) stageConfig {
+	cfg := stageConfig{workers: 1}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	cfg.workers = max(cfg.workers, 1)
+	return cfg
+}
+
+// wrap adds the stage name to an error so Wait tells where it came from
+func (c stageConfig) wrap(err error) error {
+	if err == nil || c.name == "" {
+		return err
+	}
+	return fmt.Errorf("stage %s: %w", c.name, err)
+}
+
+// Named labels the stage's errors
+func Named(name string) StageOption {
+	return func(c *stageConfig) { c.name = name }
+}
+
+// Workers runs the stage function on n goroutines, the output order then
+// depends on which worker finishes first unless Ordered is set too
+func Workers(n int) StageOption {
+	return func(c *stageConfig) { c.workers = n }
+}
+
+// Ordered keeps the output in input order even with several workers
+func Ordered() StageOption {
+	return func(c *stageConfig) { c.ordered = true }
+}
+
+// Buffer gives the stage's output channel room for n items
+func Buffer(n int) StageOption {
+	return func(c *stageConfig) { c.buffer = n }
+}
+
+// send delivers v unless the pipeline is cancelled first
+func send[T any](ctx context.Context, out chan<- T, v T) bool {
+	select {
+	case out <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// receive takes the next item unless the pipeline is cancelled first, ok is
+// false once in is closed too
+func receive[T any](ctx context.Context, in <-chan T) (v T, ok bool) {
+	select {
+	case v, ok = <-in:
+		return v, ok
+	case <-ctx.Done():
+		return v, false
+	}
+}
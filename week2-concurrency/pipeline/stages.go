@@ -0,0 +1,306 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// From is a source stage emitting items in order
+func From[T any](p *Pipeline, items ...T) <-chan T {
+	return Generate(p, func(ctx context.Context, emit func(T) bool) error {
+		for _, item := range items {
+			if !emit(item) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Generate is a source stage fed by fn, emit reports false once the pipeline
+// is cancelled and fn should return
+func Generate[T any](p *Pipeline, fn func(ctx context.Context, emit func(T) bool) error, opts ...StageOption) <-chan T {
+	cfg := newStageConfig(opts)
+	out := make(chan T, cfg.buffer)
+
+	p.spawn(func() error {
+		defer close(out)
+
+		return cfg.wrap(fn(p.ctx, func(v T) bool {
+			return send(p.ctx, out, v)
+		}))
+	})
+	return out
+}
+
+// Map applies fn to every item, an error cancels the whole pipeline
+func Map[In, Out any](p *Pipeline, in <-chan In, fn func(ctx context.Context, v In) (Out, error), opts ...StageOption) <-chan Out {
+	return process(p, in, func(ctx context.Context, v In) (Out, bool, error) {
+		out, err := fn(ctx, v)
+		return out, true, err
+	}, newStageConfig(opts))
+}
+
+// Filter keeps the items keep reports true for
+func Filter[T any](p *Pipeline, in <-chan T, keep func(ctx context.Context, v T) (bool, error), opts ...StageOption) <-chan T {
+	return process(p, in, func(ctx context.Context, v T) (T, bool, error) {
+		ok, err := keep(ctx, v)
+		return v, ok, err
+	}, newStageConfig(opts))
+}
+
+// sequenced is an item tagged with its input position for Ordered stages
+type sequenced[T any] struct {
+	seq  uint64
+	v    T
+	keep bool
+}
+
+// process runs fn over in on cfg.workers goroutines, items fn doesn't keep
+// are dropped
+func process[In, Out any](p *Pipeline, in <-chan In, fn func(ctx context.Context, v In) (Out, bool, error), cfg stageConfig) <-chan Out {
+	out := make(chan Out, cfg.buffer)
+
+	if !cfg.ordered || cfg.workers == 1 {
+		// a single worker keeps the order by itself
+		var wg sync.WaitGroup
+		for range cfg.workers {
+			wg.Add(1)
+			p.spawn(func() error {
+				defer wg.Done()
+
+				for {
+					v, ok := receive(p.ctx, in)
+					if !ok {
+						return nil
+					}
+					res, keep, err := fn(p.ctx, v)
+					if err != nil {
+						return cfg.wrap(err)
+					}
+					if keep && !send(p.ctx, out, res) {
+						return nil
+					}
+				}
+			})
+		}
+		p.spawn(func() error {
+			wg.Wait()
+			close(out)
+			return nil
+		})
+		return out
+	}
+
+	// window bounds how far the fastest worker can run ahead of the slowest,
+	// so the reorder buffer can't grow without limit
+	window := make(chan struct{}, 2*cfg.workers+cfg.buffer)
+	tagged := make(chan sequenced[In])
+	results := make(chan sequenced[Out])
+
+	// number the items as they come in
+	p.spawn(func() error {
+		defer close(tagged)
+
+		for seq := uint64(0); ; seq++ {
+			v, ok := receive(p.ctx, in)
+			if !ok {
+				return nil
+			}
+			if !send(p.ctx, window, struct{}{}) {
+				return nil
+			}
+			if !send(p.ctx, tagged, sequenced[In]{seq: seq, v: v}) {
+				return nil
+			}
+		}
+	})
+
+	var wg sync.WaitGroup
+	for range cfg.workers {
+		wg.Add(1)
+		p.spawn(func() error {
+			defer wg.Done()
+
+			for {
+				item, ok := receive(p.ctx, tagged)
+				if !ok {
+					return nil
+				}
+				res, keep, err := fn(p.ctx, item.v)
+				if err != nil {
+					return cfg.wrap(err)
+				}
+				if !send(p.ctx, results, sequenced[Out]{seq: item.seq, v: res, keep: keep}) {
+					return nil
+				}
+			}
+		})
+	}
+	p.spawn(func() error {
+		wg.Wait()
+		close(results)
+		return nil
+	})
+
+	// release results in input order
+	p.spawn(func() error {
+		defer close(out)
+
+		pending := make(map[uint64]sequenced[Out])
+		var next uint64
+		for {
+			r, ok := receive(p.ctx, results)
+			if !ok {
+				return nil
+			}
+			pending[r.seq] = r
+
+			for {
+				r, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				<-window
+
+				if r.keep && !send(p.ctx, out, r.v) {
+					return nil
+				}
+			}
+		}
+	})
+	return out
+}
+
+// Batch groups items into slices of up to size, a partial batch is emitted
+// once maxWait has passed since its first item, zero waits for a full batch.
+// Whatever is left when in closes is emitted as a last batch.
+func Batch[T any](p *Pipeline, in <-chan T, size int, maxWait time.Duration, opts ...StageOption) <-chan []T {
+	cfg := newStageConfig(opts)
+	out := make(chan []T, cfg.buffer)
+	size = max(size, 1)
+
+	p.spawn(func() error {
+		defer close(out)
+
+		var batch []T
+		var deadline <-chan time.Time // nil while the batch is empty
+		flush := func() bool {
+			b := batch
+			batch, deadline = nil, nil
+			return send(p.ctx, out, b)
+		}
+
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					if len(batch) > 0 {
+						flush()
+					}
+					return nil
+				}
+
+				batch = append(batch, v)
+				if len(batch) == 1 && maxWait > 0 {
+					deadline = time.After(maxWait)
+				}
+				if len(batch) == size && !flush() {
+					return nil
+				}
+			case <-deadline:
+				if !flush() {
+					return nil
+				}
+			case <-p.ctx.Done():
+				return nil
+			}
+		}
+	})
+	return out
+}
+
+// FanOut spreads the items of in over n channels, each item goes to
+// whichever consumer is ready first
+func FanOut[T any](p *Pipeline, in <-chan T, n int, opts ...StageOption) []<-chan T {
+	cfg := newStageConfig(opts)
+	outs := make([]<-chan T, max(n, 1))
+
+	for i := range outs {
+		out := make(chan T, cfg.buffer)
+		outs[i] = out
+
+		p.spawn(func() error {
+			defer close(out)
+
+			for {
+				v, ok := receive(p.ctx, in)
+				if !ok {
+					return nil
+				}
+				if !send(p.ctx, out, v) {
+					return nil
+				}
+			}
+		})
+	}
+	return outs
+}
+
+// Merge combines several channels into one, the order between them is
+// whatever arrives first
+func Merge[T any](p *Pipeline, ins []<-chan T, opts ...StageOption) <-chan T {
+	cfg := newStageConfig(opts)
+	out := make(chan T, cfg.buffer)
+
+	var wg sync.WaitGroup
+	for _, in := range ins {
+		wg.Add(1)
+		p.spawn(func() error {
+			defer wg.Done()
+
+			for {
+				v, ok := receive(p.ctx, in)
+				if !ok {
+					return nil
+				}
+				if !send(p.ctx, out, v) {
+					return nil
+				}
+			}
+		})
+	}
+	p.spawn(func() error {
+		wg.Wait()
+		close(out)
+		return nil
+	})
+	return out
+}
+
+// Collect reads every item of the last stage and waits for the pipeline
+func Collect[T any](p *Pipeline, in <-chan T) ([]T, error) {
+	var items []T
+	for v := range in {
+		items = append(items, v)
+	}
+	return items, p.Wait()
+}
+
+// ForEach calls fn for every item of the last stage and waits for the
+// pipeline, an error from fn cancels it
+func ForEach[T any](p *Pipeline, in <-chan T, fn func(ctx context.Context, v T) error) error {
+	for v := range in {
+		if err := fn(p.ctx, v); err != nil {
+			p.fail(err)
+			break
+		}
+	}
+	// let the stages see the cancellation and close their outputs
+	for range in {
+	}
+	return p.Wait()
+}
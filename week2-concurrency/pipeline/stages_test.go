@@ -0,0 +1,222 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errBoom = errors.New("boom")
+
+func double(ctx context.Context, n int) (int, error) {
+	return n * 2, nil
+}
+
+func isEven(ctx context.Context, n int) (bool, error) {
+	return n%2 == 0, nil
+}
+
+// count emits 1..n
+func count(p *Pipeline, n int) <-chan int {
+	return Generate(p, func(ctx context.Context, emit func(int) bool) error {
+		for i := 1; i <= n; i++ {
+			if !emit(i) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func TestMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []StageOption
+		ordered bool
+	}{
+		{name: "single worker", ordered: true},
+		{name: "workers", opts: []StageOption{Workers(4)}},
+		{name: "ordered workers", opts: []StageOption{Workers(4), Ordered()}, ordered: true},
+		{name: "ordered buffered", opts: []StageOption{Workers(8), Ordered(), Buffer(16)}, ordered: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPipeline(context.Background())
+			out := Map(p, count(p, 50), func(ctx context.Context, n int) (int, error) {
+				// early items are the slowest so unordered workers overtake them
+				time.Sleep(time.Duration(50-n) * 50 * time.Microsecond)
+				return n * 2, nil
+			}, tt.opts...)
+
+			got, err := Collect(p, out)
+			require.NoError(t, err)
+
+			var want []int
+			for i := 1; i <= 50; i++ {
+				want = append(want, i*2)
+			}
+			if tt.ordered {
+				assert.Equal(t, want, got)
+			} else {
+				assert.ElementsMatch(t, want, got)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	p := NewPipeline(context.Background())
+	got, err := Collect(p, Filter(p, From(p, 1, 2, 3, 4, 5, 6), isEven, Workers(3), Ordered()))
+
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 4, 6}, got)
+}
+
+func TestBatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		items int
+		size  int
+		want  [][]int
+	}{
+		{name: "partial last batch", items: 7, size: 3, want: [][]int{{1, 2, 3}, {4, 5, 6}, {7}}},
+		{name: "exact", items: 4, size: 2, want: [][]int{{1, 2}, {3, 4}}},
+		{name: "empty", items: 0, size: 2, want: nil},
+		{name: "size below one", items: 2, size: 0, want: [][]int{{1}, {2}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPipeline(context.Background())
+			got, err := Collect(p, Batch(p, count(p, tt.items), tt.size, 0))
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestBatch_MaxWait(t *testing.T) {
+	p := NewPipeline(context.Background())
+	release := make(chan struct{})
+
+	src := Generate(p, func(ctx context.Context, emit func(int) bool) error {
+		emit(1)
+		emit(2)
+		<-release // a slow source shouldn't hold the first items back
+		emit(3)
+		return nil
+	})
+	batches := Batch(p, src, 10, 10*time.Millisecond)
+
+	assert.Equal(t, []int{1, 2}, <-batches)
+	close(release)
+
+	got, err := Collect(p, batches)
+	require.NoError(t, err)
+	assert.Equal(t, [][]int{{3}}, got)
+}
+
+func TestFanOutMerge(t *testing.T) {
+	p := NewPipeline(context.Background())
+
+	outs := FanOut(p, count(p, 100), 4)
+	require.Len(t, outs, 4)
+
+	var doubled []<-chan int
+	for _, out := range outs {
+		doubled = append(doubled, Map(p, out, double))
+	}
+	got, err := Collect(p, Merge(p, doubled))
+	require.NoError(t, err)
+
+	var want []int
+	for i := 1; i <= 100; i++ {
+		want = append(want, i*2)
+	}
+	assert.ElementsMatch(t, want, got)
+}
+
+func TestPipeline_ErrorCancelsEveryStage(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []StageOption
+	}{
+		{name: "single worker"},
+		{name: "workers", opts: []StageOption{Workers(4)}},
+		{name: "ordered", opts: []StageOption{Workers(4), Ordered()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPipeline(context.Background())
+			sourceStopped := make(chan struct{})
+
+			// an endless source only stops because of the cancellation
+			src := Generate(p, func(ctx context.Context, emit func(int) bool) error {
+				defer close(sourceStopped)
+				for i := 1; ; i++ {
+					if !emit(i) {
+						return ctx.Err()
+					}
+				}
+			})
+			parsed := Map(p, src, func(ctx context.Context, n int) (int, error) {
+				if n == 5 {
+					return 0, errBoom
+				}
+				return n, nil
+			}, append(tt.opts, Named("parse"))...)
+
+			_, err := Collect(p, Map(p, parsed, double))
+			assert.ErrorIs(t, err, errBoom)
+			assert.EqualError(t, err, "stage parse: boom")
+			<-sourceStopped
+			assert.Error(t, p.Context().Err())
+		})
+	}
+}
+
+func TestPipeline_ParentCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := NewPipeline(ctx)
+
+	src := Generate(p, func(ctx context.Context, emit func(int) bool) error {
+		for i := 1; emit(i); i++ {
+		}
+		return nil
+	})
+	out := Map(p, src, double)
+
+	<-out
+	cancel()
+	_, err := Collect(p, out)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestForEach(t *testing.T) {
+	p := NewPipeline(context.Background())
+
+	var seen []int
+	err := ForEach(p, count(p, 10), func(ctx context.Context, n int) error {
+		seen = append(seen, n)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Len(t, seen, 10)
+
+	// an error from the sink stops the pipeline too
+	p = NewPipeline(context.Background())
+	err = ForEach(p, Map(p, count(p, 1000), double), func(ctx context.Context, n int) error {
+		if n == 6 {
+			return errBoom
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, errBoom)
+}
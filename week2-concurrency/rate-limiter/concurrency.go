@@ -0,0 +1,234 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrWeightExceedsLimit = errors.New("weight exceeds concurrency limit")
+
+// ConcurrencyLimiter is a weighted semaphore used as a bulkhead
+//
+// Rate limiters cap how often work starts, this caps how much work is in
+// flight at once, so a slow dependency can't pile up requests. Waiters are
+// served in FIFO order, a heavy waiter at the front blocks lighter ones behind
+// it so it can't be starved.
+type ConcurrencyLimiter struct {
+	mu       sync.Mutex
+	limit    int64
+	inFlight int64
+	waiters  list.List // of *concurrencyWaiter
+}
+
+type concurrencyWaiter struct {
+	weight int64
+	ready  chan struct{} // closed once the weight has been granted or err set
+	err    error         // why the waiter was turned away, nil when granted
+}
+
+func NewConcurrencyLimiter(limit int64) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{limit: limit}
+}
+
+// blocks until weight can be taken or ctx is done
+func (cl *ConcurrencyLimiter) Acquire(ctx context.Context, weight int64) error {
+	cl.mu.Lock()
+	if weight > cl.limit {
+		cl.mu.Unlock()
+		return ErrWeightExceedsLimit
+	}
+	if cl.waiters.Len() == 0 && cl.inFlight+weight <= cl.limit {
+		cl.inFlight += weight
+		cl.mu.Unlock()
+		return nil
+	}
+
+	w := &concurrencyWaiter{weight: weight, ready: make(chan struct{})}
+	el := cl.waiters.PushBack(w)
+	cl.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return w.err
+	case <-ctx.Done():
+		cl.mu.Lock()
+		select {
+		case <-w.ready:
+			if w.err != nil {
+				cl.mu.Unlock()
+				return w.err
+			}
+			// granted while we were being cancelled, give it back
+			cl.inFlight -= weight
+		default:
+			cl.waiters.Remove(el)
+		}
+		// either way the queue may be able to move now
+		cl.notifyWaiters()
+		cl.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// non-blocking, takes weight only if it fits right now and nobody is waiting
+func (cl *ConcurrencyLimiter) TryAcquire(weight int64) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.waiters.Len() == 0 && cl.inFlight+weight <= cl.limit {
+		cl.inFlight += weight
+		return true
+	}
+	return false
+}
+
+// gives back weight taken by Acquire or TryAcquire
+func (cl *ConcurrencyLimiter) Release(weight int64) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.inFlight -= weight
+	if cl.inFlight < 0 {
+		panic("concurrency limiter: released more than held")
+	}
+	cl.notifyWaiters()
+}
+
+// changes the limit, in flight work above a lowered limit is allowed to finish
+// and waiters heavier than the new limit fail with ErrWeightExceedsLimit
+func (cl *ConcurrencyLimiter) SetLimit(limit int64) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.limit = limit
+	for el := cl.waiters.Front(); el != nil; {
+		next := el.Next()
+		if w := el.Value.(*concurrencyWaiter); w.weight > limit {
+			// it would block everyone behind it forever
+			cl.waiters.Remove(el)
+			w.err = ErrWeightExceedsLimit
+			close(w.ready)
+		}
+		el = next
+	}
+	cl.notifyWaiters()
+}
+
+func (cl *ConcurrencyLimiter) Limit() int64 {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.limit
+}
+
+func (cl *ConcurrencyLimiter) InFlight() int64 {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.inFlight
+}
+
+// notifyWaiters grants weight to queued waiters in order while it fits
+// must be called with cl.mu held
+func (cl *ConcurrencyLimiter) notifyWaiters() {
+	for {
+		front := cl.waiters.Front()
+		if front == nil {
+			return
+		}
+
+		w := front.Value.(*concurrencyWaiter)
+		if cl.inFlight+w.weight > cl.limit {
+			return
+		}
+
+		cl.inFlight += w.weight
+		cl.waiters.Remove(front)
+		close(w.ready)
+	}
+}
+
+// DefaultAdaptiveBackoff is used when AdaptiveLimiterConfig.Backoff is not in (0, 1)
+const DefaultAdaptiveBackoff = 0.9
+
+type AdaptiveLimiterConfig struct {
+	// clamped to [Min, Max]
+	Initial int64
+	// raised to 1, a limit of 0 admits nothing and so never recovers
+	Min int64
+	// raised to Initial when below Min
+	Max int64
+	// a sample slower than this is treated as a sign of overload
+	LatencyThreshold time.Duration
+	// multiplicative decrease applied on overload, in (0, 1), defaults to
+	// DefaultAdaptiveBackoff
+	Backoff float64
+}
+
+// AdaptiveConcurrencyLimiter adjusts its limit with AIMD latency feedback
+//
+// Samples are evaluated in windows of one limit's worth of releases, roughly
+// one round trip. If any sample in the window was slow or dropped the limit is
+// multiplied by Backoff, otherwise it grows by one. The limit settles just
+// below the concurrency where the dependency's latency crosses
+// LatencyThreshold and keeps probing around it.
+type AdaptiveConcurrencyLimiter struct {
+	*ConcurrencyLimiter
+
+	mu         sync.Mutex
+	cfg        AdaptiveLimiterConfig
+	limit      float64
+	samples    int64
+	overloaded bool
+}
+
+func NewAdaptiveConcurrencyLimiter(cfg AdaptiveLimiterConfig) *AdaptiveConcurrencyLimiter {
+	if cfg.Backoff <= 0 || cfg.Backoff >= 1 {
+		cfg.Backoff = DefaultAdaptiveBackoff
+	}
+	cfg.Min = max(cfg.Min, 1)
+	if cfg.Max < cfg.Min {
+		cfg.Max = max(cfg.Initial, cfg.Min)
+	}
+	cfg.Initial = min(max(cfg.Initial, cfg.Min), cfg.Max)
+
+	return &AdaptiveConcurrencyLimiter{
+		ConcurrencyLimiter: NewConcurrencyLimiter(cfg.Initial),
+		cfg:                cfg,
+		limit:              float64(cfg.Initial),
+	}
+}
+
+// releases weight and feeds the observed latency back into the limit
+func (al *AdaptiveConcurrencyLimiter) ReleaseWithLatency(weight int64, latency time.Duration) {
+	al.ConcurrencyLimiter.Release(weight)
+	al.observe(latency > al.cfg.LatencyThreshold)
+}
+
+// releases weight for work that timed out or was rejected by the dependency
+func (al *AdaptiveConcurrencyLimiter) ReleaseDropped(weight int64) {
+	al.ConcurrencyLimiter.Release(weight)
+	al.observe(true)
+}
+
+func (al *AdaptiveConcurrencyLimiter) observe(overloaded bool) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	al.samples++
+	al.overloaded = al.overloaded || overloaded
+	if al.samples < int64(al.limit) {
+		return
+	}
+
+	if al.overloaded {
+		al.limit = max(float64(al.cfg.Min), al.limit*al.cfg.Backoff)
+	} else {
+		al.limit = min(float64(al.cfg.Max), al.limit+1)
+	}
+	al.samples = 0
+	al.overloaded = false
+
+	al.ConcurrencyLimiter.SetLimit(int64(al.limit))
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrencyLimiter_TryAcquire(t *testing.T) {
+	cl := NewConcurrencyLimiter(5)
+
+	assert.True(t, cl.TryAcquire(3))
+	assert.True(t, cl.TryAcquire(2))
+	assert.False(t, cl.TryAcquire(1))
+	assert.Equal(t, int64(5), cl.InFlight())
+
+	cl.Release(2)
+	assert.True(t, cl.TryAcquire(1))
+	assert.False(t, cl.TryAcquire(2))
+}
+
+func TestConcurrencyLimiter_AcquireWaitsInOrder(t *testing.T) {
+	cl := NewConcurrencyLimiter(4)
+	require.True(t, cl.TryAcquire(4))
+
+	var (
+		mu    sync.Mutex
+		order []int64
+		wg    sync.WaitGroup
+	)
+	for i, weight := range []int64{3, 1, 2} {
+		wg.Add(1)
+		go func(weight int64) {
+			defer wg.Done()
+			require.NoError(t, cl.Acquire(context.Background(), weight))
+			mu.Lock()
+			order = append(order, weight)
+			mu.Unlock()
+		}(weight)
+
+		// queue them in a known order
+		require.Eventually(t, func() bool {
+			cl.mu.Lock()
+			defer cl.mu.Unlock()
+			return cl.waiters.Len() == i+1
+		}, time.Second, time.Millisecond)
+	}
+
+	// the weight 1 waiter would fit but must not overtake the weight 3 one
+	cl.Release(1)
+	assert.False(t, cl.TryAcquire(1), "TryAcquire must not jump the queue")
+
+	// 3 and 1 fit now, 2 has to wait for them
+	cl.Release(3)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(order) == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int64(4), cl.InFlight())
+
+	cl.Release(4)
+	wg.Wait()
+	assert.ElementsMatch(t, []int64{3, 1}, order[:2])
+	assert.Equal(t, int64(2), order[2])
+	assert.Equal(t, int64(2), cl.InFlight())
+}
+
+func TestConcurrencyLimiter_AcquireCancelled(t *testing.T) {
+	cl := NewConcurrencyLimiter(2)
+	require.True(t, cl.TryAcquire(1))
+
+	// a cancelled heavy waiter at the front unblocks the light one behind it
+	ctx, cancel := context.WithCancel(context.Background())
+	heavy := make(chan error, 1)
+	go func() {
+		heavy <- cl.Acquire(ctx, 2)
+	}()
+	require.Eventually(t, func() bool {
+		cl.mu.Lock()
+		defer cl.mu.Unlock()
+		return cl.waiters.Len() == 1
+	}, time.Second, time.Millisecond)
+
+	light := make(chan error, 1)
+	go func() {
+		light <- cl.Acquire(context.Background(), 1)
+	}()
+
+	cancel()
+	assert.ErrorIs(t, <-heavy, context.Canceled)
+	assert.NoError(t, <-light)
+	assert.Equal(t, int64(2), cl.InFlight())
+}
+
+func TestConcurrencyLimiter_Errors(t *testing.T) {
+	cl := NewConcurrencyLimiter(2)
+
+	assert.ErrorIs(t, cl.Acquire(context.Background(), 3), ErrWeightExceedsLimit)
+
+	require.True(t, cl.TryAcquire(2))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, cl.Acquire(ctx, 1), context.DeadlineExceeded)
+
+	assert.Panics(t, func() { cl.Release(3) })
+}
+
+func TestConcurrencyLimiter_SetLimitWakesWaiters(t *testing.T) {
+	cl := NewConcurrencyLimiter(1)
+	require.True(t, cl.TryAcquire(1))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cl.Acquire(context.Background(), 1)
+	}()
+
+	cl.SetLimit(2)
+	require.NoError(t, <-done)
+
+	// lowering the limit lets in flight work finish but admits nothing new
+	cl.SetLimit(1)
+	assert.Equal(t, int64(2), cl.InFlight())
+	assert.False(t, cl.TryAcquire(1))
+}
+
+func TestConcurrencyLimiter_SetLimitFailsHeavyWaiters(t *testing.T) {
+	cl := NewConcurrencyLimiter(3)
+	require.True(t, cl.TryAcquire(3))
+
+	heavy := make(chan error, 1)
+	go func() {
+		heavy <- cl.Acquire(context.Background(), 3)
+	}()
+	require.Eventually(t, func() bool { return waiting(cl) == 1 }, time.Second, time.Millisecond)
+	light := make(chan error, 1)
+	go func() {
+		light <- cl.Acquire(context.Background(), 1)
+	}()
+	require.Eventually(t, func() bool { return waiting(cl) == 2 }, time.Second, time.Millisecond)
+
+	// the heavy waiter can never fit, it mustn't hold up the light one
+	cl.SetLimit(2)
+	assert.ErrorIs(t, <-heavy, ErrWeightExceedsLimit)
+
+	cl.Release(3)
+	require.NoError(t, <-light)
+	assert.Equal(t, int64(1), cl.InFlight())
+}
+
+func waiting(cl *ConcurrencyLimiter) int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.waiters.Len()
+}
+
+// simulatedDependency slows down once more than capacity requests are in flight
+type simulatedDependency struct {
+	capacity int64
+	base     time.Duration
+}
+
+func (d simulatedDependency) latency(inFlight int64) time.Duration {
+	if inFlight <= d.capacity {
+		return d.base
+	}
+	return d.base * time.Duration(inFlight) / time.Duration(d.capacity)
+}
+
+func TestAdaptiveConcurrencyLimiter_Converges(t *testing.T) {
+	// latency crosses the 15ms threshold above 30 requests in flight
+	dep := simulatedDependency{capacity: 20, base: 10 * time.Millisecond}
+
+	tests := []struct {
+		name    string
+		initial int64
+	}{
+		{name: "starts too low", initial: 5},
+		{name: "starts too high", initial: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			al := NewAdaptiveConcurrencyLimiter(AdaptiveLimiterConfig{
+				Initial:          tt.initial,
+				Min:              1,
+				Max:              200,
+				LatencyThreshold: 15 * time.Millisecond,
+				Backoff:          0.9,
+			})
+
+			var limits []int64
+			for round := 0; round < 200; round++ {
+				// demand always exceeds the limit, admit as much as we can
+				var inFlight int64
+				for inFlight < 500 && al.TryAcquire(1) {
+					inFlight++
+				}
+
+				latency := dep.latency(inFlight)
+				for range inFlight {
+					al.ReleaseWithLatency(1, latency)
+				}
+				limits = append(limits, al.Limit())
+			}
+
+			// after settling the limit saw-tooths just below 30
+			for _, limit := range limits[100:] {
+				assert.GreaterOrEqual(t, limit, int64(26))
+				assert.LessOrEqual(t, limit, int64(31))
+			}
+		})
+	}
+}
+
+func TestAdaptiveConcurrencyLimiter_DropsBackOff(t *testing.T) {
+	al := NewAdaptiveConcurrencyLimiter(AdaptiveLimiterConfig{
+		Initial:          10,
+		Min:              2,
+		Max:              10,
+		LatencyThreshold: time.Second,
+		Backoff:          0.5,
+	})
+
+	for round := 0; round < 5; round++ {
+		limit := al.Limit()
+		for range limit {
+			require.True(t, al.TryAcquire(1))
+		}
+		for range limit {
+			al.ReleaseDropped(1)
+		}
+	}
+
+	assert.Equal(t, int64(2), al.Limit())
+}
+
+func TestAdaptiveConcurrencyLimiter_Defaults(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  AdaptiveLimiterConfig
+		want AdaptiveLimiterConfig
+	}{
+		{
+			name: "zero value",
+			cfg:  AdaptiveLimiterConfig{},
+			want: AdaptiveLimiterConfig{Initial: 1, Min: 1, Max: 1, Backoff: DefaultAdaptiveBackoff},
+		},
+		{
+			name: "out of range",
+			cfg:  AdaptiveLimiterConfig{Initial: 50, Min: -3, Max: 20, Backoff: 1.5},
+			want: AdaptiveLimiterConfig{Initial: 20, Min: 1, Max: 20, Backoff: DefaultAdaptiveBackoff},
+		},
+		{
+			name: "no max",
+			cfg:  AdaptiveLimiterConfig{Initial: 10, Min: 2, Backoff: 0.5},
+			want: AdaptiveLimiterConfig{Initial: 10, Min: 2, Max: 10, Backoff: 0.5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			al := NewAdaptiveConcurrencyLimiter(tt.cfg)
+			assert.Equal(t, tt.want, al.cfg)
+			assert.Equal(t, tt.want.Initial, al.Limit())
+		})
+	}
+}
+
+func TestAdaptiveConcurrencyLimiter_RecoversFromZeroMin(t *testing.T) {
+	al := NewAdaptiveConcurrencyLimiter(AdaptiveLimiterConfig{
+		Initial:          4,
+		Max:              4,
+		LatencyThreshold: time.Second,
+	})
+
+	// repeated drops can't take the limit below one, so work is still admitted
+	for range 50 {
+		require.True(t, al.TryAcquire(1))
+		al.ReleaseDropped(1)
+	}
+	assert.Equal(t, int64(1), al.Limit())
+
+	for range 3 {
+		require.NoError(t, al.Acquire(context.Background(), 1))
+		al.ReleaseWithLatency(1, time.Millisecond)
+	}
+	assert.Greater(t, al.Limit(), int64(1))
+}
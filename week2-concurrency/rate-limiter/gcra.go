@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// GCRA implements the generic cell rate algorithm
+//
+// Instead of counting tokens it tracks the theoretical arrival time (TAT) of
+// the next event if events arrived exactly at rate. An event is allowed as
+// long as it is not more than burst emission intervals ahead of schedule.
+// It behaves like a token bucket but stores a single timestamp. Like a token
+// bucket that never refills, a rate of zero or less allows burst events and
+// then fails reservations with ErrNeverAllowed.
+type GCRA struct {
+	mu       sync.Mutex
+	clock    Clock
+	interval time.Duration // emission interval, 1/rate, InfDuration for no rate
+	burst    int
+	tat      time.Time
+	left     int // events left when interval is InfDuration
+}
+
+// creates a limiter allowing rate events per second with bursts of up to burst events
+func NewGCRA(rate float64, burst int) *GCRA {
+	return newGCRA(rate, burst, realClock{})
+}
+
+func newGCRA(rate float64, burst int, clock Clock) *GCRA {
+	interval := InfDuration
+	if rate > 0 {
+		// too small a rate saturates to InfDuration too
+		interval = durationFor(1 / rate)
+	}
+	burst = max(burst, 0)
+
+	return &GCRA{
+		clock:    clock,
+		interval: interval,
+		burst:    burst,
+		tat:      clock.Now(),
+		left:     burst,
+	}
+}
+
+// schedule returns the new TAT and the earliest time n events may happen
+// must be called with g.mu held
+func (g *GCRA) schedule(now time.Time, n int) (time.Time, time.Time) {
+	tat := g.tat
+	if now.After(tat) {
+		tat = now
+	}
+
+	newTAT := tat.Add(time.Duration(n) * g.interval)
+	allowAt := newTAT.Add(-time.Duration(g.burst) * g.interval)
+	return newTAT, allowAt
+}
+
+func (g *GCRA) Allow() bool {
+	return g.AllowN(1)
+}
+
+func (g *GCRA) AllowN(n int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.interval == InfDuration {
+		if n > g.left {
+			return false
+		}
+		g.left -= n
+		return true
+	}
+
+	now := g.clock.Now()
+	newTAT, allowAt := g.schedule(now, n)
+	if allowAt.After(now) {
+		return false
+	}
+
+	g.tat = newTAT
+	return true
+}
+
+func (g *GCRA) Wait(ctx context.Context) error {
+	return g.WaitN(ctx, 1)
+}
+
+func (g *GCRA) WaitN(ctx context.Context, n int) error {
+	return wait(ctx, func() *Reservation {
+		return g.ReserveN(n)
+	})
+}
+
+func (g *GCRA) Reserve() *Reservation {
+	return g.ReserveN(1)
+}
+
+func (g *GCRA) ReserveN(n int) *Reservation {
+	if n > g.burst {
+		return failedReservation(ErrExceedsBurst)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.clock.Now()
+	if g.interval == InfDuration {
+		return g.reserveLeft(now, n)
+	}
+
+	newTAT, allowAt := g.schedule(now, n)
+	if allowAt.Before(now) {
+		allowAt = now
+	}
+	g.tat = newTAT
+
+	return newReservation(g.clock, allowAt, func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		// only the latest booking can be rolled back without
+		// moving reservations made after it
+		if g.tat.Equal(newTAT) {
+			g.tat = g.tat.Add(-time.Duration(n) * g.interval)
+		}
+	})
+}
+
+// reserveLeft books n of the events a limiter without a rate has left
+// must be called with g.mu held
+func (g *GCRA) reserveLeft(now time.Time, n int) *Reservation {
+	if n > g.left {
+		return failedReservation(ErrNeverAllowed)
+	}
+	g.left -= n
+
+	return newReservation(g.clock, now, func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		g.left += n
+	})
+}
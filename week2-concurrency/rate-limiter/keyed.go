@@ -0,0 +1,234 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// KeyClass describes the limits applied to every key of a class
+type KeyClass struct {
+	Rate  float64 // tokens per second
+	Burst int
+}
+
+type KeyedLimiterConfig struct {
+	// limits used for keys whose class is unknown or empty
+	Default KeyClass
+	// named classes, e.g. "free" and "paid" API keys
+	Classes map[string]KeyClass
+	// maps a key to its class name, nil puts every key in the default class
+	Classify func(key string) string
+	// upper bound on tracked keys, once it is reached the least recently
+	// used key with a full bucket is evicted. while every bucket is still
+	// refilling new keys share one overflow bucket of the default class
+	// instead. zero means unbounded
+	MaxKeys int
+	// keys idle for longer than this are evicted once their bucket is full
+	// again. zero disables expiry
+	IdleTTL time.Duration
+}
+
+// KeyedLimiterMetrics is a point in time snapshot of a KeyedLimiter
+type KeyedLimiterMetrics struct {
+	TrackedKeys int
+	Allowed     uint64
+	Rejected    uint64
+	Evicted     uint64
+	// requests from keys that didn't fit and went to the overflow bucket
+	Overflowed uint64
+}
+
+// KeyedLimiter keeps one TokenBucket per key (client IP, API key, host...)
+//
+// Buckets are created lazily on first use and evicted when idle for IdleTTL or
+// when MaxKeys is reached. Eviction happens inline on access so, like
+// TokenBucket, no background goroutine is needed. An evicted key starts again
+// with a full bucket, so only full buckets are evicted: dropping one that is
+// still refilling would reset the key's limit. Buckets are used under the
+// limiter's lock, so one can't be evicted between lookup and use.
+type KeyedLimiter struct {
+	mu       sync.Mutex
+	clock    Clock
+	cfg      KeyedLimiterConfig
+	entries  map[string]*list.Element
+	lru      *list.List   // front is most recently used
+	overflow *TokenBucket // shared by keys that don't fit, nil until needed
+
+	allowed    uint64
+	rejected   uint64
+	evicted    uint64
+	overflowed uint64
+}
+
+type keyedEntry struct {
+	key      string
+	bucket   *TokenBucket
+	lastSeen time.Time
+}
+
+func NewKeyedLimiter(cfg KeyedLimiterConfig) *KeyedLimiter {
+	return newKeyedLimiter(cfg, realClock{})
+}
+
+func newKeyedLimiter(cfg KeyedLimiterConfig, clock Clock) *KeyedLimiter {
+	return &KeyedLimiter{
+		clock:   clock,
+		cfg:     cfg,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// classFor resolves the limits for a key
+func (kl *KeyedLimiter) classFor(key string) KeyClass {
+	if kl.cfg.Classify == nil {
+		return kl.cfg.Default
+	}
+	if class, ok := kl.cfg.Classes[kl.cfg.Classify(key)]; ok {
+		return class
+	}
+	return kl.cfg.Default
+}
+
+// bucket returns the bucket for key, creating it if needed, or the overflow
+// bucket when MaxKeys is reached and nothing can be evicted
+// must be called with kl.mu held
+func (kl *KeyedLimiter) bucket(key string) *TokenBucket {
+	now := kl.clock.Now()
+	kl.evictIdle(now)
+
+	if el, ok := kl.entries[key]; ok {
+		entry := el.Value.(*keyedEntry)
+		entry.lastSeen = now
+		kl.lru.MoveToFront(el)
+		return entry.bucket
+	}
+
+	if kl.cfg.MaxKeys > 0 && kl.lru.Len() >= kl.cfg.MaxKeys {
+		el := kl.leastRecentlyUsedFull()
+		if el == nil {
+			kl.overflowed++
+			if kl.overflow == nil {
+				kl.overflow = newTokenBucket(kl.cfg.Default.Rate, kl.cfg.Default.Burst, kl.clock)
+			}
+			return kl.overflow
+		}
+		kl.removeElement(el)
+	}
+
+	class := kl.classFor(key)
+	entry := &keyedEntry{
+		key:      key,
+		bucket:   newTokenBucket(class.Rate, class.Burst, kl.clock),
+		lastSeen: now,
+	}
+	kl.entries[key] = kl.lru.PushFront(entry)
+	return entry.bucket
+}
+
+// evictIdle drops keys not seen for IdleTTL whose bucket is full, oldest
+// first
+// must be called with kl.mu held
+func (kl *KeyedLimiter) evictIdle(now time.Time) {
+	if kl.cfg.IdleTTL <= 0 {
+		return
+	}
+
+	for el := kl.lru.Back(); el != nil; {
+		entry := el.Value.(*keyedEntry)
+		if now.Sub(entry.lastSeen) < kl.cfg.IdleTTL {
+			return
+		}
+
+		prev := el.Prev()
+		if entry.full() {
+			kl.removeElement(el)
+		}
+		el = prev
+	}
+}
+
+// leastRecentlyUsedFull returns the least recently used key whose bucket is
+// full, nil if every bucket is still refilling
+// must be called with kl.mu held
+func (kl *KeyedLimiter) leastRecentlyUsedFull() *list.Element {
+	for el := kl.lru.Back(); el != nil; el = el.Prev() {
+		if el.Value.(*keyedEntry).full() {
+			return el
+		}
+	}
+	return nil
+}
+
+// full reports whether a fresh bucket would be no different
+func (e *keyedEntry) full() bool {
+	return e.bucket.Tokens() >= e.bucket.burst
+}
+
+// must be called with kl.mu held
+func (kl *KeyedLimiter) removeElement(el *list.Element) {
+	entry := kl.lru.Remove(el).(*keyedEntry)
+	delete(kl.entries, entry.key)
+	kl.evicted++
+}
+
+// must be called with kl.mu held
+func (kl *KeyedLimiter) record(ok bool) {
+	if ok {
+		kl.allowed++
+	} else {
+		kl.rejected++
+	}
+}
+
+// non-blocking, reports whether key may make a single request now
+func (kl *KeyedLimiter) Allow(key string) bool {
+	return kl.AllowN(key, 1)
+}
+
+func (kl *KeyedLimiter) AllowN(key string, n int) bool {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	ok := kl.bucket(key).AllowN(n)
+	kl.record(ok)
+	return ok
+}
+
+// blocks until key may make a single request or ctx is done
+func (kl *KeyedLimiter) Wait(ctx context.Context, key string) error {
+	return kl.WaitN(ctx, key, 1)
+}
+
+// the tokens are reserved under the lock, a bucket in debt isn't evicted
+// while the caller waits for them
+func (kl *KeyedLimiter) WaitN(ctx context.Context, key string, n int) error {
+	err := wait(ctx, func() *Reservation {
+		kl.mu.Lock()
+		defer kl.mu.Unlock()
+
+		return kl.bucket(key).ReserveN(n)
+	})
+
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	kl.record(err == nil)
+	return err
+}
+
+func (kl *KeyedLimiter) Metrics() KeyedLimiterMetrics {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	kl.evictIdle(kl.clock.Now())
+
+	return KeyedLimiterMetrics{
+		TrackedKeys: kl.lru.Len(),
+		Allowed:     kl.allowed,
+		Rejected:    kl.rejected,
+		Evicted:     kl.evicted,
+		Overflowed:  kl.overflowed,
+	}
+}
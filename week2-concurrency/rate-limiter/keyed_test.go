@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyedLimiter_IndependentKeys(t *testing.T) {
+	clock := newFakeClock()
+	kl := newKeyedLimiter(KeyedLimiterConfig{
+		Default: KeyClass{Rate: 1, Burst: 2},
+	}, clock)
+
+	assert.True(t, kl.Allow("a"))
+	assert.True(t, kl.Allow("a"))
+	assert.False(t, kl.Allow("a"))
+
+	// b has its own bucket
+	assert.True(t, kl.Allow("b"))
+
+	m := kl.Metrics()
+	assert.Equal(t, 2, m.TrackedKeys)
+	assert.Equal(t, uint64(3), m.Allowed)
+	assert.Equal(t, uint64(1), m.Rejected)
+}
+
+func TestKeyedLimiter_Classes(t *testing.T) {
+	clock := newFakeClock()
+	kl := newKeyedLimiter(KeyedLimiterConfig{
+		Default: KeyClass{Rate: 1, Burst: 1},
+		Classes: map[string]KeyClass{
+			"paid": {Rate: 10, Burst: 5},
+		},
+		Classify: func(key string) string {
+			class, _, _ := strings.Cut(key, ":")
+			return class
+		},
+	}, clock)
+
+	tests := []struct {
+		key     string
+		allowed int
+	}{
+		{key: "paid:alice", allowed: 5},
+		{key: "free:bob", allowed: 1},
+		{key: "unknown", allowed: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			allowed := 0
+			for i := 0; i < 10; i++ {
+				if kl.Allow(tt.key) {
+					allowed++
+				}
+			}
+			assert.Equal(t, tt.allowed, allowed)
+		})
+	}
+}
+
+func TestKeyedLimiter_IdleTTLEviction(t *testing.T) {
+	clock := newFakeClock()
+	kl := newKeyedLimiter(KeyedLimiterConfig{
+		Default: KeyClass{Rate: 1, Burst: 1},
+		IdleTTL: time.Minute,
+	}, clock)
+
+	kl.Allow("a")
+	clock.Advance(30 * time.Second)
+	kl.Allow("b")
+
+	clock.Advance(30 * time.Second)
+	m := kl.Metrics()
+	assert.Equal(t, 1, m.TrackedKeys, "a has been idle for the whole TTL")
+	assert.Equal(t, uint64(1), m.Evicted)
+
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, 0, kl.Metrics().TrackedKeys)
+}
+
+func TestKeyedLimiter_IdleTTLKeepsRefillingBuckets(t *testing.T) {
+	clock := newFakeClock()
+	kl := newKeyedLimiter(KeyedLimiterConfig{
+		Default: KeyClass{Rate: 0.5, Burst: 1},
+		IdleTTL: time.Second,
+	}, clock)
+
+	require.True(t, kl.Allow("a"))
+	clock.Advance(time.Second)
+	assert.Equal(t, 1, kl.Metrics().TrackedKeys, "idle but its bucket is still refilling")
+	assert.False(t, kl.Allow("a"), "the limit isn't reset")
+
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, 0, kl.Metrics().TrackedKeys)
+}
+
+func TestKeyedLimiter_MaxKeysEvictsLeastRecentlyUsed(t *testing.T) {
+	clock := newFakeClock()
+	kl := newKeyedLimiter(KeyedLimiterConfig{
+		Default: KeyClass{Rate: 1, Burst: 1},
+		MaxKeys: 2,
+	}, clock)
+
+	require.True(t, kl.Allow("a"))
+	require.True(t, kl.Allow("b"))
+	// every bucket is empty, evicting one would reset its limit, so c and d
+	// share the overflow bucket
+	require.True(t, kl.Allow("c"))
+	require.False(t, kl.Allow("d"))
+
+	m := kl.Metrics()
+	assert.Equal(t, 2, m.TrackedKeys)
+	assert.Zero(t, m.Evicted)
+	assert.Equal(t, uint64(2), m.Overflowed)
+
+	clock.Advance(time.Second)
+	// touch a so b is the least recently used full bucket
+	require.True(t, kl.Allow("a"))
+	require.True(t, kl.Allow("e"))
+
+	m = kl.Metrics()
+	assert.Equal(t, 2, m.TrackedKeys)
+	assert.Equal(t, uint64(1), m.Evicted)
+
+	// a kept its empty bucket
+	assert.False(t, kl.Allow("a"))
+}
+
+func TestKeyedLimiter_MaxKeysIsABound(t *testing.T) {
+	// buckets that never refill are never full again
+	kl := newKeyedLimiter(KeyedLimiterConfig{
+		Default: KeyClass{Rate: 0, Burst: 1},
+		MaxKeys: 10,
+	}, newFakeClock())
+
+	for i := range 1000 {
+		kl.Allow(fmt.Sprint("key-", i))
+	}
+	m := kl.Metrics()
+	assert.Equal(t, 10, m.TrackedKeys)
+	assert.Equal(t, uint64(990), m.Overflowed)
+	assert.Equal(t, uint64(11), m.Allowed, "ten keys and the overflow bucket's one token")
+}
+
+func TestKeyedLimiter_Wait(t *testing.T) {
+	clock := newFakeClock()
+	kl := newKeyedLimiter(KeyedLimiterConfig{
+		Default: KeyClass{Rate: 10, Burst: 1},
+	}, clock)
+	require.True(t, kl.Allow("a"))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- kl.Wait(context.Background(), "a")
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(100 * time.Millisecond)
+	require.NoError(t, <-done)
+
+	assert.Equal(t, uint64(2), kl.Metrics().Allowed)
+}
+
+func TestKeyedLimiter_Concurrent(t *testing.T) {
+	clock := newFakeClock()
+	kl := newKeyedLimiter(KeyedLimiterConfig{
+		Default: KeyClass{Rate: 1, Burst: 10},
+		MaxKeys: 100,
+	}, clock)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			key := string(rune('a' + id%5))
+			for j := 0; j < 10; j++ {
+				kl.Allow(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	m := kl.Metrics()
+	assert.Equal(t, 5, m.TrackedKeys)
+	assert.Equal(t, uint64(50), m.Allowed)
+	assert.Equal(t, uint64(150), m.Rejected)
+}
+
+func BenchmarkKeyedLimiter_Allow(b *testing.B) {
+	kl := NewKeyedLimiter(KeyedLimiterConfig{
+		Default: KeyClass{Rate: 1e9, Burst: 1e9},
+		MaxKeys: 1000,
+		IdleTTL: time.Minute,
+	})
+	keys := make([]string, 2000)
+	for i := range keys {
+		keys[i] = strings.Repeat("k", i%50) + string(rune(i))
+	}
+
+	i := 0
+	for b.Loop() {
+		kl.Allow(keys[i%len(keys)])
+		i++
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LeakyBucket is a queueing limiter that lets events through at a constant
+// rate with no bursts
+//
+// Waiting events queue up in the bucket and leak out one interval apart.
+// Once capacity events are queued, new reservations are rejected with
+// ErrQueueFull instead of waiting. A rate of zero or less never lets anything
+// through, reservations fail with ErrNeverAllowed.
+type LeakyBucket struct {
+	mu       sync.Mutex
+	clock    Clock
+	interval time.Duration // time between two events leaking out, InfDuration for no rate
+	capacity int           // maximum number of queued events
+	next     time.Time     // earliest time the next event may leak
+}
+
+// creates a limiter letting rate events per second through, queueing up to capacity events
+func NewLeakyBucket(rate float64, capacity int) *LeakyBucket {
+	return newLeakyBucket(rate, capacity, realClock{})
+}
+
+func newLeakyBucket(rate float64, capacity int, clock Clock) *LeakyBucket {
+	interval := InfDuration
+	if rate > 0 {
+		interval = durationFor(1 / rate)
+	}
+
+	return &LeakyBucket{
+		clock:    clock,
+		interval: interval,
+		capacity: max(capacity, 0),
+		next:     clock.Now(),
+	}
+}
+
+// queued returns how many events are waiting to leak at now
+// must be called with lb.mu held
+func (lb *LeakyBucket) queued(now time.Time) int {
+	if !lb.next.After(now) {
+		return 0
+	}
+	ahead := lb.next.Sub(now)
+	return int((ahead + lb.interval - 1) / lb.interval)
+}
+
+// non-blocking, only allows an event when nothing is queued
+func (lb *LeakyBucket) Allow() bool {
+	return lb.AllowN(1)
+}
+
+func (lb *LeakyBucket) AllowN(n int) bool {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	now := lb.clock.Now()
+	if lb.interval == InfDuration || lb.next.After(now) {
+		return false
+	}
+
+	lb.next = now.Add(time.Duration(n) * lb.interval)
+	return true
+}
+
+func (lb *LeakyBucket) Wait(ctx context.Context) error {
+	return lb.WaitN(ctx, 1)
+}
+
+func (lb *LeakyBucket) WaitN(ctx context.Context, n int) error {
+	return wait(ctx, func() *Reservation {
+		return lb.ReserveN(n)
+	})
+}
+
+func (lb *LeakyBucket) Reserve() *Reservation {
+	return lb.ReserveN(1)
+}
+
+// queues n events behind the ones already waiting
+func (lb *LeakyBucket) ReserveN(n int) *Reservation {
+	if lb.interval == InfDuration {
+		return failedReservation(ErrNeverAllowed)
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	now := lb.clock.Now()
+	// an event that can leak straight away never sits in the queue
+	if lb.next.After(now) && lb.queued(now)+n > lb.capacity {
+		return failedReservation(ErrQueueFull)
+	}
+
+	at := lb.next
+	if at.Before(now) {
+		at = now
+	}
+	end := at.Add(time.Duration(n) * lb.interval)
+	lb.next = end
+
+	return newReservation(lb.clock, at, func() {
+		lb.mu.Lock()
+		defer lb.mu.Unlock()
+
+		// only the tail of the queue can leave without reshuffling the rest
+		if lb.next.Equal(end) {
+			lb.next = at
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// InfDuration is the delay of a reservation that can never be satisfied
+const InfDuration = time.Duration(math.MaxInt64)
+
+var (
+	ErrQueueFull    = errors.New("limiter queue is full")
+	ErrNeverAllowed = errors.New("limiter can never allow this request")
+)
+
+// Limiter is implemented by every rate limiting algorithm in this package
+type Limiter interface {
+	// non-blocking, reports whether a single event may happen now
+	Allow() bool
+	// non-blocking, reports whether n events may happen now
+	AllowN(n int) bool
+	// blocks until a single event may happen or ctx is done
+	Wait(ctx context.Context) error
+	// books a single event and reports how long the caller must wait before acting
+	Reserve() *Reservation
+}
+
+var (
+	_ Limiter = (*TokenBucket)(nil)
+	_ Limiter = (*SlidingWindowLog)(nil)
+	_ Limiter = (*SlidingWindowCounter)(nil)
+	_ Limiter = (*GCRA)(nil)
+	_ Limiter = (*LeakyBucket)(nil)
+	_ Limiter = (*RedisTokenBucket)(nil)
+	_ Limiter = (*RedisSlidingWindow)(nil)
+)
+
+// Reservation is a booking made with a limiter
+//
+// The capacity is taken from the limiter when the reservation is made, so the
+// caller must either wait for Delay before acting or call Cancel.
+type Reservation struct {
+	err       error
+	clock     Clock
+	timeToAct time.Time
+	cancel    func()
+}
+
+func newReservation(clock Clock, timeToAct time.Time, cancel func()) *Reservation {
+	return &Reservation{
+		clock:     clock,
+		timeToAct: timeToAct,
+		cancel:    cancel,
+	}
+}
+
+// failedReservation is returned when the limiter can never satisfy a request
+func failedReservation(err error) *Reservation {
+	return &Reservation{err: err}
+}
+
+// reports whether the limiter can satisfy the reservation
+func (r *Reservation) OK() bool {
+	return r.err == nil
+}
+
+// returns why the reservation failed, nil if it is OK
+func (r *Reservation) Err() error {
+	return r.err
+}
+
+// returns how long the caller must wait before acting, zero means act now
+func (r *Reservation) Delay() time.Duration {
+	if r.err != nil {
+		return InfDuration
+	}
+	return max(0, r.timeToAct.Sub(r.clock.Now()))
+}
+
+// gives the reserved capacity back to the limiter
+//
+// Cancel is a no-op once the time to act has passed or if it was
+// already called.
+func (r *Reservation) Cancel() {
+	if r.err != nil || r.cancel == nil {
+		return
+	}
+	if r.clock.Now().After(r.timeToAct) {
+		return
+	}
+	r.cancel()
+	r.cancel = nil
+}
+
+// wait makes a reservation and blocks until it can act, cancelling it if
+// ctx is done first or its deadline is too close
+func wait(ctx context.Context, reserve func() *Reservation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r := reserve()
+	if r.err != nil {
+		return r.err
+	}
+
+	delay := r.Delay()
+	if deadline, ok := ctx.Deadline(); ok && delay > deadline.Sub(r.clock.Now()) {
+		// no point waiting, the limiter won't allow it in time
+		r.Cancel()
+		return ErrWaitExceedsDeadline
+	}
+	if delay == 0 {
+		return nil
+	}
+
+	select {
+	case <-r.clock.After(delay):
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+}
+
+// durationFor converts a (possibly fractional) number of seconds to a duration,
+// rounding up so a limiter is never woken before it can act
+func durationFor(seconds float64) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	if seconds >= float64(InfDuration)/float64(time.Second) {
+		return InfDuration
+	}
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
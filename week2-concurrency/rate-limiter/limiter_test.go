@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// every limiter is configured for 10 events per second
+func newLimiters(clock Clock) map[string]Limiter {
+	return map[string]Limiter{
+		"token bucket":           newTokenBucket(10, 10, clock),
+		"gcra":                   newGCRA(10, 10, clock),
+		"sliding window log":     newSlidingWindowLog(10, time.Second, clock),
+		"sliding window counter": newSlidingWindowCounter(10, time.Second, clock),
+		"leaky bucket":           newLeakyBucket(10, 10, clock),
+	}
+}
+
+// trafficResult summarises how a limiter treated a stream of Allow calls
+type trafficResult struct {
+	admitted  int
+	maxPerSec int // most events admitted in any one second window
+}
+
+// simulate calls Allow at the given offsets and records what was admitted
+func simulate(clock *fakeClock, l Limiter, arrivals []time.Duration) trafficResult {
+	var (
+		admittedAt []time.Duration
+		elapsed    time.Duration
+	)
+	for _, at := range arrivals {
+		clock.Advance(at - elapsed)
+		elapsed = at
+		if l.Allow() {
+			admittedAt = append(admittedAt, at)
+		}
+	}
+
+	res := trafficResult{admitted: len(admittedAt)}
+	for i := range admittedAt {
+		count := 0
+		for j := i; j < len(admittedAt) && admittedAt[j]-admittedAt[i] < time.Second; j++ {
+			count++
+		}
+		res.maxPerSec = max(res.maxPerSec, count)
+	}
+	return res
+}
+
+// burst returns n arrivals at the same instant
+func burst(at time.Duration, n int) []time.Duration {
+	arrivals := make([]time.Duration, n)
+	for i := range arrivals {
+		arrivals[i] = at
+	}
+	return arrivals
+}
+
+func TestLimiters_BurstTraffic(t *testing.T) {
+	// 50 requests at once, then another 50 half a second later
+	arrivals := append(burst(0, 50), burst(500*time.Millisecond, 50)...)
+
+	tests := []struct {
+		limiter  string
+		admitted int
+	}{
+		// both refill 5 tokens in the 500ms between bursts
+		{limiter: "token bucket", admitted: 15},
+		{limiter: "gcra", admitted: 15},
+		// the first burst is still inside the window
+		{limiter: "sliding window log", admitted: 10},
+		// the previous window is still empty, so nothing is weighted in
+		{limiter: "sliding window counter", admitted: 10},
+		// no bursts at all, one event per interval
+		{limiter: "leaky bucket", admitted: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.limiter, func(t *testing.T) {
+			clock := newFakeClock()
+			l := newLimiters(clock)[tt.limiter]
+
+			res := simulate(clock, l, arrivals)
+			assert.Equal(t, tt.admitted, res.admitted)
+		})
+	}
+}
+
+func TestLimiters_WindowBoundaryBurst(t *testing.T) {
+	// a burst just before and just after the one second mark is the
+	// classic weakness of fixed window counters
+	arrivals := append(burst(990*time.Millisecond, 20), burst(1010*time.Millisecond, 20)...)
+
+	tests := []struct {
+		limiter   string
+		maxPerSec int
+	}{
+		{limiter: "token bucket", maxPerSec: 10},
+		{limiter: "gcra", maxPerSec: 10},
+		{limiter: "sliding window log", maxPerSec: 10},
+		// 10 from the previous window weighted by 99% leaves room for none
+		{limiter: "sliding window counter", maxPerSec: 10},
+		{limiter: "leaky bucket", maxPerSec: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.limiter, func(t *testing.T) {
+			clock := newFakeClock()
+			l := newLimiters(clock)[tt.limiter]
+
+			res := simulate(clock, l, arrivals)
+			assert.Equal(t, tt.maxPerSec, res.maxPerSec)
+		})
+	}
+}
+
+func TestLimiters_SustainedOverload(t *testing.T) {
+	// 100 requests per second for 5 seconds against a limit of 10/s
+	arrivals := make([]time.Duration, 0, 500)
+	for i := range 500 {
+		arrivals = append(arrivals, time.Duration(i)*10*time.Millisecond)
+	}
+
+	tests := []struct {
+		limiter   string
+		admitted  int
+		maxPerSec int
+	}{
+		// the initial burst comes on top of the steady rate
+		{limiter: "token bucket", admitted: 59, maxPerSec: 19},
+		{limiter: "gcra", admitted: 59, maxPerSec: 19},
+		{limiter: "sliding window log", admitted: 50, maxPerSec: 10},
+		// the previous window is assumed to be evenly spread while it was
+		// really front loaded, so the estimate is a little pessimistic
+		{limiter: "sliding window counter", admitted: 46, maxPerSec: 10},
+		{limiter: "leaky bucket", admitted: 50, maxPerSec: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.limiter, func(t *testing.T) {
+			clock := newFakeClock()
+			l := newLimiters(clock)[tt.limiter]
+
+			res := simulate(clock, l, arrivals)
+			assert.Equal(t, tt.admitted, res.admitted)
+			assert.Equal(t, tt.maxPerSec, res.maxPerSec)
+		})
+	}
+}
+
+func TestLimiters_ReserveSpacesWaiters(t *testing.T) {
+	for name := range newLimiters(newFakeClock()) {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			l := newLimiters(clock)[name]
+
+			// exhaust whatever burst the limiter allows
+			for l.Allow() {
+			}
+
+			first := l.Reserve()
+			second := l.Reserve()
+			require.True(t, first.OK())
+			require.True(t, second.OK())
+
+			assert.Greater(t, first.Delay(), time.Duration(0))
+			assert.GreaterOrEqual(t, second.Delay(), first.Delay(), "reservations are served in order")
+		})
+	}
+}
+
+func TestLimiters_CancelledReservationFreesCapacity(t *testing.T) {
+	for name := range newLimiters(newFakeClock()) {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			l := newLimiters(clock)[name]
+			for l.Allow() {
+			}
+
+			r := l.Reserve()
+			require.True(t, r.OK())
+			delay := r.Delay()
+			r.Cancel()
+
+			assert.Equal(t, delay, l.Reserve().Delay(), "the next reservation takes the cancelled slot")
+		})
+	}
+}
+
+func TestLimiters_Wait(t *testing.T) {
+	for name := range newLimiters(newFakeClock()) {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			l := newLimiters(clock)[name]
+			for l.Allow() {
+			}
+
+			done := make(chan error, 1)
+			go func() {
+				done <- l.Wait(context.Background())
+			}()
+
+			clock.BlockUntil(1)
+			clock.Advance(2 * time.Second)
+			assert.NoError(t, <-done)
+		})
+	}
+}
+
+func TestReservation_Failed(t *testing.T) {
+	clock := newFakeClock()
+
+	tests := []struct {
+		name    string
+		reserve func() *Reservation
+		wantErr error
+	}{
+		{
+			name:    "token bucket over burst",
+			reserve: func() *Reservation { return newTokenBucket(10, 5, clock).ReserveN(6) },
+			wantErr: ErrExceedsBurst,
+		},
+		{
+			name:    "gcra over burst",
+			reserve: func() *Reservation { return newGCRA(10, 5, clock).ReserveN(6) },
+			wantErr: ErrExceedsBurst,
+		},
+		{
+			name:    "sliding window over limit",
+			reserve: func() *Reservation { return newSlidingWindowLog(5, time.Second, clock).ReserveN(6) },
+			wantErr: ErrExceedsLimit,
+		},
+		{
+			name:    "sliding window counter over limit",
+			reserve: func() *Reservation { return newSlidingWindowCounter(5, time.Second, clock).ReserveN(6) },
+			wantErr: ErrExceedsLimit,
+		},
+		{
+			name: "leaky bucket queue full",
+			reserve: func() *Reservation {
+				lb := newLeakyBucket(10, 2, clock)
+				lb.ReserveN(3)
+				return lb.Reserve()
+			},
+			wantErr: ErrQueueFull,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.reserve()
+			assert.ErrorIs(t, r.Err(), tt.wantErr)
+			assert.False(t, r.OK())
+			assert.Equal(t, InfDuration, r.Delay())
+		})
+	}
+}
+
+func TestTokenBucket_NeverRefills(t *testing.T) {
+	tb := newTokenBucket(0, 1, newFakeClock())
+	require.True(t, tb.Allow())
+
+	r := tb.Reserve()
+	assert.ErrorIs(t, r.Err(), ErrNeverAllowed)
+	assert.ErrorIs(t, tb.Wait(context.Background()), ErrNeverAllowed)
+}
+
+func TestLimiters_InvalidRateOrBurst(t *testing.T) {
+	tests := []struct {
+		name    string
+		limiter func(clock Clock) Limiter
+		// events let through straight away before reservations fail
+		allowed int
+		wantErr error
+	}{
+		{"gcra zero rate", func(c Clock) Limiter { return newGCRA(0, 2, c) }, 2, ErrNeverAllowed},
+		{"gcra negative rate", func(c Clock) Limiter { return newGCRA(-5, 2, c) }, 2, ErrNeverAllowed},
+		{"gcra tiny rate", func(c Clock) Limiter { return newGCRA(1e-300, 1, c) }, 1, ErrNeverAllowed},
+		{"gcra zero burst", func(c Clock) Limiter { return newGCRA(10, 0, c) }, 0, ErrExceedsBurst},
+		{"gcra negative burst", func(c Clock) Limiter { return newGCRA(10, -1, c) }, 0, ErrExceedsBurst},
+		{"leaky bucket zero rate", func(c Clock) Limiter { return newLeakyBucket(0, 5, c) }, 0, ErrNeverAllowed},
+		{"leaky bucket negative rate", func(c Clock) Limiter { return newLeakyBucket(-1, 5, c) }, 0, ErrNeverAllowed},
+		{"token bucket negative rate", func(c Clock) Limiter { return newTokenBucket(-1, 2, c) }, 2, ErrNeverAllowed},
+		{"sliding window log zero window", func(c Clock) Limiter { return newSlidingWindowLog(5, 0, c) }, 0, ErrNeverAllowed},
+		{"sliding window counter zero window", func(c Clock) Limiter { return newSlidingWindowCounter(5, 0, c) }, 0, ErrNeverAllowed},
+		{"sliding window counter negative window", func(c Clock) Limiter { return newSlidingWindowCounter(5, -time.Second, c) }, 0, ErrNeverAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			l := tt.limiter(clock)
+
+			for range tt.allowed {
+				require.True(t, l.Allow())
+			}
+			assert.False(t, l.Allow())
+			assert.ErrorIs(t, l.Reserve().Err(), tt.wantErr)
+
+			// time doesn't help
+			clock.Advance(time.Hour)
+			assert.False(t, l.Allow())
+			assert.ErrorIs(t, l.Wait(context.Background()), tt.wantErr)
+		})
+	}
+
+	// a negative capacity is no queue, the first event still leaks straight away
+	lb := newLeakyBucket(10, -1, newFakeClock())
+	assert.True(t, lb.Reserve().OK())
+	assert.ErrorIs(t, lb.Reserve().Err(), ErrQueueFull)
+}
+
+func TestGCRA_ZeroRateCancelReturnsEvents(t *testing.T) {
+	g := newGCRA(0, 1, newFakeClock())
+	r := g.Reserve()
+	require.True(t, r.OK())
+	assert.False(t, g.Allow())
+
+	r.Cancel()
+	assert.True(t, g.Allow())
+}
+
+func BenchmarkLimiters_Allow(b *testing.B) {
+	limiters := map[string]Limiter{
+		"token bucket":           NewTokenBucket(1e9, 1e6),
+		"gcra":                   NewGCRA(1e9, 1e6),
+		"sliding window log":     NewSlidingWindowLog(1e6, time.Millisecond),
+		"sliding window counter": NewSlidingWindowCounter(1e6, time.Millisecond),
+		"leaky bucket":           NewLeakyBucket(1e9, 1e6),
+	}
+
+	for name, l := range limiters {
+		b.Run(name, func(b *testing.B) {
+			for b.Loop() {
+				l.Allow()
+			}
+		})
+	}
+}
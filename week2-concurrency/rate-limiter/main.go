@@ -3,97 +3,23 @@ package main
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
-)
-
-type RateLimiter struct {
-	rate   int
-	ticker *time.Ticker
-	tokens chan struct{}
-	ctx    context.Context
-	cancel context.CancelFunc
-	wg     sync.WaitGroup
-}
-
-func NewRateLimiter(requestPerSecond int) *RateLimiter {
-	ctx, cancel := context.WithCancel(context.Background())
-
-	rl := &RateLimiter{
-		rate:   requestPerSecond,
-		ticker: time.NewTicker(time.Second / time.Duration(requestPerSecond)),
-		tokens: make(chan struct{}, requestPerSecond),
-		ctx:    ctx,
-		cancel: cancel,
-	}
-
-	// pre-fill the bucket with tokens
-	for i := 0; i < requestPerSecond; i++ {
-		rl.tokens <- struct{}{}
-	}
-
-	rl.wg.Add(1)
-	go rl.refillTokens()
-
-	return rl
-}
-
-func (rl *RateLimiter) refillTokens() {
-	defer rl.wg.Done()
-
-	for {
-		select {
-		case <-rl.ticker.C:
-			select {
-			case rl.tokens <- struct{}{}:
-			default:
-				// bucket full, skip
-			}
-		case <-rl.ctx.Done():
-			return
-		}
-	}
-}
-
-// allow blocks until token is available
-func (rl *RateLimiter) Allow() bool {
-	select {
-	case <-rl.tokens:
-		return true
-	case <-rl.ctx.Done():
-		return false
-	}
-}
-
-func (rl *RateLimiter) TryAllow() bool {
-	select {
-	case <-rl.tokens:
-		return true
-	default:
-		return false
-	}
-}
 
-func (rl *RateLimiter) Stop() {
-	fmt.Println("======= Stopping Rate Limiter =======")
-	rl.ticker.Stop()
-	rl.cancel()
-	rl.wg.Wait()
-	close(rl.tokens)
-	fmt.Println("======= Rate Limiter Stopped =======")
-}
+	"github.com/redis/go-redis/v9"
+)
 
 // basic rate limiting
 func example1() {
 	fmt.Println("======= Example 1. Basic Rate Limiting =======")
 
-	limiter := NewRateLimiter(5) // 5 requests per second
-	defer limiter.Stop()
+	limiter := NewTokenBucket(5, 5) // 5 requests per second
 
 	start := time.Now()
 
 	for i := 1; i < 10; i++ {
-		limiter.Allow()
+		limiter.Wait(context.Background())
 		elapsed := time.Since(start)
 		fmt.Printf("Request %d at %v\n", i, elapsed.Round(time.Millisecond))
 	}
@@ -102,9 +28,10 @@ func example1() {
 // Example 2: Rate limiter with workers
 func example2() {
 	fmt.Println("\n====== Example 2. Rate Limiters With Workers ======")
-	limiter := NewRateLimiter(10) // 10 requests per second
+	limiter := NewTokenBucket(10, 10) // 10 requests per second
 
-	defer limiter.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
 	var wg sync.WaitGroup
 	request := 30
@@ -115,9 +42,10 @@ func example2() {
 			defer wg.Done()
 
 			// wait for token
-			// blocks execution here until next tick
-			if !limiter.Allow() {
-				// if false means context cancelled
+			// blocks execution here until enough tokens have been refilled
+			if err := limiter.Wait(ctx); err != nil {
+				// context cancelled or the deadline is too close
+				fmt.Printf("Request %d dropped: %v\n", id, err)
 				return
 			}
 
@@ -131,13 +59,12 @@ func example2() {
 // Example 3: Non-blocking rate limiter
 func example3() {
 	fmt.Println("====== Example 3. Non-Blocking Rate Limiter ======")
-	limiter := NewRateLimiter(3) // 3 Requests Per Second
-	defer limiter.Stop()
+	limiter := NewTokenBucket(3, 3) // 3 Requests Per Second
 
 	accepted, rejected := 0, 0
 
 	for i := 1; i <= 10; i++ {
-		if limiter.TryAllow() {
+		if limiter.Allow() {
 			accepted++
 			fmt.Printf("✅ Request %d accepted\n", i)
 		} else {
@@ -150,57 +77,15 @@ func example3() {
 	fmt.Printf("\nAccepted: %d, Rejected: %d\n", accepted, rejected)
 }
 
-// Advanced: Token Bucket with Burst
-type TockenBucket struct {
-	capacity   int
-	tokens     int
-	rate       time.Duration
-	lastRefill time.Time
-	mu         sync.Mutex
-}
-
-func NewTockenBucket(capacity int, refillRate time.Duration) *TockenBucket {
-	return &TockenBucket{
-		capacity:   capacity,
-		tokens:     capacity,
-		rate:       refillRate,
-		lastRefill: time.Now(),
-	}
-}
-
-func (tb *TockenBucket) Allow(n int) bool {
-	tb.mu.Lock()
-	defer tb.mu.Unlock()
-
-	// refill tokens based on time elapsed
-	now := time.Now()
-	elapsed := now.Sub(tb.lastRefill)
-	tokensToAdd := int(elapsed / tb.rate)
-
-	if tokensToAdd > 0 {
-		tb.tokens += tokensToAdd
-		if tb.tokens > tb.capacity {
-			tb.tokens = tb.capacity
-		}
-		tb.lastRefill = now
-	}
-
-	// check if we have enough tokens
-	if tb.tokens >= n {
-		tb.tokens -= n
-		return true
-	}
-	return false
-}
-
+// Example 4: Token Bucket with burst
 func example4() {
 	fmt.Println("====== Example 4. Token Bucket with burst ======")
 
-	bucket := NewTockenBucket(10, 100*time.Millisecond) // 10 tokens, Refill every 100ms
+	bucket := NewTokenBucket(10, 10) // 10 tokens, refills one token every 100ms
 
 	fmt.Println("Bursts of 10 request.")
 	for i := 1; i <= 10; i++ {
-		if bucket.Allow(1) {
+		if bucket.AllowN(1) {
 			fmt.Printf("✅ Request %d\n", i)
 		} else {
 			fmt.Printf("❌ Request %d (rate limited)\n", i)
@@ -210,7 +95,7 @@ func example4() {
 	// wait for refill
 	time.Sleep(500 * time.Millisecond)
 	for i := 11; i <= 15; i++ {
-		if bucket.Allow(1) {
+		if bucket.AllowN(1) {
 			fmt.Printf("✅ Request %d\n", i)
 		} else {
 			fmt.Printf("❌ Request %d (rate limited)\n", i)
@@ -218,6 +103,123 @@ func example4() {
 	}
 }
 
+// Example 5: Per client rate limiting
+func example5() {
+	fmt.Println("====== Example 5. Per Client Rate Limiting ======")
+
+	limiter := NewKeyedLimiter(KeyedLimiterConfig{
+		Default: KeyClass{Rate: 1, Burst: 2}, // anonymous clients
+		Classes: map[string]KeyClass{
+			"premium": {Rate: 10, Burst: 5},
+		},
+		Classify: func(client string) string {
+			if strings.HasPrefix(client, "premium-") {
+				return "premium"
+			}
+			return ""
+		},
+		MaxKeys: 1000,
+		IdleTTL: time.Minute,
+	})
+
+	clients := []string{"premium-alice", "bob", "carol"}
+	for i := 1; i <= 5; i++ {
+		for _, client := range clients {
+			if limiter.Allow(client) {
+				fmt.Printf("✅ Request %d from %s\n", i, client)
+			} else {
+				fmt.Printf("❌ Request %d from %s (rate limited)\n", i, client)
+			}
+		}
+	}
+
+	m := limiter.Metrics()
+	fmt.Printf("\nTracked clients: %d, Allowed: %d, Rejected: %d\n", m.TrackedKeys, m.Allowed, m.Rejected)
+}
+
+// Example 6: Comparing algorithms under a burst
+func example6() {
+	fmt.Println("====== Example 6. Rate Limiting Algorithms ======")
+
+	limiters := []struct {
+		name    string
+		limiter Limiter
+	}{
+		{"Token Bucket", NewTokenBucket(10, 10)},
+		{"GCRA", NewGCRA(10, 10)},
+		{"Sliding Window Log", NewSlidingWindowLog(10, time.Second)},
+		{"Sliding Window Counter", NewSlidingWindowCounter(10, time.Second)},
+		{"Leaky Bucket", NewLeakyBucket(10, 10)},
+	}
+
+	for _, l := range limiters {
+		accepted := 0
+		for i := 1; i <= 20; i++ {
+			if l.limiter.Allow() {
+				accepted++
+			}
+		}
+
+		// the next request has to wait this long
+		r := l.limiter.Reserve()
+		fmt.Printf("%-24s accepted %2d of 20, next request in %v\n", l.name, accepted, r.Delay().Round(time.Millisecond))
+		r.Cancel()
+	}
+}
+
+// Example 7: Rate limiting shared by every replica through Redis
+func example7() {
+	fmt.Println("====== Example 7. Distributed Rate Limiting ======")
+
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	defer client.Close()
+
+	// 10 requests per second across all replicas, if Redis goes away each
+	// of our 3 replicas falls back to a third of the budget
+	limiter := NewRedisTokenBucket(client, "ratelimit:example", 10, 10, NewTokenBucket(10.0/3, 3))
+
+	for i := 1; i <= 15; i++ {
+		if limiter.Allow() {
+			fmt.Printf("✅ Request %d\n", i)
+		} else {
+			fmt.Printf("❌ Request %d (rate limited)\n", i)
+		}
+	}
+
+	if n := limiter.Fallbacks(); n > 0 {
+		fmt.Printf("\nRedis unreachable, %d decisions made locally\n", n)
+	}
+}
+
+// Example 8: Bulkhead around a slow dependency
+func example8() {
+	fmt.Println("====== Example 8. Concurrency Limiting ======")
+
+	bulkhead := NewConcurrencyLimiter(3) // at most 3 calls in flight
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 700*time.Millisecond)
+			defer cancel()
+
+			if err := bulkhead.Acquire(ctx, 1); err != nil {
+				fmt.Printf("❌ Request %d shed: %v\n", id, err)
+				return
+			}
+			defer bulkhead.Release(1)
+
+			fmt.Printf("✅ Request %d calling dependency (%d in flight)\n", id, bulkhead.InFlight())
+			time.Sleep(300 * time.Millisecond) // slow dependency
+		}(i)
+	}
+
+	wg.Wait()
+}
+
 func main() {
 	fmt.Println("============ Rate Limiter ============")
 	// example1()
@@ -232,5 +234,17 @@ func main() {
 	example4()
 	time.Sleep(1 * time.Second)
 
+	// example5()
+	// time.Sleep(1 * time.Second)
+
+	// example6()
+	// time.Sleep(1 * time.Second)
+
+	// example7()
+	// time.Sleep(1 * time.Second)
+
+	// example8()
+	// time.Sleep(1 * time.Second)
+
 	fmt.Println("End of Program")
 }
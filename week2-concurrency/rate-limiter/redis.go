@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisTimeout bounds every round trip made by Allow and Reserve, which have
+// no context of their own
+const redisTimeout = 100 * time.Millisecond
+
+// The scripts read the clock with TIME on the Redis server so every replica
+// sees the same time, which needs Redis 5+ (effects replication).
+// Timestamps are microseconds since the epoch, Lua numbers are doubles so
+// fractional token counts are stored as strings.
+
+// KEYS[1] bucket hash
+// ARGV rate (tokens/s, 0 never refills), burst, n, reserve (0|1)
+// returns {allowed, wait in microseconds}
+var tokenBucketScript = redis.NewScript(`
+local key = KEYS[1]
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local reserve = ARGV[4] == "1"
+
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local state = redis.call("HMGET", key, "tokens", "last")
+local tokens = tonumber(state[1]) or burst
+local last = tonumber(state[2]) or now
+if now > last then
+	tokens = math.min(burst, tokens + (now - last) / 1000000 * rate)
+	last = now
+end
+
+local wait = 0
+if tokens < n then
+	if not reserve or rate <= 0 then
+		return {0, 0}
+	end
+	wait = math.ceil((n - tokens) / rate * 1000000)
+end
+tokens = tokens - n
+
+redis.call("HSET", key, "tokens", tostring(tokens), "last", tostring(last))
+if rate > 0 then
+	-- a full bucket holds no information, let it expire once refilled
+	redis.call("PEXPIRE", key, math.ceil((burst - tokens) / rate * 1000) + 1000)
+end
+return {1, wait}
+`)
+
+// KEYS[1] bucket hash
+// ARGV burst, n
+var tokenBucketCancelScript = redis.NewScript(`
+local key = KEYS[1]
+local burst = tonumber(ARGV[1])
+local n = tonumber(ARGV[2])
+
+local tokens = tonumber(redis.call("HGET", key, "tokens"))
+if tokens == nil then
+	return 0
+end
+redis.call("HSET", key, "tokens", tostring(math.min(burst, tokens + n)))
+return 1
+`)
+
+// KEYS[1] sorted set of event timestamps
+// ARGV limit, window (microseconds), n, reserve (0|1), member id
+// returns {allowed, wait in microseconds}
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local reserve = ARGV[4] == "1"
+local id = ARGV[5]
+
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now - window)
+
+local at = now
+local newest = redis.call("ZRANGE", key, -1, -1, "WITHSCORES")
+if #newest > 0 and tonumber(newest[2]) > at then
+	if not reserve then
+		-- someone is already waiting, don't jump the queue
+		return {0, 0}
+	end
+	at = tonumber(newest[2])
+end
+
+local excess = redis.call("ZCARD", key) + n - limit
+if excess > 0 then
+	if not reserve then
+		return {0, 0}
+	end
+	local oldest = redis.call("ZRANGE", key, excess - 1, excess - 1, "WITHSCORES")
+	at = math.max(at, tonumber(oldest[2]) + window)
+end
+
+for i = 1, n do
+	redis.call("ZADD", key, at, id .. ":" .. i)
+end
+redis.call("PEXPIRE", key, math.ceil((at - now + window) / 1000))
+return {1, at - now}
+`)
+
+// KEYS[1] sorted set of event timestamps
+// ARGV member id, n
+var slidingWindowCancelScript = redis.NewScript(`
+local key = KEYS[1]
+local id = ARGV[1]
+local n = tonumber(ARGV[2])
+
+for i = 1, n do
+	redis.call("ZREM", key, id .. ":" .. i)
+end
+return 1
+`)
+
+// redisLimiter holds what the Redis backed limiters have in common
+type redisLimiter struct {
+	client    redis.Scripter
+	key       string
+	clock     Clock
+	fallback  Limiter
+	fallbacks atomic.Uint64
+}
+
+// returns how many decisions were made by the fallback limiter
+func (rl *redisLimiter) Fallbacks() uint64 {
+	return rl.fallbacks.Load()
+}
+
+// run executes a limiter script and decodes its {allowed, wait} reply
+func (rl *redisLimiter) run(ctx context.Context, script *redis.Script, args ...any) (bool, time.Duration, error) {
+	reply, err := script.Run(ctx, rl.client, []string{rl.key}, args...).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(reply) != 2 {
+		return false, 0, errors.New("unexpected limiter script reply")
+	}
+	return reply[0] == 1, time.Duration(reply[1]) * time.Microsecond, nil
+}
+
+// allowFallback is used when Redis could not be reached
+//
+// Without a fallback the limiter fails closed.
+func (rl *redisLimiter) allowFallback(n int) bool {
+	rl.fallbacks.Add(1)
+	if rl.fallback == nil {
+		return false
+	}
+	return rl.fallback.AllowN(n)
+}
+
+func (rl *redisLimiter) reserveFallback(n int, err error) *Reservation {
+	rl.fallbacks.Add(1)
+	if rl.fallback == nil {
+		return failedReservation(err)
+	}
+	if fb, ok := rl.fallback.(interface{ ReserveN(int) *Reservation }); ok {
+		return fb.ReserveN(n)
+	}
+	if n != 1 {
+		return failedReservation(err)
+	}
+	return rl.fallback.Reserve()
+}
+
+// RedisTokenBucket is a TokenBucket whose state lives in Redis so every
+// replica of a service shares the same budget
+//
+// When Redis is unreachable decisions are delegated to the fallback limiter,
+// typically a local TokenBucket configured with rate divided by the number of
+// replicas. A nil fallback rejects everything until Redis is back. Like
+// TokenBucket a rate of zero or less never refills.
+type RedisTokenBucket struct {
+	redisLimiter
+	rate  float64
+	burst int
+}
+
+func NewRedisTokenBucket(client redis.Scripter, key string, rate float64, burst int, fallback Limiter) *RedisTokenBucket {
+	return newRedisTokenBucket(client, key, rate, burst, fallback, realClock{})
+}
+
+func newRedisTokenBucket(client redis.Scripter, key string, rate float64, burst int, fallback Limiter, clock Clock) *RedisTokenBucket {
+	return &RedisTokenBucket{
+		redisLimiter: redisLimiter{
+			client:   client,
+			key:      key,
+			clock:    clock,
+			fallback: fallback,
+		},
+		rate:  max(rate, 0),
+		burst: burst,
+	}
+}
+
+func (rb *RedisTokenBucket) Allow() bool {
+	return rb.AllowN(1)
+}
+
+func (rb *RedisTokenBucket) AllowN(n int) bool {
+	if n > rb.burst {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	ok, _, err := rb.run(ctx, tokenBucketScript, rb.rate, rb.burst, n, 0)
+	if err != nil {
+		return rb.allowFallback(n)
+	}
+	return ok
+}
+
+func (rb *RedisTokenBucket) Wait(ctx context.Context) error {
+	return rb.WaitN(ctx, 1)
+}
+
+func (rb *RedisTokenBucket) WaitN(ctx context.Context, n int) error {
+	return wait(ctx, func() *Reservation {
+		return rb.reserveN(ctx, n)
+	})
+}
+
+func (rb *RedisTokenBucket) Reserve() *Reservation {
+	return rb.ReserveN(1)
+}
+
+func (rb *RedisTokenBucket) ReserveN(n int) *Reservation {
+	return rb.reserveN(context.Background(), n)
+}
+
+// reserveN falls back to the local limiter only when Redis fails, not when
+// ctx is done
+func (rb *RedisTokenBucket) reserveN(ctx context.Context, n int) *Reservation {
+	if n > rb.burst {
+		return failedReservation(ErrExceedsBurst)
+	}
+
+	rctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	ok, wait, err := rb.run(rctx, tokenBucketScript, rb.rate, rb.burst, n, 1)
+	if err != nil {
+		if ctx.Err() != nil {
+			return failedReservation(ctx.Err())
+		}
+		return rb.reserveFallback(n, err)
+	}
+	if !ok {
+		// only refused when the bucket has no rate to refill it
+		return failedReservation(ErrNeverAllowed)
+	}
+
+	return newReservation(rb.clock, rb.clock.Now().Add(wait), func() {
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+
+		// best effort, the tokens refill on their own anyway
+		tokenBucketCancelScript.Run(ctx, rb.client, []string{rb.key}, rb.burst, n)
+	})
+}
+
+// RedisSlidingWindow is a SlidingWindowLog stored in a Redis sorted set so
+// every replica of a service shares the same window
+//
+// It falls back to a local limiter the same way RedisTokenBucket does, and
+// like SlidingWindowLog allows nothing with a window of zero or less.
+type RedisSlidingWindow struct {
+	redisLimiter
+	limit  int
+	window time.Duration
+}
+
+func NewRedisSlidingWindow(client redis.Scripter, key string, limit int, window time.Duration, fallback Limiter) *RedisSlidingWindow {
+	return newRedisSlidingWindow(client, key, limit, window, fallback, realClock{})
+}
+
+func newRedisSlidingWindow(client redis.Scripter, key string, limit int, window time.Duration, fallback Limiter, clock Clock) *RedisSlidingWindow {
+	return &RedisSlidingWindow{
+		redisLimiter: redisLimiter{
+			client:   client,
+			key:      key,
+			clock:    clock,
+			fallback: fallback,
+		},
+		limit:  limit,
+		window: window,
+	}
+}
+
+// eventID returns a unique sorted set member prefix for one call
+func eventID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (rs *RedisSlidingWindow) Allow() bool {
+	return rs.AllowN(1)
+}
+
+func (rs *RedisSlidingWindow) AllowN(n int) bool {
+	if rs.window <= 0 || n > rs.limit {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	ok, _, err := rs.run(ctx, slidingWindowScript, rs.limit, rs.window.Microseconds(), n, 0, eventID())
+	if err != nil {
+		return rs.allowFallback(n)
+	}
+	return ok
+}
+
+func (rs *RedisSlidingWindow) Wait(ctx context.Context) error {
+	return rs.WaitN(ctx, 1)
+}
+
+func (rs *RedisSlidingWindow) WaitN(ctx context.Context, n int) error {
+	return wait(ctx, func() *Reservation {
+		return rs.reserveN(ctx, n)
+	})
+}
+
+func (rs *RedisSlidingWindow) Reserve() *Reservation {
+	return rs.ReserveN(1)
+}
+
+func (rs *RedisSlidingWindow) ReserveN(n int) *Reservation {
+	return rs.reserveN(context.Background(), n)
+}
+
+func (rs *RedisSlidingWindow) reserveN(ctx context.Context, n int) *Reservation {
+	if rs.window <= 0 {
+		return failedReservation(ErrNeverAllowed)
+	}
+	if n > rs.limit {
+		return failedReservation(ErrExceedsLimit)
+	}
+
+	rctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	id := eventID()
+	_, wait, err := rs.run(rctx, slidingWindowScript, rs.limit, rs.window.Microseconds(), n, 1, id)
+	if err != nil {
+		if ctx.Err() != nil {
+			return failedReservation(ctx.Err())
+		}
+		return rs.reserveFallback(n, err)
+	}
+
+	return newReservation(rs.clock, rs.clock.Now().Add(wait), func() {
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+
+		slidingWindowCancelScript.Run(ctx, rs.client, []string{rs.key}, id, n)
+	})
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRedis starts an in-process Redis with a pinned clock
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	mr.SetTime(newFakeClock().Now())
+
+	client := redis.NewClient(&redis.Options{
+		Addr:       mr.Addr(),
+		MaxRetries: -1,
+	})
+	t.Cleanup(func() { client.Close() })
+
+	return mr, client
+}
+
+// advance moves both the Redis server and the local clock forward
+func advance(mr *miniredis.Miniredis, clock *fakeClock, d time.Duration) {
+	clock.Advance(d)
+	mr.SetTime(clock.Now())
+}
+
+func TestRedisTokenBucket_SharedAcrossReplicas(t *testing.T) {
+	mr, client := newTestRedis(t)
+	clock := newFakeClock()
+
+	// two replicas of the same service share one bucket
+	replicaA := newRedisTokenBucket(client, "limit:api", 10, 4, nil, clock)
+	replicaB := newRedisTokenBucket(client, "limit:api", 10, 4, nil, clock)
+
+	assert.True(t, replicaA.Allow())
+	assert.True(t, replicaB.Allow())
+	assert.True(t, replicaA.AllowN(2))
+	assert.False(t, replicaB.Allow())
+	assert.False(t, replicaA.Allow())
+
+	// 150ms refills 1.5 tokens, the half token is kept
+	advance(mr, clock, 150*time.Millisecond)
+	assert.True(t, replicaB.Allow())
+	assert.False(t, replicaA.Allow())
+	advance(mr, clock, 50*time.Millisecond)
+	assert.True(t, replicaA.Allow())
+
+	assert.Zero(t, replicaA.Fallbacks())
+}
+
+func TestRedisTokenBucket_Reserve(t *testing.T) {
+	mr, client := newTestRedis(t)
+	clock := newFakeClock()
+	rb := newRedisTokenBucket(client, "limit:reserve", 10, 1, nil, clock)
+
+	require.True(t, rb.Allow())
+
+	first := rb.Reserve()
+	require.True(t, first.OK())
+	assert.Equal(t, 100*time.Millisecond, first.Delay())
+
+	second := rb.Reserve()
+	assert.Equal(t, 200*time.Millisecond, second.Delay())
+
+	// cancelling hands the token back for the next caller
+	second.Cancel()
+	assert.Equal(t, 200*time.Millisecond, rb.Reserve().Delay())
+
+	// the refill is spent on the two outstanding reservations
+	advance(mr, clock, 200*time.Millisecond)
+	assert.False(t, rb.Allow())
+	advance(mr, clock, 100*time.Millisecond)
+	assert.True(t, rb.Allow())
+
+	assert.ErrorIs(t, rb.ReserveN(2).Err(), ErrExceedsBurst)
+}
+
+func TestRedisTokenBucket_Wait(t *testing.T) {
+	mr, client := newTestRedis(t)
+	clock := newFakeClock()
+	rb := newRedisTokenBucket(client, "limit:wait", 10, 1, nil, clock)
+	require.True(t, rb.Allow())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- rb.Wait(context.Background())
+	}()
+
+	clock.BlockUntil(1)
+	advance(mr, clock, 100*time.Millisecond)
+	require.NoError(t, <-done)
+}
+
+func TestRedisSlidingWindow(t *testing.T) {
+	mr, client := newTestRedis(t)
+	clock := newFakeClock()
+
+	replicaA := newRedisSlidingWindow(client, "limit:window", 3, time.Second, nil, clock)
+	replicaB := newRedisSlidingWindow(client, "limit:window", 3, time.Second, nil, clock)
+
+	assert.True(t, replicaA.Allow())
+	advance(mr, clock, 500*time.Millisecond)
+	assert.True(t, replicaB.AllowN(2))
+	assert.False(t, replicaA.Allow())
+
+	// the first event leaves the window
+	advance(mr, clock, 500*time.Millisecond)
+	assert.True(t, replicaA.Allow())
+	assert.False(t, replicaB.Allow())
+
+	r := replicaB.Reserve()
+	require.True(t, r.OK())
+	assert.Equal(t, 500*time.Millisecond, r.Delay())
+
+	// queued reservations block Allow until they are cancelled
+	advance(mr, clock, 500*time.Millisecond)
+	r.Cancel()
+	assert.True(t, replicaB.Allow())
+
+	assert.ErrorIs(t, replicaA.ReserveN(4).Err(), ErrExceedsLimit)
+}
+
+func TestRedisLimiters_Fallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		limiter func(client *redis.Client, fallback Limiter) Limiter
+	}{
+		{
+			name: "token bucket",
+			limiter: func(client *redis.Client, fallback Limiter) Limiter {
+				return NewRedisTokenBucket(client, "limit:fallback", 10, 10, fallback)
+			},
+		},
+		{
+			name: "sliding window",
+			limiter: func(client *redis.Client, fallback Limiter) Limiter {
+				return NewRedisSlidingWindow(client, "limit:fallback", 10, time.Second, fallback)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr, client := newTestRedis(t)
+			mr.Close()
+
+			t.Run("local fallback", func(t *testing.T) {
+				fallback := newTokenBucket(1, 2, newFakeClock())
+				l := tt.limiter(client, fallback)
+
+				assert.True(t, l.Allow())
+				assert.True(t, l.Allow())
+				assert.False(t, l.Allow())
+				assert.Equal(t, 0.0, fallback.Tokens())
+
+				r := l.Reserve()
+				assert.True(t, r.OK())
+				assert.Equal(t, time.Second, r.Delay())
+				r.Cancel()
+
+				assert.Equal(t, uint64(4), l.(interface{ Fallbacks() uint64 }).Fallbacks())
+			})
+
+			t.Run("fails closed without fallback", func(t *testing.T) {
+				l := tt.limiter(client, nil)
+
+				assert.False(t, l.Allow())
+				assert.False(t, l.Reserve().OK())
+				assert.Error(t, l.Wait(context.Background()))
+			})
+		})
+	}
+}
+
+func TestRedisLimiters_InvalidConfig(t *testing.T) {
+	mr, client := newTestRedis(t)
+	clock := newFakeClock()
+
+	// a bucket without a rate hands out its burst and then never refills
+	tb := newRedisTokenBucket(client, "limit:norate", 0, 2, nil, clock)
+	assert.True(t, tb.AllowN(2))
+	assert.False(t, tb.Allow())
+	assert.ErrorIs(t, tb.Reserve().Err(), ErrNeverAllowed)
+	assert.False(t, tb.AllowN(3))
+	assert.ErrorIs(t, tb.ReserveN(3).Err(), ErrExceedsBurst)
+	assert.Zero(t, mr.TTL("limit:norate"), "the state of a bucket without a rate must not expire")
+
+	negative := newRedisTokenBucket(client, "limit:negative", -5, 1, nil, clock)
+	assert.True(t, negative.Allow())
+	advance(mr, clock, time.Hour)
+	assert.False(t, negative.Allow())
+	assert.ErrorIs(t, negative.Wait(context.Background()), ErrNeverAllowed)
+	assert.Zero(t, tb.Fallbacks()+negative.Fallbacks())
+
+	sw := newRedisSlidingWindow(client, "limit:nowindow", 5, 0, nil, clock)
+	assert.False(t, sw.Allow())
+	assert.ErrorIs(t, sw.Reserve().Err(), ErrNeverAllowed)
+	assert.Zero(t, sw.Fallbacks())
+}
+
+// stallingScripter is a Redis that never answers before ctx is done
+type stallingScripter struct {
+	redis.Scripter
+}
+
+func (stallingScripter) EvalSha(ctx context.Context, sha1 string, keys []string, args ...any) *redis.Cmd {
+	<-ctx.Done()
+	cmd := redis.NewCmd(ctx)
+	cmd.SetErr(ctx.Err())
+	return cmd
+}
+
+func TestRedisLimiters_WaitCancelledIsNotAFallback(t *testing.T) {
+	client := stallingScripter{}
+	limiters := map[string]interface {
+		Limiter
+		Fallbacks() uint64
+	}{
+		"token bucket":   NewRedisTokenBucket(client, "limit:cancel", 10, 10, NewTokenBucket(10, 10)),
+		"sliding window": NewRedisSlidingWindow(client, "limit:cancel", 10, time.Second, NewTokenBucket(10, 10)),
+	}
+
+	for name, l := range limiters {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(20*time.Millisecond, cancel)
+
+			assert.ErrorIs(t, l.Wait(ctx), context.Canceled)
+			assert.Zero(t, l.Fallbacks())
+
+			// Redis timing out on its own is still a fallback
+			assert.True(t, l.Allow())
+			assert.Equal(t, uint64(1), l.Fallbacks())
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+var ErrExceedsLimit = errors.New("requested events exceed window limit")
+
+// SlidingWindowLog remembers the timestamp of every admitted event and
+// allows at most limit events in any window of the given length
+//
+// It is exact but needs memory proportional to limit. A window of zero or less
+// has no interval to count events in, so nothing is allowed and reservations
+// fail with ErrNeverAllowed.
+type SlidingWindowLog struct {
+	mu     sync.Mutex
+	clock  Clock
+	limit  int
+	window time.Duration
+	log    []time.Time // sorted, may contain future reservations
+}
+
+func NewSlidingWindowLog(limit int, window time.Duration) *SlidingWindowLog {
+	return newSlidingWindowLog(limit, window, realClock{})
+}
+
+func newSlidingWindowLog(limit int, window time.Duration, clock Clock) *SlidingWindowLog {
+	return &SlidingWindowLog{
+		clock:  clock,
+		limit:  limit,
+		window: window,
+		log:    make([]time.Time, 0, limit),
+	}
+}
+
+// prune drops events that left the window ending at now
+// must be called with sw.mu held
+func (sw *SlidingWindowLog) prune(now time.Time) {
+	cutoff := now.Add(-sw.window)
+
+	i := 0
+	for i < len(sw.log) && !sw.log[i].After(cutoff) {
+		i++
+	}
+	sw.log = append(sw.log[:0], sw.log[i:]...)
+}
+
+func (sw *SlidingWindowLog) Allow() bool {
+	return sw.AllowN(1)
+}
+
+func (sw *SlidingWindowLog) AllowN(n int) bool {
+	if sw.window <= 0 {
+		return false
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	now := sw.clock.Now()
+	sw.prune(now)
+
+	if len(sw.log)+n > sw.limit {
+		return false
+	}
+	if len(sw.log) > 0 && sw.log[len(sw.log)-1].After(now) {
+		// someone is already waiting, don't jump the queue
+		return false
+	}
+	for range n {
+		sw.log = append(sw.log, now)
+	}
+	return true
+}
+
+func (sw *SlidingWindowLog) Wait(ctx context.Context) error {
+	return sw.WaitN(ctx, 1)
+}
+
+func (sw *SlidingWindowLog) WaitN(ctx context.Context, n int) error {
+	return wait(ctx, func() *Reservation {
+		return sw.ReserveN(n)
+	})
+}
+
+func (sw *SlidingWindowLog) Reserve() *Reservation {
+	return sw.ReserveN(1)
+}
+
+// books n events at the earliest time they fit in the window
+//
+// Reservations are handed out in order, so the booked time is never earlier
+// than the last booking.
+func (sw *SlidingWindowLog) ReserveN(n int) *Reservation {
+	if sw.window <= 0 {
+		return failedReservation(ErrNeverAllowed)
+	}
+	if n > sw.limit {
+		return failedReservation(ErrExceedsLimit)
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	now := sw.clock.Now()
+	sw.prune(now)
+
+	at := now
+	if len(sw.log) > 0 && sw.log[len(sw.log)-1].After(at) {
+		at = sw.log[len(sw.log)-1]
+	}
+	// the oldest events have to expire to make room for n more
+	if excess := len(sw.log) + n - sw.limit; excess > 0 {
+		if expiry := sw.log[excess-1].Add(sw.window); expiry.After(at) {
+			at = expiry
+		}
+	}
+
+	for range n {
+		sw.log = append(sw.log, at)
+	}
+
+	return newReservation(sw.clock, at, func() {
+		sw.mu.Lock()
+		defer sw.mu.Unlock()
+
+		// remove n entries booked at exactly this time, newest first
+		removed := 0
+		for i := len(sw.log) - 1; i >= 0 && removed < n; i-- {
+			if sw.log[i].Equal(at) {
+				sw.log = append(sw.log[:i], sw.log[i+1:]...)
+				removed++
+			}
+		}
+	})
+}
+
+// SlidingWindowCounter approximates a sliding window with two fixed windows
+//
+// The count of the previous window is weighted by how much of it still
+// overlaps the sliding window, which needs constant memory per limiter at the
+// cost of assuming events were spread evenly over the previous window. Like
+// SlidingWindowLog it allows nothing with a window of zero or less.
+type SlidingWindowCounter struct {
+	mu     sync.Mutex
+	clock  Clock
+	limit  int
+	window time.Duration
+	origin time.Time
+	counts map[int64]int // fixed window index -> events, including future bookings
+	last   time.Time     // latest booking, reservations are handed out in order
+}
+
+func NewSlidingWindowCounter(limit int, window time.Duration) *SlidingWindowCounter {
+	return newSlidingWindowCounter(limit, window, realClock{})
+}
+
+func newSlidingWindowCounter(limit int, window time.Duration, clock Clock) *SlidingWindowCounter {
+	now := clock.Now()
+	return &SlidingWindowCounter{
+		clock:  clock,
+		limit:  limit,
+		window: window,
+		origin: now,
+		counts: make(map[int64]int),
+		last:   now,
+	}
+}
+
+// position returns the fixed window containing t and how far into it t is
+func (sc *SlidingWindowCounter) position(t time.Time) (int64, float64) {
+	elapsed := t.Sub(sc.origin)
+	idx := int64(elapsed / sc.window)
+	return idx, float64(elapsed%sc.window) / float64(sc.window)
+}
+
+// estimate returns the weighted event count of the sliding window ending at t
+// must be called with sc.mu held
+func (sc *SlidingWindowCounter) estimate(idx int64, frac float64) float64 {
+	return float64(sc.counts[idx-1])*(1-frac) + float64(sc.counts[idx])
+}
+
+// prune forgets fixed windows that can no longer affect the estimate
+// must be called with sc.mu held
+func (sc *SlidingWindowCounter) prune(idx int64) {
+	for i := range sc.counts {
+		if i < idx-1 {
+			delete(sc.counts, i)
+		}
+	}
+}
+
+func (sc *SlidingWindowCounter) Allow() bool {
+	return sc.AllowN(1)
+}
+
+func (sc *SlidingWindowCounter) AllowN(n int) bool {
+	if sc.window <= 0 {
+		return false
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	now := sc.clock.Now()
+	idx, frac := sc.position(now)
+	sc.prune(idx)
+
+	if sc.last.After(now) {
+		// someone is already waiting, don't jump the queue
+		return false
+	}
+	if sc.estimate(idx, frac)+float64(n) > float64(sc.limit) {
+		return false
+	}
+
+	sc.counts[idx] += n
+	sc.last = now
+	return true
+}
+
+func (sc *SlidingWindowCounter) Wait(ctx context.Context) error {
+	return sc.WaitN(ctx, 1)
+}
+
+func (sc *SlidingWindowCounter) WaitN(ctx context.Context, n int) error {
+	return wait(ctx, func() *Reservation {
+		return sc.ReserveN(n)
+	})
+}
+
+func (sc *SlidingWindowCounter) Reserve() *Reservation {
+	return sc.ReserveN(1)
+}
+
+// books n events at the earliest time the estimate leaves room for them
+func (sc *SlidingWindowCounter) ReserveN(n int) *Reservation {
+	if sc.window <= 0 {
+		return failedReservation(ErrNeverAllowed)
+	}
+	if n > sc.limit {
+		return failedReservation(ErrExceedsLimit)
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	now := sc.clock.Now()
+	nowIdx, _ := sc.position(now)
+	sc.prune(nowIdx)
+
+	start := now
+	if sc.last.After(start) {
+		start = sc.last
+	}
+	idx, frac := sc.position(start)
+
+	// walk forward one fixed window at a time until the booking fits
+	for {
+		room := float64(sc.limit - sc.counts[idx] - n)
+		if room >= 0 {
+			prev := float64(sc.counts[idx-1])
+			// solve prev*(1-f) + count + n <= limit for the fraction f
+			need := 0.0
+			if prev > 0 {
+				need = 1 - room/prev
+			}
+			if need < 1 {
+				frac = math.Max(frac, need)
+				break
+			}
+		}
+		idx++
+		frac = 0
+	}
+
+	at := sc.origin.Add(time.Duration(idx) * sc.window).Add(time.Duration(math.Ceil(frac * float64(sc.window))))
+	if at.Before(start) {
+		at = start
+	}
+
+	sc.counts[idx] += n
+	sc.last = at
+
+	return newReservation(sc.clock, at, func() {
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+
+		sc.counts[idx] -= n
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	ErrExceedsBurst        = errors.New("requested tokens exceed bucket burst")
+	ErrWaitExceedsDeadline = errors.New("wait would exceed context deadline")
+)
+
+// TokenBucket is a lazily refilled token bucket
+//
+// Instead of a background goroutine adding a token on every tick, the bucket
+// remembers when it was last updated and computes the refill on demand from
+// the elapsed time. Tokens are tracked as a float64 so partial refill progress
+// is never lost between calls.
+type TokenBucket struct {
+	mu     sync.Mutex
+	clock  Clock
+	rate   float64 // tokens added per second
+	burst  float64 // maximum number of tokens the bucket can hold
+	tokens float64 // may go negative while waiters hold reservations
+	last   time.Time
+}
+
+// creates a full bucket refilling at rate tokens per second up to burst tokens,
+// a rate of zero or less never refills
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return newTokenBucket(rate, burst, realClock{})
+}
+
+func newTokenBucket(rate float64, burst int, clock Clock) *TokenBucket {
+	return &TokenBucket{
+		clock:  clock,
+		rate:   max(rate, 0),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   clock.Now(),
+	}
+}
+
+// advance refills the bucket for the time elapsed since the last update
+// must be called with tb.mu held
+func (tb *TokenBucket) advance(now time.Time) {
+	if now.Before(tb.last) {
+		// clock went backwards, keep the current state
+		return
+	}
+
+	elapsed := now.Sub(tb.last)
+	tb.tokens = math.Min(tb.burst, tb.tokens+elapsed.Seconds()*tb.rate)
+	tb.last = now
+}
+
+// non-blocking, reports whether a single token was taken
+func (tb *TokenBucket) Allow() bool {
+	return tb.AllowN(1)
+}
+
+// non-blocking, takes n tokens only if all of them are available right now
+func (tb *TokenBucket) AllowN(n int) bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.advance(tb.clock.Now())
+
+	if tb.tokens >= float64(n) {
+		tb.tokens -= float64(n)
+		return true
+	}
+	return false
+}
+
+// blocks until a single token is available or ctx is done
+func (tb *TokenBucket) Wait(ctx context.Context) error {
+	return tb.WaitN(ctx, 1)
+}
+
+// blocks until n tokens are available or ctx is done
+//
+// The tokens are reserved up front, so concurrent waiters are served in the
+// order they called WaitN. If ctx is cancelled while waiting the reservation
+// is returned to the bucket.
+func (tb *TokenBucket) WaitN(ctx context.Context, n int) error {
+	return wait(ctx, func() *Reservation {
+		return tb.ReserveN(n)
+	})
+}
+
+func (tb *TokenBucket) Reserve() *Reservation {
+	return tb.ReserveN(1)
+}
+
+// takes n tokens now, letting the bucket go into debt if they are not there yet
+func (tb *TokenBucket) ReserveN(n int) *Reservation {
+	if float64(n) > tb.burst {
+		return failedReservation(ErrExceedsBurst)
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.clock.Now()
+	tb.advance(now)
+	tb.tokens -= float64(n)
+
+	var wait time.Duration
+	if tb.tokens < 0 {
+		wait = InfDuration
+		if tb.rate > 0 {
+			wait = durationFor(-tb.tokens / tb.rate)
+		}
+	}
+	if wait == InfDuration {
+		// the bucket never refills, don't hold on to the tokens
+		tb.tokens += float64(n)
+		return failedReservation(ErrNeverAllowed)
+	}
+
+	return newReservation(tb.clock, now.Add(wait), func() {
+		tb.mu.Lock()
+		defer tb.mu.Unlock()
+
+		tb.advance(tb.clock.Now())
+		tb.tokens = math.Min(tb.burst, tb.tokens+float64(n))
+	})
+}
+
+// returns the number of tokens currently available
+func (tb *TokenBucket) Tokens() float64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.advance(tb.clock.Now())
+	return tb.tokens
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket_Burst(t *testing.T) {
+	clock := newFakeClock()
+	tb := newTokenBucket(10, 5, clock)
+
+	for i := 0; i < 5; i++ {
+		assert.True(t, tb.Allow(), "request %d should be allowed", i)
+	}
+	assert.False(t, tb.Allow())
+}
+
+func TestTokenBucket_FractionalRefill(t *testing.T) {
+	clock := newFakeClock()
+	tb := newTokenBucket(10, 2, clock) // one token every 100ms
+	require.True(t, tb.AllowN(2))
+
+	// 3 x 40ms = 120ms, the old implementation truncated each
+	// 40ms step to zero tokens and never refilled
+	for i := 0; i < 2; i++ {
+		clock.Advance(40 * time.Millisecond)
+		assert.False(t, tb.Allow())
+	}
+	clock.Advance(40 * time.Millisecond)
+	assert.True(t, tb.Allow())
+
+	// the 20ms of progress past the last token is kept
+	assert.InDelta(t, 0.2, tb.Tokens(), 1e-9)
+}
+
+func TestTokenBucket_RefillCappedAtBurst(t *testing.T) {
+	clock := newFakeClock()
+	tb := newTokenBucket(100, 3, clock)
+
+	clock.Advance(time.Hour)
+	assert.Equal(t, 3.0, tb.Tokens())
+	assert.True(t, tb.AllowN(3))
+	assert.False(t, tb.Allow())
+}
+
+func TestTokenBucket_AllowNDoesNotPartiallyConsume(t *testing.T) {
+	clock := newFakeClock()
+	tb := newTokenBucket(1, 4, clock)
+
+	require.True(t, tb.AllowN(2))
+	assert.False(t, tb.AllowN(3))
+	assert.Equal(t, 2.0, tb.Tokens())
+}
+
+func TestTokenBucket_Wait(t *testing.T) {
+	clock := newFakeClock()
+	tb := newTokenBucket(10, 1, clock)
+	require.True(t, tb.Allow())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- tb.Wait(context.Background())
+	}()
+
+	clock.BlockUntil(1)
+	select {
+	case <-done:
+		t.Fatal("Wait returned before the token was refilled")
+	default:
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	require.NoError(t, <-done)
+	assert.False(t, tb.Allow())
+}
+
+func TestTokenBucket_WaitersServedInOrder(t *testing.T) {
+	clock := newFakeClock()
+	tb := newTokenBucket(10, 1, clock)
+	require.True(t, tb.Allow())
+
+	var (
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	for i := 1; i <= 3; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			require.NoError(t, tb.Wait(context.Background()))
+			mu.Lock()
+			order = append(order, id)
+			mu.Unlock()
+		}(i)
+		// make sure the reservations happen in a known order
+		clock.BlockUntil(i)
+	}
+
+	for i := 1; i <= 3; i++ {
+		clock.Advance(100 * time.Millisecond)
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(order) == i
+		}, time.Second, time.Millisecond)
+	}
+	wg.Wait()
+
+	assert.Equal(t, []int{1, 2, 3}, order)
+}
+
+func TestTokenBucket_WaitCancelledReturnsTokens(t *testing.T) {
+	clock := newFakeClock()
+	tb := newTokenBucket(10, 2, clock)
+	require.True(t, tb.AllowN(2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- tb.WaitN(ctx, 2)
+	}()
+
+	clock.BlockUntil(1)
+	assert.InDelta(t, -2.0, tb.Tokens(), 1e-9)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.InDelta(t, 0.0, tb.Tokens(), 1e-9)
+}
+
+func TestTokenBucket_WaitErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int
+		ctx     func() (context.Context, context.CancelFunc)
+		wantErr error
+	}{
+		{
+			name: "more than burst",
+			n:    5,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+			wantErr: ErrExceedsBurst,
+		},
+		{
+			name: "already cancelled",
+			n:    1,
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			wantErr: context.Canceled,
+		},
+		{
+			name: "deadline too close",
+			n:    2,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 10*time.Millisecond)
+			},
+			wantErr: ErrWaitExceedsDeadline,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := NewTokenBucket(1, 2)
+			require.True(t, tb.AllowN(2))
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			err := tb.WaitN(ctx, tt.n)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Less(t, tb.Tokens(), 1.0, "failed waits must not consume tokens")
+		})
+	}
+}
+
+func TestTokenBucket_ConcurrentAllow(t *testing.T) {
+	clock := newFakeClock()
+	tb := newTokenBucket(1, 100, clock)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if tb.Allow() {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 100, allowed)
+}
+
+func BenchmarkTokenBucket_Allow(b *testing.B) {
+	tb := NewTokenBucket(1e9, 1e9)
+	for b.Loop() {
+		tb.Allow()
+	}
+}
+
+func BenchmarkTokenBucket_AllowParallel(b *testing.B) {
+	tb := NewTokenBucket(1e9, 1e9)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tb.Allow()
+		}
+	})
+}
+
+func BenchmarkTokenBucket_Wait(b *testing.B) {
+	tb := NewTokenBucket(1e9, 1e9)
+	ctx := context.Background()
+	for b.Loop() {
+		tb.Wait(ctx)
+	}
+}
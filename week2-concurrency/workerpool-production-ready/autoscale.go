@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultAutoscaleInterval is used when AutoscaleConfig.Interval isn't set
+const DefaultAutoscaleInterval = time.Second
+
+type AutoscaleConfig struct {
+	Min int
+	Max int
+	// how often the load is evaluated, DefaultAutoscaleInterval when not
+	// positive
+	Interval time.Duration
+	// called for every evaluation that changed the number of workers
+	OnScale func(ScalingDecision)
+}
+
+// ScalingDecision describes one autoscaler evaluation
+type ScalingDecision struct {
+	At         time.Time
+	From       int
+	To         int
+	QueueDepth int
+	// jobs submitted per second since the last evaluation
+	ArrivalRate float64
+	// average processing time of the jobs completed since the last evaluation
+	AvgLatency time.Duration
+	Reason     string
+}
+
+// loadStats accumulates what the autoscaler needs between two evaluations
+type loadStats struct {
+	mu          sync.Mutex
+	submitted   int
+	completed   int
+	busy        time.Duration
+	lastLatency time.Duration // carried over when nothing completed
+}
+
+func (l *loadStats) recordSubmitted() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.submitted++
+}
+
+func (l *loadStats) recordCompleted(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.completed++
+	l.busy += latency
+}
+
+// reset returns the jobs submitted and the average latency since the last
+// call and starts a new period
+func (l *loadStats) reset() (int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.completed > 0 {
+		l.lastLatency = l.busy / time.Duration(l.completed)
+	}
+	submitted := l.submitted
+	l.submitted, l.completed, l.busy = 0, 0, 0
+	return submitted, l.lastLatency
+}
+
+// autoscale evaluates the load every interval until the pool shuts down
+func (p *Pool[In, Out]) autoscale(cfg AutoscaleConfig) {
+	// a zero interval would spin and divide the arrival rate by zero
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultAutoscaleInterval
+	}
+
+	for {
+		select {
+		case <-p.clock.After(cfg.Interval):
+			p.evaluateScaling(cfg)
+		case <-p.stopping:
+			return
+		}
+	}
+}
+
+// evaluateScaling sizes the pool for the load seen over the last interval
+//
+// By Little's law arrivals per second times latency is the number of workers
+// kept busy by new work, on top of that the current backlog should be cleared
+// within one interval. The pool scales up to that target straight away but
+// only gives back one worker per interval to avoid flapping.
+func (p *Pool[In, Out]) evaluateScaling(cfg AutoscaleConfig) ScalingDecision {
+	submitted, latency := p.load.reset()
+
+	d := ScalingDecision{
+		At:          p.clock.Now(),
+		From:        p.Size(),
+		QueueDepth:  p.QueueDepth(),
+		ArrivalRate: float64(submitted) / cfg.Interval.Seconds(),
+		AvgLatency:  latency,
+	}
+
+	var target int
+	if latency == 0 {
+		// nothing has completed yet, grow slowly while work is piling up
+		target = d.From
+		if d.QueueDepth > 0 {
+			target++
+		}
+	} else {
+		steady := d.ArrivalRate * latency.Seconds()
+		backlog := float64(d.QueueDepth) * latency.Seconds() / cfg.Interval.Seconds()
+		target = int(math.Ceil(steady + backlog))
+	}
+	target = min(max(target, cfg.Min), cfg.Max)
+
+	switch {
+	case target > d.From:
+		d.To = target
+	case target < d.From:
+		d.To = d.From - 1
+	default:
+		d.To = d.From
+	}
+	d.Reason = fmt.Sprintf("target %d workers for %.1f jobs/s at %v with %d queued",
+		target, d.ArrivalRate, latency.Round(time.Millisecond), d.QueueDepth)
+
+	if d.To == d.From {
+		return d
+	}
+	if err := p.Resize(d.To); err != nil {
+		// shutting down
+		d.To = d.From
+		return d
+	}
+
+	p.instr.Logf("Autoscaler: %d -> %d workers (%s)", d.From, d.To, d.Reason)
+	if cfg.OnScale != nil {
+		cfg.OnScale(d)
+	}
+	return d
+}
@@ -0,0 +1,20 @@
+package main
+
+import "time"
+
+// Clock abstracts time so the pool can be driven deterministically in tests
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the wall clock used by the public constructors
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock is a manually advanced Clock for deterministic tests
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward and fires every waiter that became due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = pending
+}
+
+// BlockUntil waits until n goroutines are blocked on After
+func (c *fakeClock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		count := len(c.waiters)
+		c.mu.Unlock()
+		if count >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
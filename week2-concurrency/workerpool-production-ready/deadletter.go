@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// DeadLetter is a job that failed for good, either because its error was not
+// retryable or because it ran out of attempts
+type DeadLetter[In any] struct {
+	JobID    uint64
+	Input    In
+	Err      error
+	Attempts int
+	FailedAt time.Time
+
+	task task[In] // to resubmit the job as it was submitted
+}
+
+// DeadLetterQueue keeps failed jobs around so they can be inspected and
+// resubmitted once the cause has been fixed
+type DeadLetterQueue[In any] struct {
+	mu      sync.Mutex
+	letters []DeadLetter[In] // oldest first
+}
+
+func NewDeadLetterQueue[In any]() *DeadLetterQueue[In] {
+	return &DeadLetterQueue[In]{}
+}
+
+func (q *DeadLetterQueue[In]) add(letter DeadLetter[In]) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.letters = append(q.letters, letter)
+}
+
+// take removes the letter for jobID from the queue
+func (q *DeadLetterQueue[In]) take(jobID uint64) (DeadLetter[In], bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, letter := range q.letters {
+		if letter.JobID == jobID {
+			q.letters = append(q.letters[:i], q.letters[i+1:]...)
+			return letter, true
+		}
+	}
+	return DeadLetter[In]{}, false
+}
+
+// returns a copy of every dead letter, oldest first
+func (q *DeadLetterQueue[In]) List() []DeadLetter[In] {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	letters := make([]DeadLetter[In], len(q.letters))
+	copy(letters, q.letters)
+	return letters
+}
+
+func (q *DeadLetterQueue[In]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.letters)
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// FileStore is a JobStore backed by an append-only write-ahead log
+//
+// Every state change is appended as a JSON line and synced to disk before the
+// call returns. Opening the store replays the log and compacts it down to the
+// jobs that are still pending, and so does an append once the log has grown
+// enough, so it doesn't grow without bound. Inputs must survive a round trip
+// through encoding/json.
+//
+// The idempotency keys of finished jobs are kept as FileStoreOptions says,
+// a key that has expired may be used again.
+type FileStore[In any] struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	opts     FileStoreOptions
+	clock    Clock
+	jobs     map[uint64]*JobRecord[In]
+	keys     map[string]uint64 // idempotency key -> job id, kept after the job finishes
+	finished *list.List        // finishedKey of finished jobs, oldest first
+	byKey    map[string]*list.Element
+	lastID   uint64
+
+	size     int64 // bytes of whole entries in the log
+	kept     int   // entries written by the last compaction
+	appended int   // entries appended since
+}
+
+const (
+	DefaultKeyTTL       = 24 * time.Hour
+	DefaultMaxKeys      = 100_000
+	DefaultCompactEvery = 10_000
+)
+
+// FileStoreOptions bounds how long a FileStore remembers the idempotency keys
+// of finished jobs and how far its log grows between compactions
+type FileStoreOptions struct {
+	// how long after its job finished a key still rejects duplicates, 0 uses
+	// DefaultKeyTTL and a negative value keeps keys forever
+	KeyTTL time.Duration
+	// keys of finished jobs kept at most, the oldest are dropped first, 0
+	// uses DefaultMaxKeys and a negative value keeps any number
+	MaxKeys int
+	// entries appended before the log is compacted again, at least as many
+	// as the last compaction kept, 0 uses DefaultCompactEvery and a negative
+	// value only compacts on open
+	CompactEvery int
+}
+
+// finishedKey is the key of a finished job and when it finished
+type finishedKey struct {
+	key string
+	id  uint64
+	at  time.Time
+}
+
+// walEntry is a single line of the log
+type walEntry[In any] struct {
+	Op       string `json:"op"`
+	ID       uint64 `json:"id"`
+	Key      string `json:"key,omitempty"`
+	Input    *In    `json:"input,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	Tenant   string `json:"tenant,omitempty"`
+	// when a job finished, for the retention of its key
+	At time.Time `json:"at,omitzero"`
+}
+
+const (
+	opSubmit  = "submit"
+	opStart   = "start"
+	opFinish  = "finish"
+	opDiscard = "discard"
+	opSeq     = "seq" // carries lastID across compactions
+)
+
+var _ JobStore[int] = (*FileStore[int])(nil)
+
+// opens the log at path, creating it if needed, and replays it
+func OpenFileStore[In any](path string) (*FileStore[In], error) {
+	return OpenFileStoreWithOptions[In](path, FileStoreOptions{})
+}
+
+// opens the log at path like OpenFileStore with its own key retention
+func OpenFileStoreWithOptions[In any](path string, opts FileStoreOptions) (*FileStore[In], error) {
+	return openFileStore[In](path, opts, realClock{})
+}
+
+func openFileStore[In any](path string, opts FileStoreOptions, clock Clock) (*FileStore[In], error) {
+	if opts.KeyTTL == 0 {
+		opts.KeyTTL = DefaultKeyTTL
+	}
+	if opts.MaxKeys == 0 {
+		opts.MaxKeys = DefaultMaxKeys
+	}
+	if opts.CompactEvery == 0 {
+		opts.CompactEvery = DefaultCompactEvery
+	}
+	s := &FileStore[In]{
+		path:     path,
+		opts:     opts,
+		clock:    clock,
+		jobs:     make(map[uint64]*JobRecord[In]),
+		keys:     make(map[string]uint64),
+		finished: list.New(),
+		byKey:    make(map[string]*list.Element),
+	}
+
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s.file = file
+	return s, nil
+}
+
+// replay rebuilds the in-memory state from the log
+//
+// A crash can leave the last line half written, that line is ignored. So is
+// a bad line elsewhere, which only an append that failed and could not be cut
+// off leaves behind, and that append was never acknowledged.
+func (s *FileStore[In]) replay() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// anything without a trailing newline is a torn write
+			return nil
+		}
+
+		var e walEntry[In]
+		if err := json.Unmarshal(line, &e); err != nil {
+			continue
+		}
+		s.apply(e)
+	}
+}
+
+func (s *FileStore[In]) apply(e walEntry[In]) {
+	s.lastID = max(s.lastID, e.ID)
+
+	switch e.Op {
+	case opSubmit:
+		rec := &JobRecord[In]{ID: e.ID, Key: e.Key, Priority: e.Priority, Tenant: e.Tenant}
+		if e.Input != nil {
+			rec.Input = *e.Input
+		}
+		s.jobs[e.ID] = rec
+		if e.Key != "" {
+			s.keys[e.Key] = e.ID
+		}
+	case opStart:
+		if rec, ok := s.jobs[e.ID]; ok {
+			rec.Started = true
+		}
+	case opFinish:
+		// compact writes the key, the live entry leaves it to the submit
+		key := e.Key
+		if rec, ok := s.jobs[e.ID]; ok && key == "" {
+			key = rec.Key
+		}
+		delete(s.jobs, e.ID)
+		if key != "" {
+			at := e.At
+			if at.IsZero() {
+				// written before keys expired, it gets a full TTL from now
+				at = s.clock.Now()
+			}
+			s.keys[key] = e.ID
+			// a job finishing again, e.g. from the dead letter queue, restarts
+			// the retention of its key
+			if old, ok := s.byKey[key]; ok {
+				s.finished.Remove(old)
+			}
+			s.byKey[key] = s.finished.PushBack(finishedKey{key: key, id: e.ID, at: at})
+			s.expireKeys()
+		}
+	case opDiscard:
+		if rec, ok := s.jobs[e.ID]; ok && rec.Key != "" && s.keys[rec.Key] == e.ID {
+			delete(s.keys, rec.Key)
+		}
+		delete(s.jobs, e.ID)
+	}
+}
+
+// expireKeys forgets the keys of finished jobs that are past the TTL or over
+// the maximum count
+func (s *FileStore[In]) expireKeys() {
+	now := s.clock.Now()
+	for front := s.finished.Front(); front != nil; front = s.finished.Front() {
+		oldest := front.Value.(finishedKey)
+		expired := s.opts.KeyTTL > 0 && !now.Before(oldest.at.Add(s.opts.KeyTTL))
+		over := s.opts.MaxKeys > 0 && s.finished.Len() > s.opts.MaxKeys
+		if !expired && !over {
+			return
+		}
+
+		// the key may have been taken again by a pending job since
+		if id, ok := s.keys[oldest.key]; ok && id == oldest.id {
+			if _, pending := s.jobs[id]; !pending {
+				delete(s.keys, oldest.key)
+			}
+		}
+		s.finished.Remove(front)
+		delete(s.byKey, oldest.key)
+	}
+}
+
+// compact rewrites the log with just the current state, the new log is
+// written to a temporary file and renamed over the old one so a crash leaves
+// one or the other
+func (s *FileStore[In]) compact() error {
+	var buf bytes.Buffer
+	entries := 0
+	write := func(e walEntry[In]) error {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		entries++
+		return nil
+	}
+
+	if err := write(walEntry[In]{Op: opSeq, ID: s.lastID}); err != nil {
+		return err
+	}
+	// expired keys are left out
+	s.expireKeys()
+	for el := s.finished.Front(); el != nil; el = el.Next() {
+		k := el.Value.(finishedKey)
+		if id, ok := s.keys[k.key]; !ok || id != k.id {
+			continue
+		}
+		if _, pending := s.jobs[k.id]; pending {
+			continue
+		}
+		if err := write(walEntry[In]{Op: opFinish, ID: k.id, Key: k.key, At: k.at}); err != nil {
+			return err
+		}
+	}
+	for _, rec := range s.pending() {
+		if err := write(submitEntry(rec)); err != nil {
+			return err
+		}
+		if rec.Started {
+			if err := write(walEntry[In]{Op: opStart, ID: rec.ID}); err != nil {
+				return err
+			}
+		}
+	}
+
+	tmp := s.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.size, s.kept, s.appended = int64(buf.Len()), entries, 0
+	return syncDir(filepath.Dir(s.path))
+}
+
+// compactDue reports whether the log has grown enough to be worth compacting
+// while the store is open, in proportion to what the last compaction kept so
+// the cost of compacting is spread over the appends in between
+// must be called with s.mu held
+func (s *FileStore[In]) compactDue() bool {
+	return s.opts.CompactEvery > 0 && s.appended >= max(s.opts.CompactEvery, s.kept)
+}
+
+// recompact compacts the log of an open store and switches to the new file
+//
+// Compaction can fail after the rename, so the log is reopened either way.
+// must be called with s.mu held
+func (s *FileStore[In]) recompact() error {
+	cerr := s.compact()
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	s.file.Close()
+	if err != nil {
+		// the old file may have been replaced, appending to it would lose entries
+		s.file = nil
+		return errors.Join(cerr, err)
+	}
+	s.file = file
+	return cerr
+}
+
+// syncDir makes a rename in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+func submitEntry[In any](rec JobRecord[In]) walEntry[In] {
+	return walEntry[In]{
+		Op:       opSubmit,
+		ID:       rec.ID,
+		Key:      rec.Key,
+		Input:    &rec.Input,
+		Priority: rec.Priority,
+		Tenant:   rec.Tenant,
+	}
+}
+
+// pending returns copies of the unfinished jobs in submission order
+// must be called with s.mu held
+func (s *FileStore[In]) pending() []JobRecord[In] {
+	recs := make([]JobRecord[In], 0, len(s.jobs))
+	for _, rec := range s.jobs {
+		recs = append(recs, *rec)
+	}
+	slices.SortFunc(recs, func(a, b JobRecord[In]) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return recs
+}
+
+// appendEntry writes e to the log and syncs it before the state change is
+// acknowledged
+// must be called with s.mu held
+func (s *FileStore[In]) appendEntry(e walEntry[In]) error {
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	if s.compactDue() {
+		if err := s.recompact(); err != nil {
+			return err
+		}
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := s.file.Write(line); err != nil {
+		return s.cutOff(err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return s.cutOff(err)
+	}
+	s.size += int64(len(line))
+	s.appended++
+
+	s.apply(e)
+	return nil
+}
+
+// cutOff truncates what a failed append left of its entry so the next one
+// doesn't end up on the same line, a log that can't be cut is closed
+// must be called with s.mu held
+func (s *FileStore[In]) cutOff(err error) error {
+	if terr := s.file.Truncate(s.size); terr != nil {
+		s.file.Close()
+		s.file = nil
+		return errors.Join(err, terr)
+	}
+	return err
+}
+
+func (s *FileStore[In]) Submitted(rec JobRecord[In]) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec.Key != "" {
+		s.expireKeys()
+		if id, ok := s.keys[rec.Key]; ok && id != rec.ID {
+			return ErrDuplicateJob
+		}
+	}
+	return s.appendEntry(submitEntry(rec))
+}
+
+func (s *FileStore[In]) Started(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.appendEntry(walEntry[In]{Op: opStart, ID: id})
+}
+
+func (s *FileStore[In]) Finished(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.appendEntry(walEntry[In]{Op: opFinish, ID: id, At: s.clock.Now()})
+}
+
+func (s *FileStore[In]) Discard(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.appendEntry(walEntry[In]{Op: opDiscard, ID: id})
+}
+
+func (s *FileStore[In]) Recover() (Recovery[In], error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return Recovery[In]{Pending: s.pending(), LastID: s.lastID}, nil
+}
+
+// Close closes the log, the store can't be used afterwards
+func (s *FileStore[In]) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
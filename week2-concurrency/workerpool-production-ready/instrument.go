@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// JobInfo describes the job an instrumentation event is about
+type JobInfo struct {
+	ID       uint64
+	Priority int
+	Tenant   string
+	WorkerID int // zero until a worker picks the job up
+}
+
+// Instrumentation receives the pool's logs, metrics and traces
+//
+// Implementations must be safe for concurrent use and shouldn't block, they
+// are called on the workers' hot path.
+type Instrumentation interface {
+	// a human readable progress message
+	Logf(format string, args ...any)
+	// the job was accepted, queueDepth includes it
+	JobSubmitted(job JobInfo, queueDepth int)
+	// a worker picked the job up after it waited in the queue, the returned
+	// context is the parent of every attempt's context
+	JobStarted(ctx context.Context, job JobInfo, queueWait time.Duration, queueDepth int) context.Context
+	// the job is done after attempts tries taking latency in total, err is
+	// the last error or nil on success, ctx is done if a forced shutdown cut
+	// the job short
+	JobFinished(ctx context.Context, job JobInfo, attempts int, latency time.Duration, err error)
+}
+
+// queueWatcher is an Instrumentation that reads the queue depth from the pool
+// when it needs it instead of from the events
+type queueWatcher interface {
+	watchQueue(depth func() int)
+}
+
+// NopInstrumentation ignores everything, it is the default so tests stay
+// quiet. Embed it to implement only part of Instrumentation.
+type NopInstrumentation struct{}
+
+func (NopInstrumentation) Logf(string, ...any)       {}
+func (NopInstrumentation) JobSubmitted(JobInfo, int) {}
+func (NopInstrumentation) JobStarted(ctx context.Context, _ JobInfo, _ time.Duration, _ int) context.Context {
+	return ctx
+}
+func (NopInstrumentation) JobFinished(context.Context, JobInfo, int, time.Duration, error) {}
+
+// LogInstrumentation prints the pool's progress messages to stdout
+type LogInstrumentation struct {
+	NopInstrumentation
+}
+
+func (LogInstrumentation) Logf(format string, args ...any) {
+	fmt.Printf(format+"\n", args...)
+}
+
+// multiInstrumentation fans every event out to several instrumentations
+type multiInstrumentation []Instrumentation
+
+// Instruments combines instrumentations, e.g. logs, metrics and tracing
+func Instruments(instruments ...Instrumentation) Instrumentation {
+	return multiInstrumentation(instruments)
+}
+
+func (m multiInstrumentation) Logf(format string, args ...any) {
+	for _, i := range m {
+		i.Logf(format, args...)
+	}
+}
+
+func (m multiInstrumentation) JobSubmitted(job JobInfo, queueDepth int) {
+	for _, i := range m {
+		i.JobSubmitted(job, queueDepth)
+	}
+}
+
+func (m multiInstrumentation) JobStarted(ctx context.Context, job JobInfo, queueWait time.Duration, queueDepth int) context.Context {
+	for _, i := range m {
+		ctx = i.JobStarted(ctx, job, queueWait, queueDepth)
+	}
+	return ctx
+}
+
+func (m multiInstrumentation) JobFinished(ctx context.Context, job JobInfo, attempts int, latency time.Duration, err error) {
+	for _, i := range m {
+		i.JobFinished(ctx, job, attempts, latency, err)
+	}
+}
+
+func (m multiInstrumentation) watchQueue(depth func() int) {
+	for _, i := range m {
+		if w, ok := i.(queueWatcher); ok {
+			w.watchQueue(depth)
+		}
+	}
+}
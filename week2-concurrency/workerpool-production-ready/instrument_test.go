@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// failOnEven succeeds for odd inputs only
+func failOnEven(ctx context.Context, n int) (int, error) {
+	if n%2 == 0 {
+		return 0, errors.New("even")
+	}
+	return n, nil
+}
+
+func TestHistogram_Write(t *testing.T) {
+	h := newHistogram([]float64{0.1, 1})
+	for _, v := range []float64{0.05, 0.1, 0.5, 20} {
+		h.observe(v)
+	}
+
+	var buf bytes.Buffer
+	h.write(&buf, "latency_seconds", "Latency.")
+
+	assert.Equal(t, `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 3
+latency_seconds_bucket{le="+Inf"} 4
+latency_seconds_sum 20.65
+latency_seconds_count 4
+`, buf.String())
+}
+
+func TestMetrics_CountsJobs(t *testing.T) {
+	metrics := NewMetrics("workerpool")
+	pool := NewPool(PoolConfig{Workers: 2, QueueSize: 10, Instrumentation: metrics}, failOnEven)
+	pool.Start()
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, pool.Submit(i))
+	}
+	collect(t, pool, 5)
+	pool.Stop()
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE workerpool_jobs_submitted_total counter",
+		"workerpool_jobs_submitted_total 5",
+		"workerpool_jobs_completed_total 3",
+		"workerpool_jobs_failed_total 2",
+		"workerpool_jobs_in_progress 0",
+		"# TYPE workerpool_queue_depth gauge",
+		"# TYPE workerpool_job_queue_wait_seconds histogram",
+		"workerpool_job_queue_wait_seconds_count 5",
+		`workerpool_job_duration_seconds_bucket{le="+Inf"} 5`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
+
+func TestMetrics_CancelledJobsAreNotFailures(t *testing.T) {
+	metrics := NewMetrics("workerpool")
+	gate := make(chan struct{})
+	var running atomic.Int32
+	pool := NewPool(PoolConfig{Workers: 1, QueueSize: 10, Instrumentation: metrics}, gatedHandler(gate, &running))
+	pool.Start()
+
+	require.NoError(t, pool.Submit(1))
+	require.Eventually(t, func() bool { return running.Load() == 1 }, time.Second, time.Millisecond)
+	pool.Stop()
+
+	var buf bytes.Buffer
+	require.NoError(t, metrics.WritePrometheus(&buf))
+	assert.Contains(t, buf.String(), "workerpool_jobs_cancelled_total 1\n")
+	assert.Contains(t, buf.String(), "workerpool_jobs_failed_total 0\n")
+}
+
+func TestMetrics_QueueDepthReadWhenScraped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.wal")
+	store := openStore(t, path)
+	for id := uint64(1); id <= 3; id++ {
+		require.NoError(t, store.Submitted(JobRecord[int]{ID: id, Input: int(id)}))
+	}
+
+	// recovered jobs are queued without a submit event, and the pool is found
+	// inside combined instrumentations
+	metrics := NewMetrics("")
+	_, err := NewDurablePool(PoolConfig{
+		Workers:         1,
+		QueueSize:       10,
+		Instrumentation: Instruments(NopInstrumentation{}, metrics),
+	}, failOnEven, store)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, metrics.WritePrometheus(&buf))
+	assert.Contains(t, buf.String(), "\nqueue_depth 3\n")
+}
+
+func TestTracing_SpanPerJob(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := provider.Tracer("workerpool")
+
+	pool := NewPool(PoolConfig{
+		Workers:         1,
+		QueueSize:       10,
+		Instrumentation: Instruments(NopInstrumentation{}, NewTracing(tracer)),
+	}, func(ctx context.Context, n int) (int, error) {
+		// spans started by the handler hang off the job's span
+		_, span := tracer.Start(ctx, "handler")
+		span.End()
+		return failOnEven(ctx, n)
+	})
+	pool.Start()
+
+	require.NoError(t, pool.SubmitWith(1, JobOptions{Tenant: "acme", Priority: 2}))
+	require.NoError(t, pool.Submit(2))
+	collect(t, pool, 2)
+	pool.Stop()
+
+	jobs := make(map[int64]sdktrace.ReadOnlySpan)
+	var handlers []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case "workerpool.job":
+			attrs := attribute.NewSet(span.Attributes()...)
+			id, _ := attrs.Value("job.id")
+			jobs[id.AsInt64()] = span
+		case "handler":
+			handlers = append(handlers, span)
+		}
+	}
+	require.Len(t, jobs, 2)
+	require.Len(t, handlers, 2)
+
+	ok := attribute.NewSet(jobs[1].Attributes()...)
+	tenant, _ := ok.Value("job.tenant")
+	assert.Equal(t, "acme", tenant.AsString())
+	attempts, _ := ok.Value("job.attempts")
+	assert.Equal(t, int64(1), attempts.AsInt64())
+	assert.Equal(t, codes.Unset, jobs[1].Status().Code)
+
+	assert.Equal(t, codes.Error, jobs[2].Status().Code)
+	assert.Equal(t, "even", jobs[2].Status().Description)
+
+	for _, h := range handlers {
+		parent := h.Parent().SpanID()
+		assert.True(t, parent == jobs[1].SpanContext().SpanID() || parent == jobs[2].SpanContext().SpanID())
+	}
+}
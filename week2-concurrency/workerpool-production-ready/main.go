@@ -3,7 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
-	"sync"
+	"os"
 	"time"
 )
 
@@ -13,118 +13,55 @@ type Job struct {
 	Data string
 }
 
-// represents the output of processing a job
-type Result struct {
-	Job   Job
-	Error error
-	Value string
-}
-
-// manages a pool of workers
-type WorkerPool struct {
-	ctx        context.Context
-	cancel     context.CancelFunc
-	wg         sync.WaitGroup
-	numWorkers int
-	jobs       chan Job
-	results    chan Result
-}
-
-// creates a new Worker Pool
-func NewWorkerPool(numWorkers, jobQueueSize int) *WorkerPool {
-	ctx, cancel := context.WithCancel(context.Background())
-
-	return &WorkerPool{
-		ctx:        ctx,
-		cancel:     cancel,
-		numWorkers: numWorkers,
-		jobs:       make(chan Job, jobQueueSize),
-		results:    make(chan Result, jobQueueSize),
-	}
-}
-
-// calls the worker method which are the workers of the worker pool using a go routine
-func (wp *WorkerPool) Start() {
-	fmt.Printf("Starting %d Workers...\n", wp.numWorkers)
-
-	for workerId := 1; workerId <= wp.numWorkers; workerId++ {
-		wp.wg.Add(1)
-		go wp.Worker(workerId)
-	}
-}
-
-func (wp *WorkerPool) Worker(workerId int) {
-	defer wp.wg.Done()
-
-	fmt.Printf("Worker %d started\n", workerId)
-
-	for {
-		select {
-		case job, ok := <-wp.jobs:
-			if !ok {
-				fmt.Printf("Worker %d: jobs channel closed\n", workerId)
-				return
-			}
-
-			fmt.Printf("Worker %d processing job %d\n", workerId, job.ID)
-			result := wp.ProcessJob(job)
-
-			select {
-			case wp.results <- result:
-			case <-wp.ctx.Done():
-				fmt.Printf("Worker %d: context cancelled\n", workerId)
-			}
-		case <-wp.ctx.Done():
-			fmt.Printf("Worker %d: shutting down\n", workerId)
-			return
-		}
-	}
-}
-
-func (wp *WorkerPool) ProcessJob(job Job) Result {
-	// simulate work
-	time.Sleep(500 * time.Millisecond)
-
-	return Result{
-		Job:   job,
-		Value: fmt.Sprintf("Processed: %s", job.Data),
-		Error: nil,
-	}
-}
-
-func (wp *WorkerPool) Submit(job Job) error {
+// ProcessJob is the handler used by the example, it simulates work
+func ProcessJob(ctx context.Context, job Job) (string, error) {
 	select {
-	case wp.jobs <- job:
-		return nil
-	case <-wp.ctx.Done():
-		return fmt.Errorf("Worker pool is shutting down")
+	case <-time.After(500 * time.Millisecond):
+		return fmt.Sprintf("Processed: %s", job.Data), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
 	}
 }
 
-func (wp *WorkerPool) Results() <-chan Result {
-	return wp.results
-}
-
-func (wp *WorkerPool) Stop() {
-	fmt.Println("Stopping Worker Pool...")
-	wp.cancel()       // send the cancel signal to the rest of the workers, so that the workers wont send to result channel anymore as it is close and would cause panic
-	close(wp.jobs)    // close jobs channel, no more jobs should be sent to the channel
-	wp.wg.Wait()      // wait for workers already processing jobs to finish
-	close(wp.results) // close results after worker with already processing hobs finish work
-	fmt.Println("Worker Pool Stopped")
-}
-
 func main() {
 	fmt.Println("========= Production Ready Worker Pool =========")
 
 	numworkers := 3 // no. of workers
 	numJobs := 10   // no. of Jobs
+	// log progress to stdout and keep metrics to print at the end
+	metrics := NewMetrics("workerpool")
+
 	// create the worker pool
-	pool := NewWorkerPool(numworkers, numJobs)
+	pool := NewPool(PoolConfig{
+		Instrumentation: Instruments(LogInstrumentation{}, metrics),
+		Workers:         numworkers,
+		QueueSize:       numJobs,
+		JobTimeout:      time.Second,
+		Retry: RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: 100 * time.Millisecond,
+			MaxBackoff:     time.Second,
+			Jitter:         0.5,
+		},
+		Breaker: &BreakerConfig{
+			MaxConsecutivePanics: 3,
+			Cooldown:             2 * time.Second,
+		},
+		Autoscale: &AutoscaleConfig{
+			Min:      2,
+			Max:      6,
+			Interval: 500 * time.Millisecond,
+		},
+	}, ProcessJob)
 
 	// start the worker pool
 	pool.Start()
 
+	// a heartbeat job every second, skipped while the previous one is running
+	scheduler := NewScheduler(pool)
+	scheduler.Add(Every(time.Second), Job{ID: 0, Data: "Heartbeat"}, ScheduleOptions{Overlap: OverlapSkip})
+	scheduler.Start()
+
 	go func() {
 		for i := 1; i <= numJobs; i++ {
 			job := Job{
@@ -132,24 +69,49 @@ func main() {
 				Data: fmt.Sprintf("Task-%d", i),
 			}
 
-			if err := pool.Submit(job); err != nil {
+			// jobs are spread over two tenants, every fifth one jumps the queue
+			opts := JobOptions{Tenant: fmt.Sprintf("tenant-%d", i%2)}
+			if i%5 == 0 {
+				opts.Priority = 1
+			}
+
+			if err := pool.SubmitWith(job, opts); err != nil {
 				fmt.Printf("Failed to submit job: %v\n", err)
 				return
 			}
 		}
 
-		time.Sleep(2 * time.Second)
-		pool.Stop()
+		// keep the heartbeat going until the queue has drained
+		for pool.QueueDepth() > 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		scheduler.Stop()
+
+		// stop accepting jobs and give the queued ones 5 seconds to finish
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		report, err := pool.Shutdown(ctx)
+		if err != nil {
+			fmt.Printf("Shutdown forced: %v\n", err)
+		}
+		fmt.Printf("Completed: %d, Cancelled: %d, Never started: %d\n", report.Completed, report.Cancelled, len(report.NeverStarted))
 	}()
 
 	// Collect results
-	for results := range pool.Results() {
-		if results.Error != nil {
-			fmt.Printf("Job %d failed %v\n", results.Job.ID, results.Error)
+	for result := range pool.Results() {
+		if result.Error != nil {
+			fmt.Printf("Job %d failed on worker %d after %d attempts: %v\n", result.Input.ID, result.WorkerID, result.Attempts, result.Error)
 		} else {
-			fmt.Printf("Job %d completed: %s\n", results.Job.ID, results.Value)
+			fmt.Printf("Job %d completed on worker %d in %v (queued %v): %s\n",
+				result.Input.ID, result.WorkerID, result.Duration.Round(time.Millisecond), result.QueueWait.Round(time.Millisecond), result.Value)
 		}
 	}
 
+	for _, letter := range pool.DeadLetters().List() {
+		fmt.Printf("Dead letter: job %d after %d attempts: %v\n", letter.Input.ID, letter.Attempts, letter.Err)
+	}
+
 	fmt.Println("All jobs processed")
+	metrics.WritePrometheus(os.Stdout)
 }
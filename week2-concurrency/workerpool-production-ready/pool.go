@@ -0,0 +1,609 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found in dead letter queue")
+	ErrPoolClosed  = errors.New("worker pool is shutting down")
+	ErrQueueFull   = errors.New("job queue is full")
+	// passed to OnDone of a job dropped by a shutdown before it started
+	ErrJobCancelled = errors.New("job cancelled before it started")
+)
+
+// Handler processes a single job, ctx is cancelled when the pool stops or the
+// job times out
+type Handler[In, Out any] func(ctx context.Context, in In) (Out, error)
+
+type PoolConfig struct {
+	Workers int
+	// number of jobs that can wait for a worker, at least 1
+	QueueSize int
+	// adjusts the number of workers to the load, nil keeps Workers fixed
+	Autoscale *AutoscaleConfig
+	// upper bound for a single attempt, zero means no timeout
+	JobTimeout time.Duration
+	// default retry policy for jobs submitted with Submit
+	Retry RetryPolicy
+	// pauses the pool when jobs keep panicking, nil never pauses
+	Breaker *BreakerConfig
+	// receives logs, metrics and traces, nil is NopInstrumentation
+	Instrumentation Instrumentation
+	// share of the workers each tenant gets when several are queued at the
+	// same priority, tenants not listed have a weight of 1
+	TenantWeights map[string]int
+}
+
+// JobOptions controls how a single job is scheduled
+type JobOptions struct {
+	// jobs with a higher priority always run before lower ones
+	Priority int
+	// jobs of different tenants at the same priority are interleaved by
+	// weighted round-robin, the empty string is a tenant like any other
+	Tenant string
+	// overrides the pool's default retry policy when set
+	Retry *RetryPolicy
+	// identifies the job for deduplication, a durable pool rejects a second
+	// job with the same key, handlers can read it with JobKey
+	Key string
+	// called with the final error, or nil, once the job has finished, or with
+	// ErrJobCancelled when a shutdown drops it from the queue, it isn't
+	// persisted by a durable pool
+	OnDone func(err error)
+}
+
+// represents the output of processing a job
+type Result[In, Out any] struct {
+	JobID    uint64
+	Input    In
+	Value    Out
+	Error    error
+	WorkerID int
+	Attempts int
+	// the job ran out of attempts and was moved to the dead letter queue
+	DeadLettered bool
+	// how long the job sat in the queue before a worker picked it up
+	QueueWait time.Duration
+	StartedAt time.Time
+	// total time across all attempts, including backoff
+	Duration time.Duration
+	// the job was already running when a durable pool went down, so the
+	// handler may have seen it before
+	Redelivered bool
+}
+
+// ShutdownReport accounts for every job the pool has seen once it has stopped
+type ShutdownReport[In any] struct {
+	// jobs whose handler ran to completion, successfully or not
+	Completed int
+	// jobs that were running when the pool was force cancelled
+	Cancelled int
+	// jobs still queued when the pool was force cancelled
+	NeverStarted []In
+	// the shutdown deadline passed before the queue was drained
+	Forced bool
+}
+
+// task is a job as it travels through the queue
+type task[In any] struct {
+	id          uint64
+	input       In
+	policy      RetryPolicy
+	priority    int
+	tenant      string
+	key         string
+	onDone      func(err error)
+	redelivered bool
+	submittedAt time.Time
+}
+
+func (t task[In]) info(workerId int) JobInfo {
+	return JobInfo{ID: t.id, Priority: t.priority, Tenant: t.tenant, WorkerID: workerId}
+}
+
+func (t task[In]) record() JobRecord[In] {
+	return JobRecord[In]{
+		ID:       t.id,
+		Key:      t.key,
+		Input:    t.input,
+		Priority: t.priority,
+		Tenant:   t.tenant,
+	}
+}
+
+// Pool runs a Handler over submitted jobs with a fixed number of workers
+type Pool[In, Out any] struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+	cfg         PoolConfig
+	handler     Handler[In, Out]
+	clock       Clock
+	random      func() float64 // jitter source
+	nextID      atomic.Uint64
+	queue       *fairQueue[In]
+	slots       chan struct{} // one per job waiting for a worker, bounds the queue to QueueSize
+	jobs        chan task[In] // hands jobs from the dispatcher to the workers
+	results     chan Result[In, Out]
+	deadLetters *DeadLetterQueue[In]
+	store       JobStore[In]
+
+	mu           sync.Mutex
+	stopping     chan struct{} // closed when Shutdown starts
+	shuttingDown atomic.Bool
+
+	// workersMu guards the worker set and starting workers against Shutdown
+	workersMu    sync.Mutex
+	workers      map[int]chan struct{} // worker id -> quit signal
+	lastWorkerID int
+	load         loadStats
+	completed    atomic.Int64
+	cancelled    atomic.Int64
+	restarts     atomic.Int64
+	breaker      *breaker
+	instr        Instrumentation
+	neverStarted []In // jobs picked up after a forced shutdown, guarded by mu
+}
+
+// creates a new Pool running handler for every submitted job
+func NewPool[In, Out any](cfg PoolConfig, handler Handler[In, Out]) *Pool[In, Out] {
+	return newPool(cfg, handler, realClock{})
+}
+
+func newPool[In, Out any](cfg PoolConfig, handler Handler[In, Out], clock Clock) *Pool[In, Out] {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	instr := cfg.Instrumentation
+	if instr == nil {
+		instr = NopInstrumentation{}
+	}
+
+	p := &Pool[In, Out]{
+		instr:       instr,
+		breaker:     newBreaker(ctx, cfg.Breaker, clock, instr.Logf),
+		ctx:         ctx,
+		cancel:      cancel,
+		cfg:         cfg,
+		handler:     handler,
+		clock:       clock,
+		random:      rand.Float64,
+		queue:       newFairQueue[In](cfg.TenantWeights),
+		slots:       make(chan struct{}, max(cfg.QueueSize, 1)),
+		jobs:        make(chan task[In]),
+		results:     make(chan Result[In, Out], cfg.QueueSize),
+		deadLetters: NewDeadLetterQueue[In](),
+		store:       nopStore[In]{},
+		stopping:    make(chan struct{}),
+		workers:     make(map[int]chan struct{}),
+	}
+	if w, ok := instr.(queueWatcher); ok {
+		w.watchQueue(p.QueueDepth)
+	}
+	return p
+}
+
+// NewDurablePool creates a Pool that records every job in store and queues
+// the jobs a previous run left unfinished
+//
+// Jobs that were running when the previous run went down are delivered
+// again with Result.Redelivered set. Recovered jobs use the pool's default
+// retry policy since policies aren't persisted.
+func NewDurablePool[In, Out any](cfg PoolConfig, handler Handler[In, Out], store JobStore[In]) (*Pool[In, Out], error) {
+	return newDurablePool(cfg, handler, store, realClock{})
+}
+
+func newDurablePool[In, Out any](cfg PoolConfig, handler Handler[In, Out], store JobStore[In], clock Clock) (*Pool[In, Out], error) {
+	recovery, err := store.Recover()
+	if err != nil {
+		return nil, err
+	}
+
+	p := newPool(cfg, handler, clock)
+	p.store = store
+	p.nextID.Store(recovery.LastID)
+
+	// recovered jobs must fit even if there are more than QueueSize of them
+	p.slots = make(chan struct{}, max(cap(p.slots), len(recovery.Pending)))
+	for _, rec := range recovery.Pending {
+		p.slots <- struct{}{}
+		p.queue.push(task[In]{
+			id:          rec.ID,
+			input:       rec.Input,
+			policy:      cfg.Retry,
+			priority:    rec.Priority,
+			tenant:      rec.Tenant,
+			key:         rec.Key,
+			redelivered: rec.Started,
+			submittedAt: clock.Now(),
+		})
+	}
+	if n := len(recovery.Pending); n > 0 {
+		p.instr.Logf("Recovered %d unfinished jobs", n)
+	}
+
+	return p, nil
+}
+
+// starts the workers, and the autoscaler if one is configured
+func (p *Pool[In, Out]) Start() {
+	p.instr.Logf("Starting %d Workers...", p.cfg.Workers)
+
+	p.wg.Add(1)
+	go p.dispatch()
+
+	workers := p.cfg.Workers
+	if as := p.cfg.Autoscale; as != nil {
+		workers = min(max(workers, as.Min), as.Max)
+		go p.autoscale(*as)
+	}
+	p.Resize(workers)
+}
+
+// dispatch feeds the workers from the queue in priority and fairness order
+// and closes jobs once the queue is closed and drained
+func (p *Pool[In, Out]) dispatch() {
+	defer p.wg.Done()
+	defer close(p.jobs)
+
+	for {
+		t, ok := p.queue.pop(p.ctx)
+		if !ok {
+			return
+		}
+		// a tripped breaker holds the job back, it still counts as queued
+		if !p.breaker.admit(p.ctx, p.stopping) {
+			p.neverStart(t)
+			return
+		}
+
+		select {
+		case p.jobs <- t:
+			// the slot is held until a worker has the job so it counts as queued
+			<-p.slots
+		case <-p.ctx.Done():
+			p.neverStart(t)
+			return
+		}
+	}
+}
+
+// neverStart accounts for a job a shutdown dropped, its OnDone still hears
+// about it
+func (p *Pool[In, Out]) neverStart(t task[In]) {
+	p.mu.Lock()
+	p.neverStarted = append(p.neverStarted, t.input)
+	p.mu.Unlock()
+
+	if err := callOnDone(t.onDone, ErrJobCancelled); err != nil {
+		p.instr.Logf("OnDone of job %d panicked: %v", t.id, err)
+	}
+}
+
+// worker runs jobs until it is retired or the pool stops, it reports true
+// if it stopped because a job panicked
+func (p *Pool[In, Out]) worker(workerId int, quit <-chan struct{}) bool {
+	p.instr.Logf("Worker %d started", workerId)
+
+	for {
+		// a retired worker must not pick up another job
+		select {
+		case <-quit:
+			p.instr.Logf("Worker %d: stopped by resize", workerId)
+			return false
+		default:
+		}
+
+		select {
+		case t, ok := <-p.jobs:
+			if !ok {
+				p.instr.Logf("Worker %d: jobs channel closed", workerId)
+				return false
+			}
+
+			if p.ctx.Err() != nil {
+				// force cancelled before this job got going
+				p.neverStart(t)
+				continue
+			}
+
+			p.journal(workerId, "start", t.id, p.store.Started)
+			result := p.process(workerId, t)
+			if p.ctx.Err() != nil {
+				// left as started so a durable pool runs it again
+				p.cancelled.Add(1)
+			} else {
+				p.journal(workerId, "finish", t.id, p.store.Finished)
+				p.completed.Add(1)
+			}
+			p.load.recordCompleted(result.Duration)
+			callbackErr := callOnDone(t.onDone, result.Error)
+
+			p.deliver(workerId, result)
+
+			if IsPanic(result.Error) {
+				p.instr.Logf("Worker %d: job %d panicked: %v", workerId, result.JobID, result.Error)
+				return true
+			}
+			if callbackErr != nil {
+				p.instr.Logf("Worker %d: OnDone of job %d panicked: %v", workerId, result.JobID, callbackErr)
+				return true
+			}
+		case <-quit:
+			p.instr.Logf("Worker %d: stopped by resize", workerId)
+			return false
+		case <-p.ctx.Done():
+			p.instr.Logf("Worker %d: shutting down", workerId)
+			return false
+		}
+	}
+}
+
+// journal records a state change of a durable pool, a failing store only
+// costs durability so the job carries on
+func (p *Pool[In, Out]) journal(workerId int, op string, id uint64, record func(id uint64) error) {
+	if err := record(id); err != nil {
+		p.instr.Logf("Worker %d: failed to record %s of job %d: %v", workerId, op, id, err)
+	}
+}
+
+// deliver hands a result to the consumer, after a forced shutdown it is only
+// kept if there is room left in the results buffer
+func (p *Pool[In, Out]) deliver(workerId int, result Result[In, Out]) {
+	select {
+	case p.results <- result:
+		return
+	default:
+	}
+
+	select {
+	case p.results <- result:
+	case <-p.ctx.Done():
+		p.instr.Logf("Worker %d: context cancelled, dropping result of job %d", workerId, result.JobID)
+	}
+}
+
+// process runs the handler until it succeeds or the retry policy gives up,
+// backing off between attempts
+func (p *Pool[In, Out]) process(workerId int, t task[In]) (result Result[In, Out]) {
+	start := p.clock.Now()
+	result = Result[In, Out]{
+		JobID:     t.id,
+		Input:     t.input,
+		WorkerID:  workerId,
+		QueueWait: start.Sub(t.submittedAt),
+		StartedAt: start,
+
+		Redelivered: t.redelivered,
+	}
+
+	ctx := p.instr.JobStarted(p.ctx, t.info(workerId), result.QueueWait, len(p.slots))
+	defer func() {
+		p.instr.JobFinished(ctx, t.info(workerId), result.Attempts, result.Duration, result.Error)
+	}()
+
+	for attempt := 1; ; attempt++ {
+		result.Attempts = attempt
+		result.Value, result.Error = p.attempt(ctx, t)
+		if result.Error == nil || !t.policy.shouldRetry(attempt, result.Error) {
+			break
+		}
+
+		select {
+		case <-p.clock.After(t.policy.backoff(attempt, p.random)):
+		case <-p.ctx.Done():
+			// shutting down, report the last error without dead lettering
+			result.Duration = p.clock.Now().Sub(start)
+			return result
+		}
+	}
+	result.Duration = p.clock.Now().Sub(start)
+
+	// jobs cut short by a shutdown didn't really fail
+	if result.Error != nil && p.ctx.Err() == nil {
+		p.deadLetters.add(DeadLetter[In]{
+			JobID:    t.id,
+			Input:    t.input,
+			Err:      result.Error,
+			Attempts: result.Attempts,
+			FailedAt: p.clock.Now(),
+			task:     t,
+		})
+		result.DeadLettered = true
+	}
+
+	return result
+}
+
+// attempt runs the handler once with a context derived from the job's context
+func (p *Pool[In, Out]) attempt(parent context.Context, t task[In]) (Out, error) {
+	ctx, cancel := p.jobContext(parent)
+	defer cancel()
+
+	if t.key != "" {
+		ctx = context.WithValue(ctx, jobKeyCtx{}, t.key)
+	}
+	out, err := safeCall(ctx, p.handler, t.input)
+	p.breaker.record(IsPanic(err))
+	return out, err
+}
+
+func (p *Pool[In, Out]) jobContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if p.cfg.JobTimeout > 0 {
+		return context.WithTimeout(parent, p.cfg.JobTimeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// queues a job using the pool's default options, blocking while the queue
+// is full
+func (p *Pool[In, Out]) Submit(in In) error {
+	return p.SubmitWith(in, JobOptions{})
+}
+
+// queues a job with its own retry policy
+func (p *Pool[In, Out]) SubmitWithRetry(in In, policy RetryPolicy) error {
+	return p.SubmitWith(in, JobOptions{Retry: &policy})
+}
+
+// queues a job with the given priority, tenant and retry policy, blocking
+// while the queue is full
+func (p *Pool[In, Out]) SubmitWith(in In, opts JobOptions) error {
+	return p.enqueue(p.newTask(in, opts), true)
+}
+
+// queues a job like Submit but returns ErrQueueFull instead of blocking
+func (p *Pool[In, Out]) TrySubmit(in In) error {
+	return p.TrySubmitWith(in, JobOptions{})
+}
+
+// queues a job like SubmitWith but returns ErrQueueFull instead of blocking
+func (p *Pool[In, Out]) TrySubmitWith(in In, opts JobOptions) error {
+	return p.enqueue(p.newTask(in, opts), false)
+}
+
+func (p *Pool[In, Out]) newTask(in In, opts JobOptions) task[In] {
+	policy := p.cfg.Retry
+	if opts.Retry != nil {
+		policy = *opts.Retry
+	}
+
+	return task[In]{
+		id:       p.nextID.Add(1),
+		input:    in,
+		policy:   policy,
+		priority: opts.Priority,
+		tenant:   opts.Tenant,
+		key:      opts.Key,
+		onDone:   opts.OnDone,
+	}
+}
+
+func (p *Pool[In, Out]) enqueue(t task[In], block bool) error {
+	select {
+	case <-p.stopping:
+		return ErrPoolClosed
+	default:
+	}
+
+	// take a slot in the queue first, so a full queue pushes back on submitters
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		if !block {
+			return ErrQueueFull
+		}
+		select {
+		case p.slots <- struct{}{}:
+		case <-p.stopping:
+			return ErrPoolClosed
+		}
+	}
+
+	// a durable pool persists the job before it can be picked up
+	if err := p.store.Submitted(t.record()); err != nil {
+		<-p.slots
+		return err
+	}
+
+	t.submittedAt = p.clock.Now()
+	if !p.queue.push(t) {
+		<-p.slots
+		p.store.Discard(t.id)
+		return ErrPoolClosed
+	}
+	p.load.recordSubmitted()
+	p.instr.JobSubmitted(t.info(0), len(p.slots))
+	return nil
+}
+
+// DeadLetters returns the jobs that failed for good
+func (p *Pool[In, Out]) DeadLetters() *DeadLetterQueue[In] {
+	return p.deadLetters
+}
+
+// moves a job from the dead letter queue back onto the pool with a fresh
+// set of attempts
+func (p *Pool[In, Out]) Resubmit(jobID uint64) error {
+	letter, ok := p.deadLetters.take(jobID)
+	if !ok {
+		return ErrJobNotFound
+	}
+
+	t := letter.task
+	t.redelivered = false
+	err := p.enqueue(t, true)
+	if err != nil {
+		// keep it so it can be resubmitted later
+		p.deadLetters.add(letter)
+	}
+	return err
+}
+
+func (p *Pool[In, Out]) Results() <-chan Result[In, Out] {
+	return p.results
+}
+
+// Shutdown stops accepting jobs and lets the workers drain the queue
+//
+// Once ctx is done the remaining work is force cancelled: running jobs see
+// their context cancelled and queued jobs are never started. The results
+// channel is closed before Shutdown returns. The returned error is ctx.Err()
+// if the shutdown was forced.
+func (p *Pool[In, Out]) Shutdown(ctx context.Context) (ShutdownReport[In], error) {
+	// no workers may be added once we start waiting for them
+	p.workersMu.Lock()
+	if !p.shuttingDown.CompareAndSwap(false, true) {
+		p.workersMu.Unlock()
+		return ShutdownReport[In]{}, ErrPoolClosed
+	}
+	p.workersMu.Unlock()
+
+	p.instr.Logf("Stopping Worker Pool...")
+	close(p.stopping) // wake up submitters blocked on a full queue
+
+	p.queue.close() // the dispatcher, and then the workers, exit once the queue is empty
+
+	drained := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		p.instr.Logf("Shutdown deadline reached, cancelling remaining jobs")
+		p.cancel()
+		<-drained
+	}
+	p.cancel()
+
+	for _, t := range p.queue.drain() {
+		p.neverStart(t)
+	}
+	report := ShutdownReport[In]{
+		Completed:    int(p.completed.Load()),
+		Cancelled:    int(p.cancelled.Load()),
+		NeverStarted: p.neverStarted,
+		Forced:       err != nil,
+	}
+
+	close(p.results)
+	p.instr.Logf("Worker Pool Stopped")
+	return report, err
+}
+
+// Stop cancels running jobs straight away and drops everything still queued
+func (p *Pool[In, Out]) Stop() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.Shutdown(ctx)
+}
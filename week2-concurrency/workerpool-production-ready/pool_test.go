@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collect reads n results from the pool
+func collect[In, Out any](t *testing.T, p *Pool[In, Out], n int) []Result[In, Out] {
+	t.Helper()
+
+	results := make([]Result[In, Out], 0, n)
+	timeout := time.After(5 * time.Second)
+	for len(results) < n {
+		select {
+		case r := <-p.Results():
+			results = append(results, r)
+		case <-timeout:
+			t.Fatalf("got %d of %d results", len(results), n)
+		}
+	}
+	return results
+}
+
+func TestPool_ProcessesJobs(t *testing.T) {
+	pool := NewPool(PoolConfig{Workers: 3, QueueSize: 10}, func(ctx context.Context, n int) (string, error) {
+		time.Sleep(time.Millisecond)
+		return strconv.Itoa(n * 2), nil
+	})
+	pool.Start()
+
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, pool.Submit(i))
+	}
+
+	seen := make(map[int]string)
+	for _, r := range collect(t, pool, 10) {
+		require.NoError(t, r.Error)
+		assert.GreaterOrEqual(t, r.WorkerID, 1)
+		assert.LessOrEqual(t, r.WorkerID, 3)
+		assert.GreaterOrEqual(t, r.Duration, time.Millisecond)
+		assert.GreaterOrEqual(t, r.QueueWait, time.Duration(0))
+		assert.False(t, r.StartedAt.IsZero())
+		seen[r.Input] = r.Value
+	}
+	pool.Stop()
+
+	for i := 1; i <= 10; i++ {
+		assert.Equal(t, strconv.Itoa(i*2), seen[i])
+	}
+}
+
+func TestPool_HandlerError(t *testing.T) {
+	errBoom := errors.New("boom")
+	pool := NewPool(PoolConfig{Workers: 1, QueueSize: 1}, func(ctx context.Context, s string) (int, error) {
+		return 0, fmt.Errorf("processing %s: %w", s, errBoom)
+	})
+	pool.Start()
+	defer pool.Stop()
+
+	require.NoError(t, pool.Submit("job"))
+	r := collect(t, pool, 1)[0]
+
+	assert.ErrorIs(t, r.Error, errBoom)
+	assert.Equal(t, "job", r.Input)
+	assert.Equal(t, 1, r.WorkerID)
+}
+
+func TestPool_JobTimeout(t *testing.T) {
+	pool := NewPool(PoolConfig{Workers: 1, QueueSize: 1, JobTimeout: 10 * time.Millisecond}, func(ctx context.Context, _ int) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	pool.Start()
+	defer pool.Stop()
+
+	require.NoError(t, pool.Submit(1))
+	r := collect(t, pool, 1)[0]
+
+	assert.ErrorIs(t, r.Error, context.DeadlineExceeded)
+	assert.GreaterOrEqual(t, r.Duration, 10*time.Millisecond)
+}
+
+func TestPool_StopCancelsJobContext(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan error, 1)
+	pool := NewPool(PoolConfig{Workers: 1, QueueSize: 1}, func(ctx context.Context, _ int) (int, error) {
+		close(started)
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return 0, ctx.Err()
+	})
+	pool.Start()
+
+	require.NoError(t, pool.Submit(1))
+	<-started
+	pool.Stop()
+
+	assert.ErrorIs(t, <-cancelled, context.Canceled)
+	assert.Error(t, pool.ctx.Err())
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets are the histogram upper bounds in seconds, the same as the
+// Prometheus client's defaults
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics is an Instrumentation that keeps counters, gauges and histograms of
+// the pool and exports them in the Prometheus text format
+//
+// The queue depth is read from the pool when scraped, so a Metrics should
+// instrument a single pool.
+type Metrics struct {
+	NopInstrumentation
+
+	namespace  string
+	submitted  atomic.Int64
+	completed  atomic.Int64
+	failed     atomic.Int64
+	cancelled  atomic.Int64
+	queueDepth atomic.Int64 // last depth an event saw, until the pool is known
+	queue      atomic.Pointer[func() int]
+	inProgress atomic.Int64
+	queueWait  *histogram
+	latency    *histogram
+}
+
+var _ Instrumentation = (*Metrics)(nil)
+
+// creates Metrics whose names start with namespace, e.g. "workerpool"
+func NewMetrics(namespace string) *Metrics {
+	return &Metrics{
+		namespace: namespace,
+		queueWait: newHistogram(DefaultBuckets),
+		latency:   newHistogram(DefaultBuckets),
+	}
+}
+
+func (m *Metrics) JobSubmitted(_ JobInfo, queueDepth int) {
+	m.submitted.Add(1)
+	m.queueDepth.Store(int64(queueDepth))
+}
+
+func (m *Metrics) JobStarted(ctx context.Context, _ JobInfo, queueWait time.Duration, queueDepth int) context.Context {
+	m.queueDepth.Store(int64(queueDepth))
+	m.inProgress.Add(1)
+	m.queueWait.observe(queueWait.Seconds())
+	return ctx
+}
+
+func (m *Metrics) JobFinished(ctx context.Context, _ JobInfo, _ int, latency time.Duration, err error) {
+	m.inProgress.Add(-1)
+	m.latency.observe(latency.Seconds())
+	switch {
+	case err == nil:
+		m.completed.Add(1)
+	case ctx.Err() != nil:
+		// a forced shutdown, not the job's fault
+		m.cancelled.Add(1)
+	default:
+		m.failed.Add(1)
+	}
+}
+
+func (m *Metrics) watchQueue(depth func() int) {
+	m.queue.Store(&depth)
+}
+
+// depth returns the queue depth, a job leaves the queue without an event when
+// it is dropped or its submission rejected
+func (m *Metrics) depth() int64 {
+	if depth := m.queue.Load(); depth != nil {
+		return int64((*depth)())
+	}
+	return m.queueDepth.Load()
+}
+
+// WritePrometheus writes every metric in the Prometheus text exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	var buf bytes.Buffer
+
+	m.writeMetric(&buf, "jobs_submitted_total", "counter", "Jobs accepted onto the queue.", m.submitted.Load())
+	m.writeMetric(&buf, "jobs_completed_total", "counter", "Jobs that finished successfully.", m.completed.Load())
+	m.writeMetric(&buf, "jobs_failed_total", "counter", "Jobs that finished with an error after all attempts.", m.failed.Load())
+	m.writeMetric(&buf, "jobs_cancelled_total", "counter", "Jobs cut short by a forced shutdown.", m.cancelled.Load())
+	m.writeMetric(&buf, "queue_depth", "gauge", "Jobs waiting for a worker.", m.depth())
+	m.writeMetric(&buf, "jobs_in_progress", "gauge", "Jobs being processed by a worker.", m.inProgress.Load())
+	m.queueWait.write(&buf, m.name("job_queue_wait_seconds"), "Time jobs spent in the queue before a worker picked them up.")
+	m.latency.write(&buf, m.name("job_duration_seconds"), "Time spent processing a job across all attempts.")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WritePrometheus(w)
+}
+
+func (m *Metrics) name(metric string) string {
+	if m.namespace == "" {
+		return metric
+	}
+	return m.namespace + "_" + metric
+}
+
+func (m *Metrics) writeMetric(buf *bytes.Buffer, metric, kind, help string, value int64) {
+	name := m.name(metric)
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, kind)
+	fmt.Fprintf(buf, "%s %d\n", name, value)
+}
+
+// histogram counts observations into cumulative buckets
+type histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64 // observations <= bounds[i], not cumulative
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds:  bounds,
+		buckets: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.buckets[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(buf *bytes.Buffer, name, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s histogram\n", name)
+
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.buckets[i]
+		fmt.Fprintf(buf, "%s_bucket{le=%q} %d\n", name, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(buf, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(buf, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(buf, "%s_count %d\n", name, h.count)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// fairQueue orders queued jobs by priority and, within a priority, shares
+// the workers between tenants with weighted round-robin
+//
+// Higher priorities always go first. Inside a priority level each tenant has
+// its own FIFO and gets up to its weight in jobs before the next tenant's turn,
+// so one tenant flooding the pool can't starve the others.
+type fairQueue[In any] struct {
+	mu      sync.Mutex
+	weights map[string]int
+	levels  map[int]*priorityLevel[In]
+	size    int
+	closed  bool
+	ready   chan struct{} // signalled when a job is pushed or the queue closes
+}
+
+type priorityLevel[In any] struct {
+	tenants map[string][]task[In]
+	order   []string // tenants with queued jobs, in round-robin order
+	cursor  int      // whose turn it is
+	credits int      // jobs the tenant at cursor may still take this turn
+}
+
+func newFairQueue[In any](weights map[string]int) *fairQueue[In] {
+	return &fairQueue[In]{
+		weights: weights,
+		levels:  make(map[int]*priorityLevel[In]),
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+func (q *fairQueue[In]) weight(tenant string) int {
+	if w := q.weights[tenant]; w > 0 {
+		return w
+	}
+	return 1
+}
+
+func (q *fairQueue[In]) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// push adds a job, it reports false once the queue has been closed
+func (q *fairQueue[In]) push(t task[In]) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false
+	}
+
+	level, ok := q.levels[t.priority]
+	if !ok {
+		level = &priorityLevel[In]{tenants: make(map[string][]task[In])}
+		q.levels[t.priority] = level
+	}
+	if _, ok := level.tenants[t.tenant]; !ok {
+		// a tenant with nothing queued joins at the back of the round
+		level.order = append(level.order, t.tenant)
+	}
+	level.tenants[t.tenant] = append(level.tenants[t.tenant], t)
+	q.size++
+
+	q.signal()
+	return true
+}
+
+// pop blocks until a job is available, it reports false once the queue is
+// closed and empty or ctx is done
+func (q *fairQueue[In]) pop(ctx context.Context) (task[In], bool) {
+	for {
+		q.mu.Lock()
+		if q.size > 0 {
+			t := q.next()
+			q.mu.Unlock()
+			return t, true
+		}
+		closed := q.closed
+		q.mu.Unlock()
+
+		if closed {
+			return task[In]{}, false
+		}
+
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return task[In]{}, false
+		}
+	}
+}
+
+// next removes the job that should run next
+// must be called with q.mu held and q.size > 0
+func (q *fairQueue[In]) next() task[In] {
+	priority, first := 0, true
+	for p := range q.levels {
+		if first || p > priority {
+			priority, first = p, false
+		}
+	}
+	level := q.levels[priority]
+
+	tenant := level.order[level.cursor]
+	if level.credits == 0 {
+		level.credits = q.weight(tenant)
+	}
+
+	jobs := level.tenants[tenant]
+	t := jobs[0]
+	level.credits--
+	q.size--
+
+	if len(jobs) == 1 {
+		// the cursor now points at the next tenant in the round
+		delete(level.tenants, tenant)
+		level.order = append(level.order[:level.cursor], level.order[level.cursor+1:]...)
+		level.credits = 0
+		if len(level.order) == 0 {
+			delete(q.levels, priority)
+			return t
+		}
+		level.cursor %= len(level.order)
+		return t
+	}
+
+	level.tenants[tenant] = jobs[1:]
+	if level.credits == 0 {
+		level.cursor = (level.cursor + 1) % len(level.order)
+	}
+	return t
+}
+
+// close stops the queue accepting jobs, queued jobs can still be popped
+func (q *fairQueue[In]) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.signal()
+}
+
+// drain removes and returns every queued job in the order they would have run
+func (q *fairQueue[In]) drain() []task[In] {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	tasks := make([]task[In], 0, q.size)
+	for q.size > 0 {
+		tasks = append(tasks, q.next())
+	}
+	return tasks
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// inputs returns the inputs of tasks in order
+func inputs(tasks []task[string]) []string {
+	out := make([]string, 0, len(tasks))
+	for _, t := range tasks {
+		out = append(out, t.input)
+	}
+	return out
+}
+
+func TestFairQueue_Order(t *testing.T) {
+	type job struct {
+		input    string
+		priority int
+		tenant   string
+	}
+
+	tests := []struct {
+		name    string
+		weights map[string]int
+		jobs    []job
+		want    []string
+	}{
+		{
+			name: "fifo within a tenant",
+			jobs: []job{{input: "1"}, {input: "2"}, {input: "3"}},
+			want: []string{"1", "2", "3"},
+		},
+		{
+			name: "higher priority first",
+			jobs: []job{
+				{input: "low", priority: -1},
+				{input: "normal"},
+				{input: "high-1", priority: 5},
+				{input: "high-2", priority: 5},
+			},
+			want: []string{"high-1", "high-2", "normal", "low"},
+		},
+		{
+			name: "tenants take turns",
+			jobs: []job{
+				{input: "a1", tenant: "a"}, {input: "a2", tenant: "a"}, {input: "a3", tenant: "a"},
+				{input: "b1", tenant: "b"},
+				{input: "c1", tenant: "c"}, {input: "c2", tenant: "c"},
+			},
+			want: []string{"a1", "b1", "c1", "a2", "c2", "a3"},
+		},
+		{
+			name:    "weighted round-robin",
+			weights: map[string]int{"a": 2},
+			jobs: []job{
+				{input: "a1", tenant: "a"}, {input: "a2", tenant: "a"}, {input: "a3", tenant: "a"},
+				{input: "a4", tenant: "a"}, {input: "a5", tenant: "a"},
+				{input: "b1", tenant: "b"}, {input: "b2", tenant: "b"}, {input: "b3", tenant: "b"},
+			},
+			want: []string{"a1", "a2", "b1", "a3", "a4", "b2", "a5", "b3"},
+		},
+		{
+			name: "priority beats fairness",
+			jobs: []job{
+				{input: "a1", tenant: "a"}, {input: "a2", tenant: "a"},
+				{input: "b1", tenant: "b", priority: 1}, {input: "b2", tenant: "b", priority: 1},
+			},
+			want: []string{"b1", "b2", "a1", "a2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newFairQueue[string](tt.weights)
+			for _, j := range tt.jobs {
+				require.True(t, q.push(task[string]{input: j.input, priority: j.priority, tenant: j.tenant}))
+			}
+			assert.Equal(t, tt.want, inputs(q.drain()))
+		})
+	}
+}
+
+func TestFairQueue_Close(t *testing.T) {
+	q := newFairQueue[string](nil)
+	require.True(t, q.push(task[string]{input: "queued"}))
+	q.close()
+
+	assert.False(t, q.push(task[string]{input: "late"}), "closed queue rejects jobs")
+
+	// queued jobs can still be taken, then pop reports the queue is done
+	got, ok := q.pop(context.Background())
+	require.True(t, ok)
+	assert.Equal(t, "queued", got.input)
+
+	_, ok = q.pop(context.Background())
+	assert.False(t, ok)
+}
+
+func TestFairQueue_PopWaitsForPush(t *testing.T) {
+	q := newFairQueue[string](nil)
+
+	popped := make(chan string)
+	go func() {
+		got, _ := q.pop(context.Background())
+		popped <- got.input
+	}()
+
+	q.push(task[string]{input: "late"})
+	assert.Equal(t, "late", <-popped)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, ok := q.pop(ctx)
+	assert.False(t, ok, "pop gives up once ctx is done")
+}
+
+func TestPool_RunsByPriority(t *testing.T) {
+	pool := NewPool(PoolConfig{Workers: 1, QueueSize: 10}, func(ctx context.Context, s string) (string, error) {
+		return s, nil
+	})
+
+	// queue everything before a worker can take any of it
+	require.NoError(t, pool.Submit("normal"))
+	require.NoError(t, pool.SubmitWith("low", JobOptions{Priority: -1}))
+	require.NoError(t, pool.SubmitWith("urgent", JobOptions{Priority: 10}))
+	require.NoError(t, pool.SubmitWith("high", JobOptions{Priority: 1}))
+	pool.Start()
+
+	var order []string
+	for _, r := range collect(t, pool, 4) {
+		order = append(order, r.Value)
+	}
+	pool.Stop()
+
+	assert.Equal(t, []string{"urgent", "high", "normal", "low"}, order)
+}
+
+func TestPool_SharesWorkersBetweenTenants(t *testing.T) {
+	pool := NewPool(PoolConfig{
+		Workers:       1,
+		QueueSize:     20,
+		TenantWeights: map[string]int{"paying": 2},
+	}, func(ctx context.Context, s string) (string, error) {
+		return s, nil
+	})
+
+	// a noisy tenant floods the queue before anyone else gets a job in
+	for i := 1; i <= 6; i++ {
+		require.NoError(t, pool.SubmitWith(fmt.Sprintf("noisy-%d", i), JobOptions{Tenant: "noisy"}))
+	}
+	for i := 1; i <= 4; i++ {
+		require.NoError(t, pool.SubmitWith(fmt.Sprintf("paying-%d", i), JobOptions{Tenant: "paying"}))
+	}
+	pool.Start()
+
+	var order []string
+	for _, r := range collect(t, pool, 10) {
+		order = append(order, r.Value)
+	}
+	pool.Stop()
+
+	assert.Equal(t, []string{
+		"noisy-1", "paying-1", "paying-2",
+		"noisy-2", "paying-3", "paying-4",
+		"noisy-3", "noisy-4", "noisy-5", "noisy-6",
+	}, order)
+}
+
+func TestPool_TrySubmit(t *testing.T) {
+	// not started, so nothing leaves the queue
+	pool := NewPool(PoolConfig{Workers: 1, QueueSize: 2}, func(ctx context.Context, n int) (int, error) {
+		return n, nil
+	})
+
+	require.NoError(t, pool.TrySubmit(1))
+	require.NoError(t, pool.TrySubmitWith(2, JobOptions{Priority: 1}))
+	assert.ErrorIs(t, pool.TrySubmit(3), ErrQueueFull)
+	assert.Equal(t, 2, pool.QueueDepth())
+
+	report, err := pool.Shutdown(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 1}, report.NeverStarted)
+
+	assert.ErrorIs(t, pool.TrySubmit(4), ErrPoolClosed)
+}
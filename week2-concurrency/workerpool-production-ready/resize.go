@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"slices"
+)
+
+var ErrInvalidPoolSize = errors.New("worker pool needs at least one worker")
+
+// Resize grows or shrinks the number of workers while the pool is running
+//
+// New workers start picking up jobs straight away. Removed workers finish
+// the job they are working on and deliver its result before exiting, so no
+// job is lost; queued jobs stay in the queue for the remaining workers.
+func (p *Pool[In, Out]) Resize(n int) error {
+	if n < 1 {
+		return ErrInvalidPoolSize
+	}
+
+	p.workersMu.Lock()
+	defer p.workersMu.Unlock()
+
+	if p.shuttingDown.Load() {
+		return ErrPoolClosed
+	}
+
+	for len(p.workers) < n {
+		p.lastWorkerID++
+		quit := make(chan struct{})
+		p.workers[p.lastWorkerID] = quit
+
+		p.wg.Add(1)
+		go p.supervise(p.lastWorkerID, quit)
+	}
+
+	if extra := len(p.workers) - n; extra > 0 {
+		// retire the newest workers first
+		ids := slices.Sorted(maps.Keys(p.workers))
+		for _, id := range ids[len(ids)-extra:] {
+			close(p.workers[id])
+			delete(p.workers, id)
+		}
+	}
+
+	return nil
+}
+
+// returns the number of workers the pool is currently sized for
+func (p *Pool[In, Out]) Size() int {
+	p.workersMu.Lock()
+	defer p.workersMu.Unlock()
+
+	return len(p.workers)
+}
+
+// returns the number of jobs waiting for a worker
+func (p *Pool[In, Out]) QueueDepth() int {
+	return len(p.slots)
+}
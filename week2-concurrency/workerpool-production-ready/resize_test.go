@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gatedHandler blocks every job until gate is closed and counts running jobs
+func gatedHandler(gate <-chan struct{}, running *atomic.Int32) Handler[int, int] {
+	return func(ctx context.Context, n int) (int, error) {
+		running.Add(1)
+		defer running.Add(-1)
+
+		select {
+		case <-gate:
+			return n, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+func TestPool_ResizeGrow(t *testing.T) {
+	gate := make(chan struct{})
+	var running atomic.Int32
+	pool := NewPool(PoolConfig{Workers: 1, QueueSize: 10}, gatedHandler(gate, &running))
+	pool.Start()
+	defer pool.Stop()
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, pool.Submit(i))
+	}
+	require.Eventually(t, func() bool { return running.Load() == 1 }, time.Second, time.Millisecond)
+
+	require.NoError(t, pool.Resize(3))
+	assert.Equal(t, 3, pool.Size())
+	require.Eventually(t, func() bool { return running.Load() == 3 }, time.Second, time.Millisecond)
+
+	close(gate)
+	assert.Len(t, collect(t, pool, 3), 3)
+}
+
+func TestPool_ResizeShrinkKeepsJobs(t *testing.T) {
+	gate := make(chan struct{})
+	var running atomic.Int32
+	pool := NewPool(PoolConfig{Workers: 4, QueueSize: 20}, gatedHandler(gate, &running))
+	pool.Start()
+	defer pool.Stop()
+
+	for i := 1; i <= 20; i++ {
+		require.NoError(t, pool.Submit(i))
+	}
+	require.Eventually(t, func() bool { return running.Load() == 4 }, time.Second, time.Millisecond)
+
+	// the retired workers finish what they are running before exiting
+	require.NoError(t, pool.Resize(1))
+	assert.Equal(t, 1, pool.Size())
+	close(gate)
+
+	seen := make(map[int]bool)
+	perWorker := make(map[int]int)
+	for _, r := range collect(t, pool, 20) {
+		require.NoError(t, r.Error)
+		seen[r.Input] = true
+		perWorker[r.WorkerID]++
+	}
+	assert.Len(t, seen, 20)
+	// once the in flight jobs are done only the oldest worker is left
+	assert.Equal(t, map[int]int{1: 17, 2: 1, 3: 1, 4: 1}, perWorker)
+}
+
+func TestPool_ResizeErrors(t *testing.T) {
+	pool := NewPool(PoolConfig{Workers: 1, QueueSize: 1}, func(ctx context.Context, n int) (int, error) {
+		return n, nil
+	})
+	pool.Start()
+
+	assert.ErrorIs(t, pool.Resize(0), ErrInvalidPoolSize)
+
+	pool.Stop()
+	assert.ErrorIs(t, pool.Resize(2), ErrPoolClosed)
+}
+
+func TestPool_EvaluateScaling(t *testing.T) {
+	clock := newFakeClock()
+	gate := make(chan struct{})
+	var running atomic.Int32
+
+	var decisions []ScalingDecision
+	cfg := AutoscaleConfig{
+		Min:      1,
+		Max:      10,
+		Interval: time.Second,
+		OnScale:  func(d ScalingDecision) { decisions = append(decisions, d) },
+	}
+
+	pool := newPool(PoolConfig{Workers: 2, QueueSize: 100}, gatedHandler(gate, &running), clock)
+	pool.Start()
+	defer func() {
+		close(gate)
+		pool.Stop()
+	}()
+
+	for i := 1; i <= 12; i++ {
+		require.NoError(t, pool.Submit(i))
+	}
+	waitForQueue := func(depth int) {
+		t.Helper()
+		require.Eventually(t, func() bool { return pool.QueueDepth() == depth }, time.Second, time.Millisecond)
+	}
+	waitForQueue(10)
+
+	// nothing has completed yet so there is no latency to go on
+	d := pool.evaluateScaling(cfg)
+	assert.Equal(t, 2, d.From)
+	assert.Equal(t, 3, d.To)
+	assert.Equal(t, 12.0, d.ArrivalRate)
+	waitForQueue(9)
+
+	// 40 jobs/s at 250ms keeps 10 workers busy, capped at Max
+	for range 40 {
+		pool.load.recordSubmitted()
+	}
+	pool.load.recordCompleted(250 * time.Millisecond)
+	d = pool.evaluateScaling(cfg)
+	assert.Equal(t, 3, d.From)
+	assert.Equal(t, 10, d.To)
+	assert.Equal(t, 250*time.Millisecond, d.AvgLatency)
+	waitForQueue(2)
+
+	// the load is gone, scale down one worker at a time
+	d = pool.evaluateScaling(cfg)
+	assert.Equal(t, 9, d.To)
+	d = pool.evaluateScaling(cfg)
+	assert.Equal(t, 8, d.To)
+
+	require.Len(t, decisions, 4)
+	assert.Equal(t, 8, pool.Size())
+	assert.NotEmpty(t, decisions[0].Reason)
+}
+
+func TestPool_AutoscalerRunsEveryInterval(t *testing.T) {
+	clock := newFakeClock()
+	gate := make(chan struct{})
+	var running atomic.Int32
+
+	decisions := make(chan ScalingDecision, 1)
+	pool := newPool(PoolConfig{
+		Workers:   1,
+		QueueSize: 10,
+		Autoscale: &AutoscaleConfig{
+			Min:      2,
+			Max:      4,
+			Interval: time.Minute,
+			OnScale:  func(d ScalingDecision) { decisions <- d },
+		},
+	}, gatedHandler(gate, &running), clock)
+	pool.Start()
+	defer func() {
+		close(gate)
+		pool.Stop()
+	}()
+
+	// Workers is raised to Min
+	assert.Equal(t, 2, pool.Size())
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, pool.Submit(i))
+	}
+	require.Eventually(t, func() bool { return pool.QueueDepth() == 3 }, time.Second, time.Millisecond)
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+
+	d := <-decisions
+	assert.Equal(t, 2, d.From)
+	assert.Equal(t, 3, d.To)
+	assert.Equal(t, clock.Now(), d.At)
+}
+
+func TestPool_AutoscalerDefaultsInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		t.Run(interval.String(), func(t *testing.T) {
+			clock := newFakeClock()
+			gate := make(chan struct{})
+			var running atomic.Int32
+
+			decisions := make(chan ScalingDecision, 1)
+			pool := newPool(PoolConfig{
+				Workers:   1,
+				QueueSize: 10,
+				Autoscale: &AutoscaleConfig{
+					Min:      1,
+					Max:      4,
+					Interval: interval,
+					OnScale:  func(d ScalingDecision) { decisions <- d },
+				},
+			}, gatedHandler(gate, &running), clock)
+			pool.Start()
+			defer func() {
+				close(gate)
+				pool.Stop()
+			}()
+
+			for i := 1; i <= 3; i++ {
+				require.NoError(t, pool.Submit(i))
+			}
+			require.Eventually(t, func() bool { return pool.QueueDepth() == 2 }, time.Second, time.Millisecond)
+
+			clock.BlockUntil(1)
+			clock.Advance(DefaultAutoscaleInterval)
+
+			d := <-decisions
+			assert.Equal(t, 1, d.From)
+			assert.Equal(t, 2, d.To)
+			assert.InDelta(t, 3.0, d.ArrivalRate, 0.001)
+		})
+	}
+}
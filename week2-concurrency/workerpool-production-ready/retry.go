@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// RetryPolicy decides whether and when a failed job is attempted again
+type RetryPolicy struct {
+	// total attempts including the first one, 0 or 1 disables retries
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// growth factor between attempts, defaults to 2
+	Multiplier float64
+	// fraction of the backoff that is randomised, 0 is no jitter and 1 is
+	// full jitter
+	Jitter float64
+	// reports whether an error is worth retrying, nil retries everything
+	// except errors wrapped with Permanent
+	Retryable func(err error) bool
+}
+
+// permanentError marks an error that retrying won't fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps err so the default RetryPolicy gives up on it straight away
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was wrapped with Permanent
+func IsPermanent(err error) bool {
+	var perm *permanentError
+	return errors.As(err, &perm)
+}
+
+// shouldRetry reports whether a job that failed its attempt-th try goes again
+func (rp RetryPolicy) shouldRetry(attempt int, err error) bool {
+	if attempt >= rp.MaxAttempts {
+		return false
+	}
+	if rp.Retryable != nil {
+		return rp.Retryable(err)
+	}
+	return !IsPermanent(err)
+}
+
+// backoff returns how long to wait after the attempt-th try, random returns
+// a number in [0, 1)
+func (rp RetryPolicy) backoff(attempt int, random func() float64) time.Duration {
+	multiplier := rp.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	delay := float64(rp.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if rp.MaxBackoff > 0 && delay > float64(rp.MaxBackoff) {
+		delay = float64(rp.MaxBackoff)
+	}
+
+	jitter := min(max(rp.Jitter, 0), 1)
+	delay = delay*(1-jitter) + delay*jitter*random()
+	return time.Duration(delay)
+}
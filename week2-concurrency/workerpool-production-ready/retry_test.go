@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errTransient = errors.New("transient")
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		random  float64
+		want    time.Duration
+	}{
+		{
+			name:    "first retry",
+			policy:  RetryPolicy{InitialBackoff: 100 * time.Millisecond},
+			attempt: 1,
+			want:    100 * time.Millisecond,
+		},
+		{
+			name:    "doubles by default",
+			policy:  RetryPolicy{InitialBackoff: 100 * time.Millisecond},
+			attempt: 4,
+			want:    800 * time.Millisecond,
+		},
+		{
+			name:    "custom multiplier",
+			policy:  RetryPolicy{InitialBackoff: 100 * time.Millisecond, Multiplier: 3},
+			attempt: 3,
+			want:    900 * time.Millisecond,
+		},
+		{
+			name:    "capped",
+			policy:  RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second},
+			attempt: 10,
+			want:    time.Second,
+		},
+		{
+			name:    "full jitter",
+			policy:  RetryPolicy{InitialBackoff: time.Second, Jitter: 1},
+			attempt: 1,
+			random:  0.25,
+			want:    250 * time.Millisecond,
+		},
+		{
+			name:    "half jitter keeps a floor",
+			policy:  RetryPolicy{InitialBackoff: time.Second, Jitter: 0.5},
+			attempt: 1,
+			random:  0,
+			want:    500 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.backoff(tt.attempt, func() float64 { return tt.random })
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRetryPolicy_ShouldRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3}
+
+	assert.True(t, policy.shouldRetry(1, errTransient))
+	assert.True(t, policy.shouldRetry(2, errTransient))
+	assert.False(t, policy.shouldRetry(3, errTransient), "out of attempts")
+	assert.False(t, policy.shouldRetry(1, Permanent(errTransient)))
+
+	policy.Retryable = func(err error) bool { return errors.Is(err, context.DeadlineExceeded) }
+	assert.True(t, policy.shouldRetry(1, context.DeadlineExceeded))
+	assert.False(t, policy.shouldRetry(1, errTransient))
+}
+
+// flakyHandler fails the first failures calls for every input
+func flakyHandler(failures int32, calls *atomic.Int32) Handler[string, string] {
+	return func(ctx context.Context, in string) (string, error) {
+		if calls.Add(1) <= failures {
+			return "", errTransient
+		}
+		return "ok " + in, nil
+	}
+}
+
+func TestPool_RetriesWithBackoff(t *testing.T) {
+	clock := newFakeClock()
+	var calls atomic.Int32
+	pool := newPool(PoolConfig{
+		Workers:   1,
+		QueueSize: 1,
+		Retry: RetryPolicy{
+			MaxAttempts:    5,
+			InitialBackoff: 100 * time.Millisecond,
+		},
+	}, flakyHandler(2, &calls), clock)
+	pool.Start()
+	defer pool.Stop()
+
+	require.NoError(t, pool.Submit("a"))
+
+	// first backoff is 100ms, nothing happens a tick before it
+	clock.BlockUntil(1)
+	clock.Advance(99 * time.Millisecond)
+	assert.Equal(t, int32(1), calls.Load())
+	clock.Advance(time.Millisecond)
+
+	// then 200ms
+	clock.BlockUntil(1)
+	assert.Equal(t, int32(2), calls.Load())
+	clock.Advance(200 * time.Millisecond)
+
+	r := collect(t, pool, 1)[0]
+	require.NoError(t, r.Error)
+	assert.Equal(t, "ok a", r.Value)
+	assert.Equal(t, 3, r.Attempts)
+	assert.Equal(t, 300*time.Millisecond, r.Duration)
+	assert.False(t, r.DeadLettered)
+	assert.Zero(t, pool.DeadLetters().Len())
+}
+
+func TestPool_DeadLetters(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		policy   RetryPolicy
+		attempts int
+	}{
+		{
+			name:     "retries exhausted",
+			err:      errTransient,
+			policy:   RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second},
+			attempts: 3,
+		},
+		{
+			name:     "permanent error",
+			err:      Permanent(errTransient),
+			policy:   RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second},
+			attempts: 1,
+		},
+		{
+			name:     "no retry policy",
+			err:      errTransient,
+			attempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			pool := newPool(PoolConfig{Workers: 1, QueueSize: 1}, func(ctx context.Context, in int) (int, error) {
+				return 0, tt.err
+			}, clock)
+			pool.Start()
+			defer pool.Stop()
+
+			require.NoError(t, pool.SubmitWithRetry(42, tt.policy))
+			for i := 1; i < tt.attempts; i++ {
+				clock.BlockUntil(1)
+				clock.Advance(time.Hour)
+			}
+
+			r := collect(t, pool, 1)[0]
+			assert.ErrorIs(t, r.Error, errTransient)
+			assert.Equal(t, tt.attempts, r.Attempts)
+			assert.True(t, r.DeadLettered)
+
+			letters := pool.DeadLetters().List()
+			require.Len(t, letters, 1)
+			assert.Equal(t, r.JobID, letters[0].JobID)
+			assert.Equal(t, 42, letters[0].Input)
+			assert.Equal(t, tt.attempts, letters[0].Attempts)
+			assert.ErrorIs(t, letters[0].Err, errTransient)
+		})
+	}
+}
+
+func TestPool_Resubmit(t *testing.T) {
+	clock := newFakeClock()
+	var broken atomic.Bool
+	broken.Store(true)
+
+	pool := newPool(PoolConfig{Workers: 1, QueueSize: 1}, func(ctx context.Context, in string) (string, error) {
+		if broken.Load() {
+			return "", errTransient
+		}
+		return "ok " + in, nil
+	}, clock)
+	pool.Start()
+	defer pool.Stop()
+
+	require.NoError(t, pool.Submit("a"))
+	failed := collect(t, pool, 1)[0]
+	require.True(t, failed.DeadLettered)
+
+	assert.ErrorIs(t, pool.Resubmit(failed.JobID+100), ErrJobNotFound)
+
+	// the dependency is fixed, try again
+	broken.Store(false)
+	require.NoError(t, pool.Resubmit(failed.JobID))
+	assert.Zero(t, pool.DeadLetters().Len())
+
+	r := collect(t, pool, 1)[0]
+	require.NoError(t, r.Error)
+	assert.Equal(t, failed.JobID, r.JobID)
+	assert.Equal(t, "ok a", r.Value)
+
+	assert.ErrorIs(t, pool.Resubmit(failed.JobID), ErrJobNotFound, "a letter can only be resubmitted once")
+}
+
+func TestPool_StopDuringBackoffIsNotDeadLettered(t *testing.T) {
+	clock := newFakeClock()
+	pool := newPool(PoolConfig{
+		Workers:   1,
+		QueueSize: 1,
+		Retry:     RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Minute},
+	}, func(ctx context.Context, in int) (int, error) {
+		return 0, errTransient
+	}, clock)
+	pool.Start()
+
+	require.NoError(t, pool.Submit(1))
+	clock.BlockUntil(1)
+	pool.Stop()
+
+	assert.Zero(t, pool.DeadLetters().Len())
+}
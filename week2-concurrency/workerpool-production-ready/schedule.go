@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule decides when a scheduled job runs
+type Schedule interface {
+	// Next returns the first run strictly after t, the zero time means
+	// there are no more runs
+	Next(t time.Time) time.Time
+}
+
+// runAt runs once
+type runAt struct {
+	at time.Time
+}
+
+// At runs a job once at t
+func At(t time.Time) Schedule {
+	return runAt{at: t}
+}
+
+func (s runAt) Next(t time.Time) time.Time {
+	if s.at.After(t) {
+		return s.at
+	}
+	return time.Time{}
+}
+
+// every runs at a fixed interval
+type every struct {
+	interval time.Duration
+}
+
+// Every runs a job every interval, starting one interval after it is added
+func Every(interval time.Duration) Schedule {
+	return every{interval: interval}
+}
+
+func (s every) Next(t time.Time) time.Time {
+	if s.interval <= 0 {
+		return time.Time{}
+	}
+	return t.Add(s.interval)
+}
+
+// cronSchedule is a parsed five field cron expression, each field is a
+// bitset of the values it matches
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// day of month and day of week are ORed when both are restricted, like
+	// in cron(8)
+	anyDom, anyDow bool
+}
+
+var ErrInvalidCron = errors.New("invalid cron expression")
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Cron parses a standard five field cron expression: minute, hour, day of
+// month, month and day of week (0 is Sunday, 7 is accepted too). Fields take
+// *, values, ranges, lists and steps like "*/15" or "1-5". The @hourly,
+// @daily, @weekly, @monthly and @yearly shorthands are supported as well.
+// Runs are computed in the location of the time passed to Next.
+func Cron(expr string) (Schedule, error) {
+	if spec, ok := cronDescriptors[strings.TrimSpace(expr)]; ok {
+		expr = spec
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w %q: want 5 fields, got %d", ErrInvalidCron, expr, len(fields))
+	}
+
+	var s cronSchedule
+	var err error
+	parse := func(field string, first, last int) uint64 {
+		if err != nil {
+			return 0
+		}
+		var bits uint64
+		bits, err = parseCronField(field, first, last)
+		if err != nil {
+			err = fmt.Errorf("%w %q: %v", ErrInvalidCron, expr, err)
+		}
+		return bits
+	}
+
+	s.minute = parse(fields[0], 0, 59)
+	s.hour = parse(fields[1], 0, 23)
+	s.dom = parse(fields[2], 1, 31)
+	s.month = parse(fields[3], 1, 12)
+	s.dow = parse(fields[4], 0, 7)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sunday can be written as 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.anyDom = strings.HasPrefix(fields[2], "*")
+	s.anyDow = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// MustCron is like Cron but panics on an invalid expression, for schedules
+// known at compile time
+func MustCron(expr string) Schedule {
+	s, err := Cron(expr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// parseCronField turns a comma separated list of *, n, a-b and */step
+// forms into a bitset
+func parseCronField(field string, first, last int) (uint64, error) {
+	var bits uint64
+
+	for part := range strings.SplitSeq(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step %q", part)
+			}
+		}
+
+		lo, hi := first, last
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var errA, errB error
+			lo, errA = strconv.Atoi(a)
+			hi, errB = strconv.Atoi(b)
+			if errA != nil || errB != nil {
+				return 0, fmt.Errorf("bad range %q", part)
+			}
+		default:
+			n, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			lo, hi = n, n
+			if hasStep {
+				// "5/15" means from 5 to the end in steps of 15
+				hi = last
+			}
+		}
+
+		if lo < first || hi > last || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, first, last)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+
+	switch {
+	case s.anyDom && s.anyDow:
+		return true
+	case s.anyDom:
+		return dow
+	case s.anyDow:
+		return dom
+	}
+	return dom || dow
+}
+
+func (s cronSchedule) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := time.Date(after.Year(), after.Month(), after.Day(), after.Hour(), after.Minute(), 0, 0, loc).Add(time.Minute)
+
+	// a valid expression matches within a few years, e.g. Feb 29
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
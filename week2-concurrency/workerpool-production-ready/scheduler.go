@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoFutureRuns   = errors.New("schedule has no future runs")
+	ErrEntryNotFound  = errors.New("scheduled entry not found")
+	ErrSchedulerState = errors.New("scheduler already started or stopped")
+)
+
+// OverlapPolicy decides what happens when a scheduled job is due while its
+// previous run is still queued or running
+type OverlapPolicy int
+
+const (
+	// drop the new run, the default
+	OverlapSkip OverlapPolicy = iota
+	// run it once the previous one has finished, runs are never dropped
+	OverlapQueue
+	// submit it anyway, runs may overlap
+	OverlapAllow
+)
+
+func (o OverlapPolicy) String() string {
+	switch o {
+	case OverlapSkip:
+		return "skip"
+	case OverlapQueue:
+		return "queue"
+	case OverlapAllow:
+		return "allow"
+	}
+	return fmt.Sprintf("OverlapPolicy(%d)", int(o))
+}
+
+// EntryID identifies a scheduled job
+type EntryID uint64
+
+// ScheduleOptions controls how a scheduled job is run
+type ScheduleOptions struct {
+	Overlap OverlapPolicy
+	// passed on to the pool for every run, OnDone is called after the
+	// scheduler's own bookkeeping
+	Job JobOptions
+}
+
+// EntryStatus is a snapshot of a scheduled job
+type EntryStatus struct {
+	// next time the job is due, zero once the schedule has ended
+	Next time.Time
+	// runs handed to the pool
+	Runs int
+	// runs dropped by OverlapSkip or because the pool refused them
+	Skipped int
+	// runs submitted and not finished yet
+	Running int
+	// runs waiting for the previous one under OverlapQueue
+	Queued int
+}
+
+type scheduledEntry[In any] struct {
+	id       EntryID
+	schedule Schedule
+	input    In
+	opts     ScheduleOptions
+	status   EntryStatus
+}
+
+// Scheduler submits jobs to a pool at a point in time, at fixed intervals or
+// on a cron schedule
+//
+// It uses the pool's clock, so a pool built with a fake clock gives a
+// deterministic scheduler too.
+type Scheduler[In, Out any] struct {
+	pool  *Pool[In, Out]
+	clock Clock
+
+	mu      sync.Mutex
+	entries map[EntryID]*scheduledEntry[In]
+	nextID  EntryID
+	started bool
+
+	wake chan struct{} // the earliest due time may have changed
+	stop chan struct{}
+	done chan struct{}
+}
+
+// creates a Scheduler feeding pool, Start must be called for jobs to run
+func NewScheduler[In, Out any](pool *Pool[In, Out]) *Scheduler[In, Out] {
+	return &Scheduler[In, Out]{
+		pool:    pool,
+		clock:   pool.clock,
+		entries: make(map[EntryID]*scheduledEntry[In]),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Add schedules in to be submitted whenever schedule is due
+func (s *Scheduler[In, Out]) Add(schedule Schedule, in In, opts ScheduleOptions) (EntryID, error) {
+	next := schedule.Next(s.clock.Now())
+	if next.IsZero() {
+		return 0, ErrNoFutureRuns
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	id := s.nextID
+	s.entries[id] = &scheduledEntry[In]{
+		id:       id,
+		schedule: schedule,
+		input:    in,
+		opts:     opts,
+		status:   EntryStatus{Next: next},
+	}
+	s.mu.Unlock()
+
+	s.signal()
+	return id, nil
+}
+
+// Remove stops scheduling an entry, runs already submitted are unaffected
+func (s *Scheduler[In, Out]) Remove(id EntryID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[id]; !ok {
+		return ErrEntryNotFound
+	}
+	delete(s.entries, id)
+	s.signal()
+	return nil
+}
+
+// Status returns a snapshot of an entry
+func (s *Scheduler[In, Out]) Status(id EntryID) (EntryStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[id]
+	if !ok {
+		return EntryStatus{}, ErrEntryNotFound
+	}
+	return e.status, nil
+}
+
+func (s *Scheduler[In, Out]) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the scheduling loop in the background
+func (s *Scheduler[In, Out]) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return ErrSchedulerState
+	}
+	s.started = true
+
+	go s.loop()
+	return nil
+}
+
+// Stop ends the scheduling loop, the pool itself keeps running
+func (s *Scheduler[In, Out]) Stop() {
+	s.mu.Lock()
+	started := s.started
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	s.mu.Unlock()
+
+	if started {
+		<-s.done
+	}
+}
+
+func (s *Scheduler[In, Out]) loop() {
+	defer close(s.done)
+
+	for {
+		var timer <-chan time.Time
+		if next, ok := s.earliest(); ok {
+			timer = s.clock.After(next.Sub(s.clock.Now()))
+		}
+
+		select {
+		case <-timer:
+			s.runDue()
+		case <-s.wake:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// earliest returns the next time any entry is due
+func (s *Scheduler[In, Out]) earliest() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, e := range s.entries {
+		if !e.status.Next.IsZero() && (next.IsZero() || e.status.Next.Before(next)) {
+			next = e.status.Next
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// runDue fires every entry that is due, in the order they were added
+func (s *Scheduler[In, Out]) runDue() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	for _, id := range slices.Sorted(maps.Keys(s.entries)) {
+		e := s.entries[id]
+		if e.status.Next.IsZero() || e.status.Next.After(now) {
+			continue
+		}
+
+		// runs missed while the process was busy aren't made up for
+		next := e.schedule.Next(e.status.Next)
+		if !next.IsZero() && !next.After(now) {
+			next = e.schedule.Next(now)
+		}
+		e.status.Next = next
+
+		s.fire(e)
+	}
+}
+
+// fire submits a run of e according to its overlap policy
+// must be called with s.mu held
+func (s *Scheduler[In, Out]) fire(e *scheduledEntry[In]) {
+	busy := e.status.Running > 0
+	switch {
+	case busy && e.opts.Overlap == OverlapSkip:
+		e.status.Skipped++
+		s.pool.instr.Logf("Scheduler: skipping entry %d, previous run still in progress", e.id)
+		return
+	case busy && e.opts.Overlap == OverlapQueue:
+		e.status.Queued++
+		return
+	}
+
+	s.submit(e)
+}
+
+// submit hands a run to the pool without blocking the scheduler, a full
+// queue is waited on in the background
+// must be called with s.mu held
+func (s *Scheduler[In, Out]) submit(e *scheduledEntry[In]) {
+	e.status.Runs++
+	e.status.Running++
+
+	opts := e.opts.Job
+	onDone := opts.OnDone
+	opts.OnDone = func(err error) {
+		s.finished(e.id)
+		if onDone != nil {
+			onDone(err)
+		}
+	}
+
+	err := s.pool.TrySubmitWith(e.input, opts)
+	if errors.Is(err, ErrQueueFull) {
+		go func() {
+			if err := s.pool.SubmitWith(e.input, opts); err != nil {
+				s.rejected(e.id, err)
+			}
+		}()
+		return
+	}
+	if err != nil {
+		s.reject(e, err)
+	}
+}
+
+func (s *Scheduler[In, Out]) rejected(id EntryID, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[id]; ok {
+		s.reject(e, err)
+	}
+}
+
+// reject undoes the bookkeeping of a run the pool didn't take
+// must be called with s.mu held
+func (s *Scheduler[In, Out]) reject(e *scheduledEntry[In], err error) {
+	e.status.Runs--
+	e.status.Running--
+	e.status.Skipped++
+	s.pool.instr.Logf("Scheduler: entry %d not submitted: %v", e.id, err)
+}
+
+// finished releases a run and starts a queued one
+func (s *Scheduler[In, Out]) finished(id EntryID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[id]
+	if !ok {
+		return
+	}
+
+	e.status.Running--
+	if e.status.Queued > 0 && e.status.Running == 0 {
+		e.status.Queued--
+		s.submit(e)
+	}
+}
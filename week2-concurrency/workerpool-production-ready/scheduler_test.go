@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCron_Next(t *testing.T) {
+	at := func(s string) time.Time {
+		ts, err := time.Parse("2006-01-02 15:04", s)
+		require.NoError(t, err)
+		return ts
+	}
+
+	tests := []struct {
+		expr  string
+		after string
+		want  string
+	}{
+		{expr: "*/15 * * * *", after: "2024-01-01 10:07", want: "2024-01-01 10:15"},
+		{expr: "*/15 * * * *", after: "2024-01-01 10:15", want: "2024-01-01 10:30"},
+		{expr: "5/20 * * * *", after: "2024-01-01 10:30", want: "2024-01-01 10:45"},
+		{expr: "0 9 * * 1-5", after: "2024-01-05 10:00", want: "2024-01-08 09:00"},
+		{expr: "30 8 * * 7", after: "2024-01-01 00:00", want: "2024-01-07 08:30"},
+		{expr: "0 0,12 * * *", after: "2024-01-01 00:00", want: "2024-01-01 12:00"},
+		{expr: "0 0 31 * *", after: "2024-01-31 00:00", want: "2024-03-31 00:00"},
+		{expr: "0 0 29 2 *", after: "2024-03-01 00:00", want: "2028-02-29 00:00"},
+		// day of month and day of week both restricted match either
+		{expr: "0 12 15 * 0", after: "2024-01-01 13:00", want: "2024-01-07 12:00"},
+		{expr: "@hourly", after: "2024-01-01 10:59", want: "2024-01-01 11:00"},
+		{expr: "@daily", after: "2024-01-01 10:00", want: "2024-01-02 00:00"},
+		{expr: "@monthly", after: "2024-01-15 00:00", want: "2024-02-01 00:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr+" after "+tt.after, func(t *testing.T) {
+			s, err := Cron(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, at(tt.want), s.Next(at(tt.after)))
+		})
+	}
+}
+
+func TestCron_Invalid(t *testing.T) {
+	for _, expr := range []string{
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"5-1 * * * *",
+		"* * * 13 *",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			_, err := Cron(expr)
+			assert.ErrorIs(t, err, ErrInvalidCron)
+		})
+	}
+	assert.Panics(t, func() { MustCron("@never") })
+}
+
+func TestScheduler_At(t *testing.T) {
+	clock := newFakeClock()
+	pool := newPool(PoolConfig{Workers: 1, QueueSize: 10}, func(ctx context.Context, n int) (int, error) {
+		return n, nil
+	}, clock)
+	pool.Start()
+	defer pool.Stop()
+
+	sched := NewScheduler(pool)
+	_, err := sched.Add(At(clock.Now()), 0, ScheduleOptions{})
+	assert.ErrorIs(t, err, ErrNoFutureRuns)
+
+	id, err := sched.Add(At(clock.Now().Add(time.Hour)), 42, ScheduleOptions{})
+	require.NoError(t, err)
+	require.NoError(t, sched.Start())
+	defer sched.Stop()
+	assert.ErrorIs(t, sched.Start(), ErrSchedulerState)
+
+	clock.BlockUntil(1)
+	clock.Advance(59 * time.Minute)
+	clock.Advance(time.Minute)
+
+	r := collect(t, pool, 1)[0]
+	assert.Equal(t, 42, r.Value)
+
+	status, err := sched.Status(id)
+	require.NoError(t, err)
+	assert.True(t, status.Next.IsZero(), "a one off job doesn't run again")
+	assert.Equal(t, 1, status.Runs)
+}
+
+func TestScheduler_Overlap(t *testing.T) {
+	tests := []struct {
+		policy      OverlapPolicy
+		whileBusy   EntryStatus
+		wantResults int
+	}{
+		{policy: OverlapSkip, whileBusy: EntryStatus{Runs: 1, Running: 1, Skipped: 2}, wantResults: 1},
+		{policy: OverlapQueue, whileBusy: EntryStatus{Runs: 1, Running: 1, Queued: 2}, wantResults: 3},
+		{policy: OverlapAllow, whileBusy: EntryStatus{Runs: 3, Running: 3}, wantResults: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			clock := newFakeClock()
+			gate := make(chan struct{})
+			var running atomic.Int32
+			pool := newPool(PoolConfig{Workers: 3, QueueSize: 10}, gatedHandler(gate, &running), clock)
+			pool.Start()
+			defer pool.Stop()
+
+			var done atomic.Int32
+			sched := NewScheduler(pool)
+			id, err := sched.Add(Every(time.Minute), 1, ScheduleOptions{
+				Overlap: tt.policy,
+				Job:     JobOptions{OnDone: func(error) { done.Add(1) }},
+			})
+			require.NoError(t, err)
+			require.NoError(t, sched.Start())
+			defer sched.Stop()
+
+			// the first run blocks, the next two ticks find it still running
+			for range 3 {
+				clock.BlockUntil(1)
+				clock.Advance(time.Minute)
+			}
+			clock.BlockUntil(1)
+
+			status, err := sched.Status(id)
+			require.NoError(t, err)
+			tt.whileBusy.Next = clock.Now().Add(time.Minute)
+			assert.Equal(t, tt.whileBusy, status)
+
+			close(gate)
+			collect(t, pool, tt.wantResults)
+			require.Eventually(t, func() bool { return done.Load() == int32(tt.wantResults) }, time.Second, time.Millisecond)
+
+			status, err = sched.Status(id)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantResults, status.Runs)
+			assert.Zero(t, status.Running)
+			assert.Zero(t, status.Queued)
+		})
+	}
+}
+
+func TestScheduler_PoolStopReleasesRuns(t *testing.T) {
+	clock := newFakeClock()
+	gate := make(chan struct{})
+	var running atomic.Int32
+	pool := newPool(PoolConfig{Workers: 1, QueueSize: 10}, gatedHandler(gate, &running), clock)
+	pool.Start()
+
+	sched := NewScheduler(pool)
+	id, err := sched.Add(Every(time.Minute), 1, ScheduleOptions{Overlap: OverlapAllow})
+	require.NoError(t, err)
+	require.NoError(t, sched.Start())
+	defer sched.Stop()
+
+	// one run blocks the only worker, two more wait in the queue
+	for range 3 {
+		clock.BlockUntil(1)
+		clock.Advance(time.Minute)
+	}
+	clock.BlockUntil(1)
+	status, err := sched.Status(id)
+	require.NoError(t, err)
+	require.Equal(t, 3, status.Running)
+
+	pool.Stop()
+	status, err = sched.Status(id)
+	require.NoError(t, err)
+	assert.Zero(t, status.Running, "dropped runs are finished too")
+}
+
+func TestScheduler_CronSkipsMissedRuns(t *testing.T) {
+	clock := newFakeClock() // midnight
+	pool := newPool(PoolConfig{Workers: 1, QueueSize: 10}, func(ctx context.Context, n int) (int, error) {
+		return n, nil
+	}, clock)
+	pool.Start()
+	defer pool.Stop()
+
+	sched := NewScheduler(pool)
+	id, err := sched.Add(MustCron("0 * * * *"), 7, ScheduleOptions{})
+	require.NoError(t, err)
+	require.NoError(t, sched.Start())
+	defer sched.Stop()
+
+	clock.BlockUntil(1)
+	clock.Advance(30 * time.Minute)
+	clock.Advance(30 * time.Minute)
+	collect(t, pool, 1)
+
+	// three hours pass in one go, that is one run and not three
+	clock.BlockUntil(1)
+	clock.Advance(3 * time.Hour)
+	collect(t, pool, 1)
+	clock.BlockUntil(1)
+
+	status, err := sched.Status(id)
+	require.NoError(t, err)
+	assert.Equal(t, 2, status.Runs)
+	assert.Equal(t, clock.Now().Add(time.Hour), status.Next)
+}
+
+func TestScheduler_Remove(t *testing.T) {
+	clock := newFakeClock()
+	pool := newPool(PoolConfig{Workers: 1, QueueSize: 10}, func(ctx context.Context, n int) (int, error) {
+		return n, nil
+	}, clock)
+	pool.Start()
+	defer pool.Stop()
+
+	sched := NewScheduler(pool)
+	first, err := sched.Add(Every(time.Minute), 1, ScheduleOptions{})
+	require.NoError(t, err)
+	_, err = sched.Add(Every(time.Minute), 2, ScheduleOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, sched.Remove(first))
+	assert.ErrorIs(t, sched.Remove(first), ErrEntryNotFound)
+	_, err = sched.Status(first)
+	assert.ErrorIs(t, err, ErrEntryNotFound)
+
+	require.NoError(t, sched.Start())
+	defer sched.Stop()
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+
+	assert.Equal(t, 2, collect(t, pool, 1)[0].Value)
+	select {
+	case r := <-pool.Results():
+		t.Fatalf("removed entry still ran: %v", r.Value)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
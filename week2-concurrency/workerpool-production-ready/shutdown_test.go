@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// drain reads results until the channel is closed
+func drain[In, Out any](p *Pool[In, Out]) []Result[In, Out] {
+	var results []Result[In, Out]
+	for r := range p.Results() {
+		results = append(results, r)
+	}
+	return results
+}
+
+func TestPool_ShutdownDrainsQueue(t *testing.T) {
+	pool := NewPool(PoolConfig{Workers: 1, QueueSize: 5}, func(ctx context.Context, n int) (int, error) {
+		time.Sleep(10 * time.Millisecond)
+		return n, nil
+	})
+	pool.Start()
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, pool.Submit(i))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	report, err := pool.Shutdown(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 5, report.Completed)
+	assert.Zero(t, report.Cancelled)
+	assert.Empty(t, report.NeverStarted)
+	assert.False(t, report.Forced)
+
+	results := drain(pool)
+	require.Len(t, results, 5)
+	for _, r := range results {
+		assert.NoError(t, r.Error)
+	}
+}
+
+func TestPool_ShutdownForcedAtDeadline(t *testing.T) {
+	started := make(chan struct{}, 1)
+	pool := NewPool(PoolConfig{Workers: 1, QueueSize: 3}, func(ctx context.Context, n int) (int, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	pool.Start()
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, pool.Submit(i))
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	report, err := pool.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, report.Forced)
+	assert.Zero(t, report.Completed)
+	assert.Equal(t, 1, report.Cancelled)
+	assert.ElementsMatch(t, []int{2, 3}, report.NeverStarted)
+
+	// the cancelled job's result is still delivered
+	results := drain(pool)
+	require.Len(t, results, 1)
+	assert.Equal(t, 1, results[0].Input)
+	assert.ErrorIs(t, results[0].Error, context.Canceled)
+}
+
+func TestPool_ShutdownCallsOnDoneOfDroppedJobs(t *testing.T) {
+	started := make(chan struct{}, 1)
+	pool := NewPool(PoolConfig{Workers: 1, QueueSize: 3}, func(ctx context.Context, n int) (int, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	pool.Start()
+
+	var mu sync.Mutex
+	done := map[int]error{}
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, pool.SubmitWith(i, JobOptions{OnDone: func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			done[i] = err
+		}}))
+	}
+	<-started
+
+	pool.Stop()
+	drain(pool)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, done, 3)
+	assert.ErrorIs(t, done[1], context.Canceled)
+	assert.ErrorIs(t, done[2], ErrJobCancelled)
+	assert.ErrorIs(t, done[3], ErrJobCancelled)
+}
+
+func TestPool_ShutdownRejectsNewWork(t *testing.T) {
+	pool := NewPool(PoolConfig{Workers: 1, QueueSize: 1}, func(ctx context.Context, n int) (int, error) {
+		return n, nil
+	})
+	pool.Start()
+
+	_, err := pool.Shutdown(context.Background())
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, pool.Submit(1), ErrPoolClosed)
+	_, err = pool.Shutdown(context.Background())
+	assert.ErrorIs(t, err, ErrPoolClosed)
+	assert.NotPanics(t, pool.Stop)
+}
+
+func TestPool_ShutdownUnblocksSubmitters(t *testing.T) {
+	// never started, so the queue stays full
+	pool := NewPool(PoolConfig{Workers: 1, QueueSize: 1}, func(ctx context.Context, n int) (int, error) {
+		return n, nil
+	})
+	require.NoError(t, pool.Submit(1))
+
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- pool.Submit(2)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err := pool.Shutdown(ctx)
+
+	assert.ErrorIs(t, <-blocked, ErrPoolClosed)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []int{1}, report.NeverStarted)
+}
+
+func TestPool_StopIsImmediate(t *testing.T) {
+	started := make(chan struct{})
+	pool := NewPool(PoolConfig{Workers: 1, QueueSize: 5}, func(ctx context.Context, n int) (int, error) {
+		if n == 1 {
+			close(started)
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(time.Minute):
+			return n, nil
+		}
+	})
+	pool.Start()
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, pool.Submit(i))
+	}
+	<-started
+
+	begin := time.Now()
+	pool.Stop()
+	assert.Less(t, time.Since(begin), time.Second)
+
+	results := drain(pool)
+	require.Len(t, results, 1)
+	assert.ErrorIs(t, results[0].Error, context.Canceled)
+}
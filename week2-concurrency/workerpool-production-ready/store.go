@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrDuplicateJob = errors.New("a job with this idempotency key was already submitted")
+
+// JobRecord is what a JobStore keeps about a job that hasn't finished yet
+type JobRecord[In any] struct {
+	ID       uint64
+	Key      string
+	Input    In
+	Priority int
+	Tenant   string
+	// a worker picked the job up, it may have run partially before a crash
+	Started bool
+}
+
+// Recovery is the state a JobStore hands back to a pool on start up
+type Recovery[In any] struct {
+	// jobs that were submitted or in progress, in submission order
+	Pending []JobRecord[In]
+	// highest job id the store has seen, new ids continue from here
+	LastID uint64
+}
+
+// JobStore records the lifecycle of every job so queued and running work
+// survives a crash
+//
+// A job moves from Submitted to Started to Finished. Jobs that never reach
+// Finished are handed back by Recover and run again, so delivery is
+// at-least-once and handlers with side effects should use JobKey to make
+// them idempotent.
+type JobStore[In any] interface {
+	// records a new job, it returns ErrDuplicateJob if rec.Key is already
+	// used by another job, pending or finished within the store's retention
+	Submitted(rec JobRecord[In]) error
+	Started(id uint64) error
+	// the job is done with, successfully or not, and won't be recovered
+	Finished(id uint64) error
+	// forgets a job that never made it onto the queue, freeing its key
+	Discard(id uint64) error
+	Recover() (Recovery[In], error)
+}
+
+type jobKeyCtx struct{}
+
+// JobKey returns the idempotency key of the job a handler is running, or ""
+// if it was submitted without one
+func JobKey(ctx context.Context) string {
+	key, _ := ctx.Value(jobKeyCtx{}).(string)
+	return key
+}
+
+// nopStore is the JobStore of a pool that keeps its jobs in memory only
+type nopStore[In any] struct{}
+
+func (nopStore[In]) Submitted(JobRecord[In]) error  { return nil }
+func (nopStore[In]) Started(uint64) error           { return nil }
+func (nopStore[In]) Finished(uint64) error          { return nil }
+func (nopStore[In]) Discard(uint64) error           { return nil }
+func (nopStore[In]) Recover() (Recovery[In], error) { return Recovery[In]{}, nil }
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openStore(t *testing.T, path string) *FileStore[int] {
+	t.Helper()
+
+	store, err := OpenFileStore[int](path)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestFileStore_Replay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.wal")
+
+	store := openStore(t, path)
+	require.NoError(t, store.Submitted(JobRecord[int]{ID: 1, Key: "order-1", Input: 10}))
+	require.NoError(t, store.Submitted(JobRecord[int]{ID: 2, Input: 20, Priority: 3, Tenant: "acme"}))
+	require.NoError(t, store.Submitted(JobRecord[int]{ID: 3, Input: 30}))
+	require.NoError(t, store.Started(1))
+	require.NoError(t, store.Started(2))
+	require.NoError(t, store.Finished(1))
+	require.NoError(t, store.Close())
+
+	store = openStore(t, path)
+	recovery, err := store.Recover()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), recovery.LastID)
+	assert.Equal(t, []JobRecord[int]{
+		{ID: 2, Input: 20, Priority: 3, Tenant: "acme", Started: true},
+		{ID: 3, Input: 30},
+	}, recovery.Pending)
+
+	// the key of a finished job stays taken across restarts
+	assert.ErrorIs(t, store.Submitted(JobRecord[int]{ID: 4, Key: "order-1"}), ErrDuplicateJob)
+	// but the same job may be submitted again, e.g. from the dead letter queue
+	assert.NoError(t, store.Submitted(JobRecord[int]{ID: 1, Key: "order-1", Input: 10}))
+}
+
+func TestFileStore_DiscardFreesKey(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "jobs.wal"))
+
+	require.NoError(t, store.Submitted(JobRecord[int]{ID: 1, Key: "k"}))
+	assert.ErrorIs(t, store.Submitted(JobRecord[int]{ID: 2, Key: "k"}), ErrDuplicateJob)
+
+	require.NoError(t, store.Discard(1))
+	assert.NoError(t, store.Submitted(JobRecord[int]{ID: 2, Key: "k"}))
+
+	recovery, err := store.Recover()
+	require.NoError(t, err)
+	require.Len(t, recovery.Pending, 1)
+	assert.Equal(t, uint64(2), recovery.Pending[0].ID)
+}
+
+func TestFileStore_KeyRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.wal")
+	clock := newFakeClock()
+	open := func(opts FileStoreOptions) *FileStore[int] {
+		store, err := openFileStore[int](path, opts, clock)
+		require.NoError(t, err)
+		t.Cleanup(func() { store.Close() })
+		return store
+	}
+
+	store := open(FileStoreOptions{KeyTTL: time.Hour, MaxKeys: -1})
+	require.NoError(t, store.Submitted(JobRecord[int]{ID: 1, Key: "a"}))
+	require.NoError(t, store.Finished(1))
+	clock.Advance(30 * time.Minute)
+	require.NoError(t, store.Close())
+
+	// the finish time survives a restart, the TTL doesn't start over
+	store = open(FileStoreOptions{KeyTTL: time.Hour, MaxKeys: -1})
+	assert.ErrorIs(t, store.Submitted(JobRecord[int]{ID: 2, Key: "a"}), ErrDuplicateJob)
+	clock.Advance(30 * time.Minute)
+	assert.NoError(t, store.Submitted(JobRecord[int]{ID: 2, Key: "a"}))
+	require.NoError(t, store.Discard(2))
+	require.NoError(t, store.Close())
+
+	// only the newest keys are kept
+	store = open(FileStoreOptions{KeyTTL: -1, MaxKeys: 2})
+	for id := uint64(3); id <= 5; id++ {
+		require.NoError(t, store.Submitted(JobRecord[int]{ID: id, Key: fmt.Sprint("k", id)}))
+		require.NoError(t, store.Finished(id))
+	}
+	assert.NoError(t, store.Submitted(JobRecord[int]{ID: 6, Key: "k3"}))
+	assert.ErrorIs(t, store.Submitted(JobRecord[int]{ID: 7, Key: "k4"}), ErrDuplicateJob)
+	require.NoError(t, store.Discard(6))
+	require.NoError(t, store.Close())
+
+	// compaction drops expired keys from the log
+	clock.Advance(2 * time.Hour)
+	open(FileStoreOptions{KeyTTL: time.Hour})
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, bytes.Count(data, []byte("\n")), "just the sequence number")
+}
+
+func TestFileStore_TornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.wal")
+
+	store := openStore(t, path)
+	require.NoError(t, store.Submitted(JobRecord[int]{ID: 1, Input: 10}))
+	require.NoError(t, store.Close())
+
+	// a crash half way through appending the next entry
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"op":"submit","id":2,"inp`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	store = openStore(t, path)
+	recovery, err := store.Recover()
+	require.NoError(t, err)
+	assert.Equal(t, []JobRecord[int]{{ID: 1, Input: 10}}, recovery.Pending)
+	require.NoError(t, store.Close())
+
+	// a bad line with entries after it is skipped too
+	require.NoError(t, os.WriteFile(path, []byte("not json\n{\"op\":\"seq\",\"id\":7}\n"), 0o644))
+	store = openStore(t, path)
+	recovery, err = store.Recover()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(7), recovery.LastID)
+}
+
+func TestFileStore_FailedAppendIsCutOff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.wal")
+
+	store := openStore(t, path)
+	require.NoError(t, store.Submitted(JobRecord[int]{ID: 1, Input: 10}))
+
+	// an append that only got half its entry out before failing
+	store.mu.Lock()
+	_, err := store.file.WriteString(`{"op":"submit","id":2,"inp`)
+	require.NoError(t, err)
+	assert.EqualError(t, store.cutOff(errors.New("disk full")), "disk full")
+	store.mu.Unlock()
+
+	require.NoError(t, store.Submitted(JobRecord[int]{ID: 3, Input: 30}))
+	require.NoError(t, store.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), `"id":2`)
+
+	store = openStore(t, path)
+	recovery, err := store.Recover()
+	require.NoError(t, err)
+	assert.Equal(t, []JobRecord[int]{{ID: 1, Input: 10}, {ID: 3, Input: 30}}, recovery.Pending)
+}
+
+func TestFileStore_CompactsOnOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.wal")
+
+	store := openStore(t, path)
+	for id := uint64(1); id <= 50; id++ {
+		require.NoError(t, store.Submitted(JobRecord[int]{ID: id, Input: int(id)}))
+		require.NoError(t, store.Started(id))
+		require.NoError(t, store.Finished(id))
+	}
+	require.NoError(t, store.Submitted(JobRecord[int]{ID: 51, Input: 51}))
+	require.NoError(t, store.Close())
+
+	store = openStore(t, path)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	// the sequence number and the one pending job
+	assert.Equal(t, 2, bytes.Count(data, []byte("\n")))
+
+	recovery, err := store.Recover()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(51), recovery.LastID)
+	assert.Len(t, recovery.Pending, 1)
+}
+
+func TestFileStore_CompactsWhileOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.wal")
+	store, err := openFileStore[int](path, FileStoreOptions{CompactEvery: 10}, newFakeClock())
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	lines := func() int {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return bytes.Count(data, []byte("\n"))
+	}
+
+	for id := uint64(1); id <= 100; id++ {
+		require.NoError(t, store.Submitted(JobRecord[int]{ID: id, Input: int(id)}))
+		require.NoError(t, store.Started(id))
+		require.NoError(t, store.Finished(id))
+		assert.LessOrEqual(t, lines(), 13, "what the last compaction kept and what was appended since")
+	}
+	require.NoError(t, store.Submitted(JobRecord[int]{ID: 101, Input: 101}))
+	require.NoError(t, store.Close())
+
+	store = openStore(t, path)
+	recovery, err := store.Recover()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(101), recovery.LastID)
+	assert.Equal(t, []JobRecord[int]{{ID: 101, Input: 101}}, recovery.Pending)
+}
+
+func TestFileStore_FinishedKeyKeptOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.wal")
+	clock := newFakeClock()
+	store, err := openFileStore[int](path, FileStoreOptions{KeyTTL: time.Hour}, clock)
+	require.NoError(t, err)
+
+	// the same job finishing again, e.g. resubmitted from the dead letter queue
+	for range 3 {
+		require.NoError(t, store.Submitted(JobRecord[int]{ID: 1, Key: "order-1"}))
+		require.NoError(t, store.Finished(1))
+		clock.Advance(30 * time.Minute)
+	}
+	assert.Equal(t, 1, store.finished.Len())
+	require.NoError(t, store.Close())
+
+	store, err = openFileStore[int](path, FileStoreOptions{KeyTTL: time.Hour}, clock)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, bytes.Count(data, []byte(`"op":"finish"`)))
+
+	// the retention runs from the last finish
+	assert.ErrorIs(t, store.Submitted(JobRecord[int]{ID: 2, Key: "order-1"}), ErrDuplicateJob)
+	clock.Advance(30 * time.Minute)
+	assert.NoError(t, store.Submitted(JobRecord[int]{ID: 2, Key: "order-1"}))
+}
+
+func TestPool_DurableRecoversAfterCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.wal")
+
+	// first run: job 1 is running and 2, 3 are queued when the process dies
+	store := openStore(t, path)
+	gate := make(chan struct{})
+	var running atomic.Int32
+	pool, err := NewDurablePool(PoolConfig{Workers: 1, QueueSize: 10}, gatedHandler(gate, &running), store)
+	require.NoError(t, err)
+	pool.Start()
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, pool.Submit(i))
+	}
+	require.Eventually(t, func() bool { return running.Load() == 1 }, time.Second, time.Millisecond)
+	pool.Stop()
+	require.NoError(t, store.Close())
+
+	// second run picks up where the first left off
+	store = openStore(t, path)
+	pool, err = NewDurablePool(PoolConfig{Workers: 1, QueueSize: 1}, func(ctx context.Context, n int) (int, error) {
+		return n * 10, nil
+	}, store)
+	require.NoError(t, err)
+	assert.Equal(t, 3, pool.QueueDepth(), "recovered jobs fit even past QueueSize")
+	pool.Start()
+
+	redelivered := make(map[uint64]bool)
+	for _, r := range collect(t, pool, 3) {
+		require.NoError(t, r.Error)
+		assert.Equal(t, r.Input*10, r.Value)
+		redelivered[r.JobID] = r.Redelivered
+	}
+	assert.Equal(t, map[uint64]bool{1: true, 2: false, 3: false}, redelivered)
+
+	// ids carry on after the recovered ones
+	require.NoError(t, pool.Submit(4))
+	r := collect(t, pool, 1)[0]
+	assert.Equal(t, uint64(4), r.JobID)
+
+	_, err = pool.Shutdown(context.Background())
+	require.NoError(t, err)
+
+	recovery, err := store.Recover()
+	require.NoError(t, err)
+	assert.Empty(t, recovery.Pending, "everything finished")
+}
+
+func TestPool_DurableIdempotencyKeys(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "jobs.wal"))
+
+	pool, err := NewDurablePool(PoolConfig{Workers: 1, QueueSize: 10}, func(ctx context.Context, n int) (string, error) {
+		return JobKey(ctx), nil
+	}, store)
+	require.NoError(t, err)
+	pool.Start()
+	defer pool.Stop()
+
+	require.NoError(t, pool.SubmitWith(1, JobOptions{Key: "payment-1"}))
+	assert.ErrorIs(t, pool.SubmitWith(1, JobOptions{Key: "payment-1"}), ErrDuplicateJob)
+	assert.Equal(t, "payment-1", collect(t, pool, 1)[0].Value)
+
+	// still a duplicate once the first one has finished
+	assert.ErrorIs(t, pool.TrySubmitWith(1, JobOptions{Key: "payment-1"}), ErrDuplicateJob)
+	assert.Eventually(t, func() bool { return pool.QueueDepth() == 0 }, time.Second, time.Millisecond,
+		"rejected jobs give their queue slot back")
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// PanicError is the error of a job whose handler panicked
+type PanicError struct {
+	Value any
+	// stack of the panicking goroutine
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("job panicked: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it was an error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// IsPanic reports whether err comes from a panicking handler
+func IsPanic(err error) bool {
+	var pe *PanicError
+	return errors.As(err, &pe)
+}
+
+// safeCall runs the handler, turning a panic into a PanicError
+func safeCall[In, Out any](ctx context.Context, handler Handler[In, Out], in In) (out Out, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+
+	return handler(ctx, in)
+}
+
+// callOnDone runs a job's OnDone callback, a panic in it is returned as a
+// PanicError like one in a handler
+func callOnDone(onDone func(err error), err error) error {
+	if onDone == nil {
+		return nil
+	}
+	_, panicErr := safeCall(context.Background(), func(ctx context.Context, err error) (struct{}, error) {
+		onDone(err)
+		return struct{}{}, nil
+	}, err)
+	return panicErr
+}
+
+// supervise runs a worker and restarts it whenever its job panicked, so a
+// buggy job costs one result instead of a worker
+func (p *Pool[In, Out]) supervise(workerId int, quit <-chan struct{}) {
+	defer p.wg.Done()
+
+	for p.worker(workerId, quit) {
+		p.restarts.Add(1)
+		p.instr.Logf("Worker %d: restarting after a panic", workerId)
+	}
+}
+
+// returns how many times a worker was restarted after a panic
+func (p *Pool[In, Out]) Restarts() int {
+	return int(p.restarts.Load())
+}
+
+// BreakerState is the state of the pool's panic circuit breaker
+type BreakerState int
+
+const (
+	// jobs are handed out as usual
+	BreakerClosed BreakerState = iota
+	// too many jobs panicked in a row, the pool is paused
+	BreakerOpen
+	// the cooldown is over, a single probe job decides whether to close again
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// BreakerConfig pauses the pool when jobs keep panicking, which usually means
+// a bad deploy or a poisoned dependency rather than a bad job
+type BreakerConfig struct {
+	// consecutive panicking attempts that trip the breaker
+	MaxConsecutivePanics int
+	// how long the pool stays paused before a probe job is let through,
+	// zero keeps it paused until Resume is called, a shutdown meanwhile
+	// never starts the queued jobs
+	Cooldown      time.Duration
+	OnStateChange func(from, to BreakerState)
+}
+
+// breaker gates the dispatcher, a nil config never trips
+type breaker struct {
+	cfg   *BreakerConfig
+	clock Clock
+	ctx   context.Context // stops the cooldown timer
+	logf  func(format string, args ...any)
+
+	mu          sync.Mutex
+	state       BreakerState
+	consecutive int
+	probing     bool          // the half-open probe job has been handed out
+	changed     chan struct{} // closed and replaced on every state change
+}
+
+func newBreaker(ctx context.Context, cfg *BreakerConfig, clock Clock, logf func(string, ...any)) *breaker {
+	return &breaker{
+		cfg:     cfg,
+		clock:   clock,
+		ctx:     ctx,
+		logf:    logf,
+		changed: make(chan struct{}),
+	}
+}
+
+// admit blocks while the breaker is open, it reports false once ctx is done
+//
+// Without a cooldown only Resume closes the breaker again, so admit also gives
+// up once stop is closed rather than hold a graceful shutdown up forever.
+func (b *breaker) admit(ctx context.Context, stop <-chan struct{}) bool {
+	if b.cfg != nil && b.cfg.Cooldown > 0 {
+		// the breaker half-opens on its own, a draining pool can wait for it
+		stop = nil
+	}
+
+	for {
+		b.mu.Lock()
+		switch {
+		case b.state == BreakerClosed:
+			b.mu.Unlock()
+			return true
+		case b.state == BreakerHalfOpen && !b.probing:
+			b.probing = true
+			b.mu.Unlock()
+			return true
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		case <-stop:
+			return false
+		}
+	}
+}
+
+// record feeds the outcome of an attempt to the breaker
+func (b *breaker) record(panicked bool) {
+	if b.cfg == nil || b.cfg.MaxConsecutivePanics <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !panicked {
+		b.consecutive = 0
+		if b.state == BreakerHalfOpen {
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+
+	b.consecutive++
+	switch {
+	case b.state == BreakerHalfOpen:
+		b.trip()
+	case b.state == BreakerClosed && b.consecutive >= b.cfg.MaxConsecutivePanics:
+		b.trip()
+	}
+}
+
+// must be called with b.mu held
+func (b *breaker) trip() {
+	b.setState(BreakerOpen)
+	b.logf("Circuit breaker open after %d consecutive panics, pausing the pool", b.consecutive)
+
+	if b.cfg.Cooldown <= 0 {
+		return
+	}
+
+	changed := b.changed
+	go func() {
+		select {
+		case <-b.clock.After(b.cfg.Cooldown):
+		case <-b.ctx.Done():
+			return
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		// only if nothing else, like Resume, moved the breaker meanwhile
+		if b.changed == changed {
+			b.setState(BreakerHalfOpen)
+		}
+	}()
+}
+
+// must be called with b.mu held
+func (b *breaker) setState(to BreakerState) {
+	from := b.state
+	b.state = to
+	b.probing = false
+	close(b.changed)
+	b.changed = make(chan struct{})
+
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(from, to)
+	}
+}
+
+func (b *breaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.consecutive = 0
+	if b.state != BreakerClosed {
+		b.setState(BreakerClosed)
+	}
+}
+
+func (b *breaker) current() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// reports whether the circuit breaker has paused the pool
+func (p *Pool[In, Out]) Paused() bool {
+	return p.breaker.current() != BreakerClosed
+}
+
+// BreakerState returns the state of the panic circuit breaker
+func (p *Pool[In, Out]) BreakerState() BreakerState {
+	return p.breaker.current()
+}
+
+// Resume closes the circuit breaker, e.g. once the cause of the panics has
+// been fixed
+func (p *Pool[In, Out]) Resume() {
+	p.breaker.reset()
+	p.instr.Logf("Circuit breaker closed, resuming the pool")
+}
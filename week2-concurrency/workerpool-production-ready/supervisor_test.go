@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// panickyHandler panics for negative inputs
+func panickyHandler(ctx context.Context, n int) (int, error) {
+	if n < 0 {
+		panic("negative input")
+	}
+	return n, nil
+}
+
+func TestPool_PanicBecomesResultError(t *testing.T) {
+	pool := NewPool(PoolConfig{Workers: 1, QueueSize: 10}, panickyHandler)
+
+	for _, n := range []int{1, -2, 3} {
+		require.NoError(t, pool.Submit(n))
+	}
+	pool.Start()
+
+	results := collect(t, pool, 3)
+	pool.Stop()
+
+	for _, r := range results {
+		// the same worker carries on after being restarted
+		assert.Equal(t, 1, r.WorkerID)
+		if r.Input > 0 {
+			assert.NoError(t, r.Error)
+			continue
+		}
+
+		var pe *PanicError
+		require.ErrorAs(t, r.Error, &pe)
+		assert.Equal(t, "negative input", pe.Value)
+		assert.Contains(t, string(pe.Stack), "panickyHandler")
+		assert.True(t, r.DeadLettered)
+	}
+	assert.Equal(t, 1, pool.Restarts())
+}
+
+func TestPool_PanicWithError(t *testing.T) {
+	errBoom := errors.New("boom")
+	pool := NewPool(PoolConfig{Workers: 1, QueueSize: 1}, func(ctx context.Context, n int) (int, error) {
+		panic(errBoom)
+	})
+	pool.Start()
+	defer pool.Stop()
+
+	require.NoError(t, pool.Submit(1))
+	err := collect(t, pool, 1)[0].Error
+	assert.True(t, IsPanic(err))
+	assert.ErrorIs(t, err, errBoom)
+	assert.EqualError(t, err, "job panicked: boom")
+}
+
+func TestPool_PanicInOnDone(t *testing.T) {
+	pool := NewPool(PoolConfig{Workers: 1, QueueSize: 10}, panickyHandler)
+
+	require.NoError(t, pool.SubmitWith(1, JobOptions{OnDone: func(error) { panic("callback") }}))
+	require.NoError(t, pool.Submit(2))
+	pool.Start()
+
+	// the worker survives the callback and runs the next job
+	results := collect(t, pool, 2)
+	pool.Stop()
+
+	for _, r := range results {
+		assert.NoError(t, r.Error)
+		assert.Equal(t, 1, r.WorkerID)
+	}
+	assert.Equal(t, 1, pool.Restarts())
+}
+
+// stateRecorder collects breaker transitions
+type stateRecorder struct {
+	mu          sync.Mutex
+	transitions [][2]BreakerState
+}
+
+func (r *stateRecorder) record(from, to BreakerState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.transitions = append(r.transitions, [2]BreakerState{from, to})
+}
+
+func (r *stateRecorder) get() [][2]BreakerState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([][2]BreakerState(nil), r.transitions...)
+}
+
+func TestPool_BreakerPausesAndProbes(t *testing.T) {
+	clock := newFakeClock()
+	var states stateRecorder
+	pool := newPool(PoolConfig{
+		Workers:   2,
+		QueueSize: 10,
+		Breaker: &BreakerConfig{
+			MaxConsecutivePanics: 2,
+			Cooldown:             time.Minute,
+			OnStateChange:        states.record,
+		},
+	}, panickyHandler, clock)
+	pool.Start()
+	defer pool.Stop()
+
+	require.NoError(t, pool.Submit(-1))
+	require.NoError(t, pool.Submit(-2))
+	collect(t, pool, 2)
+	assert.True(t, pool.Paused())
+	assert.Equal(t, BreakerOpen, pool.BreakerState())
+
+	// jobs wait in the queue while the pool is paused
+	require.NoError(t, pool.Submit(-3))
+	require.NoError(t, pool.Submit(4))
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 2, pool.QueueDepth())
+
+	// the probe after the cooldown panics too, so the breaker opens again
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	r := collect(t, pool, 1)[0]
+	assert.Equal(t, -3, r.Input)
+	assert.Equal(t, BreakerOpen, pool.BreakerState())
+
+	// the next probe succeeds and the pool is back to normal
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	r = collect(t, pool, 1)[0]
+	assert.Equal(t, 4, r.Input)
+	require.NoError(t, r.Error)
+	assert.False(t, pool.Paused())
+
+	assert.Equal(t, [][2]BreakerState{
+		{BreakerClosed, BreakerOpen},
+		{BreakerOpen, BreakerHalfOpen},
+		{BreakerHalfOpen, BreakerOpen},
+		{BreakerOpen, BreakerHalfOpen},
+		{BreakerHalfOpen, BreakerClosed},
+	}, states.get())
+}
+
+func TestPool_BreakerWaitsForResume(t *testing.T) {
+	pool := NewPool(PoolConfig{
+		Workers:   1,
+		QueueSize: 10,
+		Breaker:   &BreakerConfig{MaxConsecutivePanics: 1},
+	}, panickyHandler)
+	pool.Start()
+	defer pool.Stop()
+
+	require.NoError(t, pool.Submit(-1))
+	collect(t, pool, 1)
+	require.True(t, pool.Paused())
+
+	require.NoError(t, pool.Submit(2))
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 1, pool.QueueDepth(), "no cooldown, stays paused")
+
+	pool.Resume()
+	r := collect(t, pool, 1)[0]
+	assert.Equal(t, 2, r.Value)
+	assert.False(t, pool.Paused())
+}
+
+func TestPool_BreakerWithoutCooldownDoesNotBlockShutdown(t *testing.T) {
+	pool := NewPool(PoolConfig{
+		Workers:   1,
+		QueueSize: 10,
+		Breaker:   &BreakerConfig{MaxConsecutivePanics: 1},
+	}, panickyHandler)
+	pool.Start()
+
+	require.NoError(t, pool.Submit(-1))
+	collect(t, pool, 1)
+	require.True(t, pool.Paused())
+
+	require.NoError(t, pool.Submit(2))
+	require.NoError(t, pool.Submit(3))
+
+	done := make(chan struct{})
+	var report ShutdownReport[int]
+	var err error
+	go func() {
+		defer close(done)
+		report, err = pool.Shutdown(context.Background())
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("graceful shutdown waited for a Resume that never comes")
+	}
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{2, 3}, report.NeverStarted)
+	assert.False(t, report.Forced)
+}
+
+func TestPool_BreakerResetsOnSuccess(t *testing.T) {
+	pool := NewPool(PoolConfig{
+		Workers:   1,
+		QueueSize: 10,
+		Breaker:   &BreakerConfig{MaxConsecutivePanics: 2},
+	}, panickyHandler)
+
+	// panics that aren't back to back don't trip the breaker
+	for _, n := range []int{-1, 2, -3, 4, -5} {
+		require.NoError(t, pool.Submit(n))
+	}
+	pool.Start()
+	defer pool.Stop()
+
+	collect(t, pool, 5)
+	assert.False(t, pool.Paused())
+	assert.Equal(t, 3, pool.Restarts())
+}
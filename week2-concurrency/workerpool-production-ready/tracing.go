@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing is an Instrumentation that records an OpenTelemetry span per job
+//
+// The span covers the job from the moment a worker picks it up until its
+// last attempt, it is the parent of anything the handler traces through the
+// context it is given.
+type Tracing struct {
+	NopInstrumentation
+	tracer trace.Tracer
+}
+
+var _ Instrumentation = (*Tracing)(nil)
+
+// creates Tracing that starts its spans with tracer, e.g.
+// otel.Tracer("workerpool")
+func NewTracing(tracer trace.Tracer) *Tracing {
+	return &Tracing{tracer: tracer}
+}
+
+func (t *Tracing) JobStarted(ctx context.Context, job JobInfo, queueWait time.Duration, _ int) context.Context {
+	ctx, _ = t.tracer.Start(ctx, "workerpool.job",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.Int64("job.id", int64(job.ID)),
+			attribute.Int("job.priority", job.Priority),
+			attribute.String("job.tenant", job.Tenant),
+			attribute.Int("worker.id", job.WorkerID),
+			attribute.Float64("job.queue_wait_seconds", queueWait.Seconds()),
+		),
+	)
+	return ctx
+}
+
+func (t *Tracing) JobFinished(ctx context.Context, _ JobInfo, attempts int, _ time.Duration, err error) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int("job.attempts", attempts))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}